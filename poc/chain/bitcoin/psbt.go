@@ -0,0 +1,238 @@
+// Package bitcoin signs Bitcoin transactions with threshold keys.
+package bitcoin
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	tsscommon "github.com/bnb-chain/tss-lib/v2/common"
+	"github.com/btcsuite/btcd/btcec/v2"
+	btcecdsa "github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/keruch/thesis/poc/chain"
+)
+
+// ErrNoInputs is returned when a PSBT has no inputs the key can sign.
+var ErrNoInputs = errors.New("no inputs to sign with the key")
+
+// ScriptType is the type of an output script a key can spend.
+type ScriptType string
+
+const (
+	ScriptTypeP2WPKH ScriptType = "p2wpkh"
+	ScriptTypeP2PKH  ScriptType = "p2pkh"
+)
+
+// InputSighash is the digest the key signs to spend an input of a PSBT.
+type InputSighash struct {
+	Index      int
+	ScriptType ScriptType
+	HashType   txscript.SigHashType
+	Sighash    []byte
+}
+
+// Key is a secp256k1 public key in the encodings Bitcoin scripts use.
+type Key struct {
+	pub *btcec.PublicKey
+}
+
+// NewKey converts a public key of the secp256k1 curve.
+func NewKey(pub *ecdsa.PublicKey) (*Key, error) {
+	if pub == nil || pub.X == nil || pub.Y == nil {
+		return nil, errors.New("empty public key")
+	}
+	parsed, err := btcec.ParsePubKey(elliptic.MarshalCompressed(btcec.S256(), pub.X, pub.Y))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	return &Key{pub: parsed}, nil
+}
+
+// Compressed returns the 33-byte compressed encoding of the key.
+func (k *Key) Compressed() []byte {
+	return k.pub.SerializeCompressed()
+}
+
+// Script returns the output script of the given type paying to the key.
+func (k *Key) Script(scriptType ScriptType) []byte {
+	hash := btcutil.Hash160(k.Compressed())
+	switch scriptType {
+	case ScriptTypeP2WPKH:
+		return append([]byte{txscript.OP_0, txscript.OP_DATA_20}, hash...)
+	case ScriptTypeP2PKH:
+		script := append([]byte{txscript.OP_DUP, txscript.OP_HASH160, txscript.OP_DATA_20}, hash...)
+		return append(script, txscript.OP_EQUALVERIFY, txscript.OP_CHECKSIG)
+	default:
+		return nil
+	}
+}
+
+// Address returns the P2WPKH and the P2PKH address of the key on a network.
+func (k *Key) Address(params *chaincfg.Params) (witness, legacy string, err error) {
+	hash := btcutil.Hash160(k.Compressed())
+	witnessAddr, err := btcutil.NewAddressWitnessPubKeyHash(hash, params)
+	if err != nil {
+		return "", "", err
+	}
+	legacyAddr, err := btcutil.NewAddressPubKeyHash(hash, params)
+	if err != nil {
+		return "", "", err
+	}
+	return witnessAddr.EncodeAddress(), legacyAddr.EncodeAddress(), nil
+}
+
+// ParsePSBT decodes a PSBT given in base64, hex or binary form.
+func ParsePSBT(input []byte) (*psbt.Packet, error) {
+	input = bytes.TrimSpace(input)
+	if !bytes.HasPrefix(input, []byte("psbt\xff")) {
+		raw, err := hex.DecodeString(string(input))
+		if err != nil {
+			if raw, err = base64.StdEncoding.DecodeString(string(input)); err != nil {
+				return nil, errors.New("PSBT is neither base64 nor hex encoded")
+			}
+		}
+		input = raw
+	}
+
+	packet, err := psbt.NewFromRawBytes(bytes.NewReader(input), false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode PSBT: %w", err)
+	}
+	return packet, nil
+}
+
+// Sighashes returns the digests to sign for the inputs of packet spending
+// P2WPKH or P2PKH outputs of the key. P2WPKH inputs are hashed as BIP143
+// describes, P2PKH inputs with the legacy algorithm. Finalized inputs and
+// inputs the key already signed are skipped.
+func Sighashes(packet *psbt.Packet, key *Key) ([]InputSighash, error) {
+	tx := packet.UnsignedTx
+	prevOuts := make(map[wire.OutPoint]*wire.TxOut, len(tx.TxIn))
+	for i, txIn := range tx.TxIn {
+		prevOut, err := prevOutput(packet, i)
+		if err != nil {
+			return nil, err
+		}
+		if prevOut == nil {
+			// Only the scripts of taproot inputs matter to the cached hashes
+			prevOut = &wire.TxOut{}
+		}
+		prevOuts[txIn.PreviousOutPoint] = prevOut
+	}
+	sigHashes := txscript.NewTxSigHashes(tx, txscript.NewMultiPrevOutFetcher(prevOuts))
+
+	var sighashes []InputSighash
+	for i, input := range packet.Inputs {
+		if len(input.FinalScriptSig) > 0 || len(input.FinalScriptWitness) > 0 || signedBy(input, key) {
+			continue
+		}
+		prevOut := prevOuts[tx.TxIn[i].PreviousOutPoint]
+
+		hashType := input.SighashType
+		if hashType == 0 {
+			hashType = txscript.SigHashAll
+		}
+
+		var (
+			scriptType ScriptType
+			sighash    []byte
+			err        error
+		)
+		switch {
+		case bytes.Equal(prevOut.PkScript, key.Script(ScriptTypeP2WPKH)):
+			scriptType = ScriptTypeP2WPKH
+			sighash, err = txscript.CalcWitnessSigHash(prevOut.PkScript, sigHashes, hashType, tx, i, prevOut.Value)
+		case bytes.Equal(prevOut.PkScript, key.Script(ScriptTypeP2PKH)):
+			if input.NonWitnessUtxo == nil {
+				return nil, fmt.Errorf("input %d: P2PKH input without the previous transaction", i)
+			}
+			scriptType = ScriptTypeP2PKH
+			sighash, err = txscript.CalcSignatureHash(prevOut.PkScript, hashType, tx, i)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("input %d: failed to compute sighash: %w", i, err)
+		}
+
+		sighashes = append(sighashes, InputSighash{
+			Index:      i,
+			ScriptType: scriptType,
+			HashType:   hashType,
+			Sighash:    sighash,
+		})
+	}
+	if len(sighashes) == 0 {
+		return nil, ErrNoInputs
+	}
+	return sighashes, nil
+}
+
+// AddSignature inserts a threshold signature of an input's sighash into
+// packet as a partial signature: the low-S DER encoding followed by the
+// sighash type.
+func AddSignature(packet *psbt.Packet, input InputSighash, data *tsscommon.SignatureData, key *Key) error {
+	sig, err := chain.FromTSS(data)
+	if err != nil {
+		return err
+	}
+	sig = sig.Normalize()
+
+	var r, s btcec.ModNScalar
+	r.SetByteSlice(sig.R.Bytes())
+	s.SetByteSlice(sig.S.Bytes())
+	signature := btcecdsa.NewSignature(&r, &s)
+	if !signature.Verify(input.Sighash, key.pub) {
+		return fmt.Errorf("input %d: signature does not verify against the key", input.Index)
+	}
+
+	updater, err := psbt.NewUpdater(packet)
+	if err != nil {
+		return err
+	}
+	der := append(signature.Serialize(), byte(input.HashType))
+	if _, err := updater.Sign(input.Index, der, key.Compressed(), nil, nil); err != nil {
+		return fmt.Errorf("input %d: failed to add signature: %w", input.Index, err)
+	}
+	return nil
+}
+
+// prevOutput returns the output spent by an input, or nil if the PSBT does
+// not describe it.
+func prevOutput(packet *psbt.Packet, index int) (*wire.TxOut, error) {
+	input := packet.Inputs[index]
+	outPoint := packet.UnsignedTx.TxIn[index].PreviousOutPoint
+
+	if input.NonWitnessUtxo != nil {
+		if input.NonWitnessUtxo.TxHash() != outPoint.Hash {
+			return nil, fmt.Errorf("input %d: previous transaction does not match the outpoint", index)
+		}
+		if int(outPoint.Index) >= len(input.NonWitnessUtxo.TxOut) {
+			return nil, fmt.Errorf("input %d: previous transaction has no output %d", index, outPoint.Index)
+		}
+		prevOut := input.NonWitnessUtxo.TxOut[outPoint.Index]
+		if input.WitnessUtxo != nil && !psbt.TxOutsEqual(input.WitnessUtxo, prevOut) {
+			return nil, fmt.Errorf("input %d: witness UTXO does not match the previous transaction", index)
+		}
+		return prevOut, nil
+	}
+	return input.WitnessUtxo, nil
+}
+
+func signedBy(input psbt.PInput, key *Key) bool {
+	for _, partialSig := range input.PartialSigs {
+		if bytes.Equal(partialSig.PubKey, key.Compressed()) {
+			return true
+		}
+	}
+	return false
+}
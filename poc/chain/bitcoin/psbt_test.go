@@ -0,0 +1,219 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	btcecdsa "github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/keruch/thesis/poc/chain/internal/chaintest"
+)
+
+// The regtest fixture spends three outputs of one funding transaction: a
+// P2WPKH and a P2PKH output of the committee key and a P2WPKH output of
+// another key. The P2PKH input is signed with SIGHASH_ALL|ANYONECANPAY.
+const (
+	committeeKey     = "c1bb3894443fd9088c9cad4a3bc91dd0547f81f8a9cafb1ac80eb3943ed57827"
+	otherKey         = "d9298a10d1b0735837dc4bd85dac641b0f3cef27a47e5d53a54f2f3f5b2fcffa"
+	committeeWitness = "bcrt1qtcwple44rqq2mnj9js9wyaznupxc7t7gfuyxgg"
+	committeeLegacy  = "mp6ZWDdNK81M4jbYNWomg1QMDY7PS3NsPm"
+)
+
+func privateKey(t *testing.T, keyHex string) (*btcec.PrivateKey, *Key) {
+	t.Helper()
+
+	raw, err := hex.DecodeString(keyHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, pub := btcec.PrivKeyFromBytes(raw)
+	key, err := NewKey(pub.ToECDSA())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, key
+}
+
+func loadFixture(t *testing.T) *psbt.Packet {
+	t.Helper()
+
+	data, err := os.ReadFile("testdata/regtest_unsigned.psbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := ParsePSBT(data)
+	if err != nil {
+		t.Fatalf("failed to parse fixture: %v", err)
+	}
+	return packet
+}
+
+func TestKeyAddress(t *testing.T) {
+	_, key := privateKey(t, committeeKey)
+
+	witness, legacy, err := key.Address(&chaincfg.RegressionNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if witness != committeeWitness || legacy != committeeLegacy {
+		t.Fatalf("addresses %s and %s, want %s and %s", witness, legacy, committeeWitness, committeeLegacy)
+	}
+}
+
+func TestSignRegtestPSBT(t *testing.T) {
+	packet := loadFixture(t)
+	committeePriv, committee := privateKey(t, committeeKey)
+	otherPriv, other := privateKey(t, otherKey)
+
+	sighashes, err := Sighashes(packet, committee)
+	if err != nil {
+		t.Fatalf("failed to compute sighashes: %v", err)
+	}
+	want := []InputSighash{
+		{Index: 0, ScriptType: ScriptTypeP2WPKH, HashType: txscript.SigHashAll},
+		{Index: 1, ScriptType: ScriptTypeP2PKH, HashType: txscript.SigHashAll | txscript.SigHashAnyOneCanPay},
+	}
+	if len(sighashes) != len(want) {
+		t.Fatalf("got %d inputs to sign, want %d", len(sighashes), len(want))
+	}
+	for i, input := range sighashes {
+		if input.Index != want[i].Index || input.ScriptType != want[i].ScriptType || input.HashType != want[i].HashType {
+			t.Fatalf("input to sign %d is %+v, want %+v", i, input, want[i])
+		}
+
+		// Nodes do not relay signatures with S above N/2 (the low-S rule of
+		// BIP62 and BIP146), so the high-S signature of the P2PKH input must
+		// be finalized low-S
+		data := chaintest.TSSSign(t, committeePriv.ToECDSA(), input.Sighash)
+		if i == 1 {
+			data = chaintest.HighS(data)
+		}
+		if err := AddSignature(packet, input, data, committee); err != nil {
+			t.Fatalf("failed to add signature: %v", err)
+		}
+	}
+
+	for _, input := range sighashes {
+		partialSigs := packet.Inputs[input.Index].PartialSigs
+		if len(partialSigs) != 1 {
+			t.Fatalf("input %d has %d partial signatures, want 1", input.Index, len(partialSigs))
+		}
+		sig := partialSigs[0].Signature
+		if txscript.SigHashType(sig[len(sig)-1]) != input.HashType {
+			t.Fatalf("input %d signature has sighash flag %x, want %x", input.Index, sig[len(sig)-1], input.HashType)
+		}
+		parsed, err := btcecdsa.ParseDERSignature(sig[:len(sig)-1])
+		if err != nil {
+			t.Fatalf("input %d signature is not strict DER: %v", input.Index, err)
+		}
+		if !parsed.Verify(input.Sighash, committee.pub) {
+			t.Fatalf("input %d signature does not verify", input.Index)
+		}
+	}
+	if _, err := Sighashes(packet, committee); !errors.Is(err, ErrNoInputs) {
+		t.Fatalf("expected no inputs left to sign, got %v", err)
+	}
+
+	// The updated PSBT survives encoding and completes with the other key
+	encoded, err := packet.B64Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet, err = ParsePSBT([]byte(encoded)); err != nil {
+		t.Fatalf("failed to parse the signed PSBT: %v", err)
+	}
+	otherSighashes, err := Sighashes(packet, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(otherSighashes) != 1 || otherSighashes[0].Index != 2 {
+		t.Fatalf("unexpected inputs to sign with the other key: %+v", otherSighashes)
+	}
+	if err := AddSignature(packet, otherSighashes[0], chaintest.TSSSign(t, otherPriv.ToECDSA(), otherSighashes[0].Sighash), other); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := psbt.MaybeFinalizeAll(packet); err != nil {
+		t.Fatalf("failed to finalize: %v", err)
+	}
+	tx, err := psbt.Extract(packet)
+	if err != nil {
+		t.Fatalf("failed to extract transaction: %v", err)
+	}
+	verifyScripts(t, packet, tx)
+}
+
+func TestAddSignatureRejectsWrongSighash(t *testing.T) {
+	packet := loadFixture(t)
+	priv, key := privateKey(t, committeeKey)
+
+	sighashes, err := Sighashes(packet, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := chaintest.TSSSign(t, priv.ToECDSA(), sighashes[0].Sighash)
+	if err := AddSignature(packet, sighashes[1], data, key); err == nil {
+		t.Fatal("expected a signature of another input to be rejected")
+	}
+	if len(packet.Inputs[1].PartialSigs) != 0 {
+		t.Fatal("rejected signature was added")
+	}
+}
+
+func TestParsePSBTEncodings(t *testing.T) {
+	packet := loadFixture(t)
+
+	var raw bytes.Buffer
+	if err := packet.Serialize(&raw); err != nil {
+		t.Fatal(err)
+	}
+	for name, input := range map[string][]byte{
+		"binary": raw.Bytes(),
+		"hex":    []byte(hex.EncodeToString(raw.Bytes()) + "\n"),
+	} {
+		parsed, err := ParsePSBT(input)
+		if err != nil {
+			t.Fatalf("failed to parse %s PSBT: %v", name, err)
+		}
+		if parsed.UnsignedTx.TxHash() != packet.UnsignedTx.TxHash() {
+			t.Fatalf("%s PSBT has a different transaction", name)
+		}
+	}
+	if _, err := ParsePSBT([]byte("not a psbt")); err == nil {
+		t.Fatal("expected an error for garbage input")
+	}
+}
+
+// verifyScripts runs the script interpreter on every input of tx.
+func verifyScripts(t *testing.T, packet *psbt.Packet, tx *wire.MsgTx) {
+	t.Helper()
+
+	prevOuts := make(map[wire.OutPoint]*wire.TxOut)
+	for i, txIn := range tx.TxIn {
+		prevOut, err := prevOutput(packet, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prevOuts[txIn.PreviousOutPoint] = prevOut
+	}
+	fetcher := txscript.NewMultiPrevOutFetcher(prevOuts)
+	sigHashes := txscript.NewTxSigHashes(tx, fetcher)
+
+	for i, txIn := range tx.TxIn {
+		prevOut := prevOuts[txIn.PreviousOutPoint]
+		engine, err := txscript.NewEngine(prevOut.PkScript, tx, i, txscript.StandardVerifyFlags, nil, sigHashes, prevOut.Value, fetcher)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := engine.Execute(); err != nil {
+			t.Fatalf("input %d does not verify: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Package cosmos signs Cosmos SDK transactions in SIGN_MODE_DIRECT.
+package cosmos
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	tsscommon "github.com/bnb-chain/tss-lib/v2/common"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"github.com/keruch/thesis/poc/chain"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// DefaultHRP is the human-readable part of Cosmos Hub account addresses.
+const DefaultHRP = "cosmos"
+
+// SignDoc is the cosmos.tx.v1beta1.SignDoc signed in SIGN_MODE_DIRECT.
+type SignDoc struct {
+	BodyBytes     []byte
+	AuthInfoBytes []byte
+	ChainID       string
+	AccountNumber uint64
+}
+
+// signDocJSON is the protobuf JSON mapping of SignDoc. Parsers accept both
+// the lowerCamelCase and the original field names.
+type signDocJSON struct {
+	BodyBytes          []byte          `json:"bodyBytes"`
+	BodyBytesSnake     []byte          `json:"body_bytes"`
+	AuthInfoBytes      []byte          `json:"authInfoBytes"`
+	AuthInfoBytesSnake []byte          `json:"auth_info_bytes"`
+	ChainID            string          `json:"chainId"`
+	ChainIDSnake       string          `json:"chain_id"`
+	AccountNumber      json.RawMessage `json:"accountNumber"`
+	AccountNumberSnake json.RawMessage `json:"account_number"`
+}
+
+// ParseSignDoc decodes a SignDoc from its JSON form as printed by the Cosmos
+// SDK: base64 body and auth info bytes, and the account number as a string
+// or a number.
+func ParseSignDoc(input []byte) (*SignDoc, error) {
+	var doc signDocJSON
+	if err := json.Unmarshal(input, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode sign doc: %w", err)
+	}
+
+	signDoc := &SignDoc{
+		BodyBytes:     firstNonEmpty(doc.BodyBytes, doc.BodyBytesSnake),
+		AuthInfoBytes: firstNonEmpty(doc.AuthInfoBytes, doc.AuthInfoBytesSnake),
+		ChainID:       firstNonEmpty(doc.ChainID, doc.ChainIDSnake),
+	}
+	if len(signDoc.BodyBytes) == 0 {
+		return nil, errors.New("sign doc has no body bytes")
+	}
+	if len(signDoc.AuthInfoBytes) == 0 {
+		return nil, errors.New("sign doc has no auth info bytes")
+	}
+	if signDoc.ChainID == "" {
+		return nil, errors.New("sign doc has no chain ID")
+	}
+
+	accountNumber, err := parseUint64(firstNonEmpty(doc.AccountNumber, doc.AccountNumberSnake))
+	if err != nil {
+		return nil, fmt.Errorf("invalid account number: %w", err)
+	}
+	signDoc.AccountNumber = accountNumber
+	return signDoc, nil
+}
+
+// Bytes returns the protobuf encoding of the sign doc. Fields are written in
+// field number order and default values are omitted, which is the encoding
+// the SDK signs.
+func (d *SignDoc) Bytes() []byte {
+	var out []byte
+	if len(d.BodyBytes) > 0 {
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendBytes(out, d.BodyBytes)
+	}
+	if len(d.AuthInfoBytes) > 0 {
+		out = protowire.AppendTag(out, 2, protowire.BytesType)
+		out = protowire.AppendBytes(out, d.AuthInfoBytes)
+	}
+	if d.ChainID != "" {
+		out = protowire.AppendTag(out, 3, protowire.BytesType)
+		out = protowire.AppendString(out, d.ChainID)
+	}
+	if d.AccountNumber != 0 {
+		out = protowire.AppendTag(out, 4, protowire.VarintType)
+		out = protowire.AppendVarint(out, d.AccountNumber)
+	}
+	return out
+}
+
+// Hash returns the SHA-256 digest of the sign doc the signature covers.
+func (d *SignDoc) Hash() [32]byte {
+	return sha256.Sum256(d.Bytes())
+}
+
+// CompressedPubKey returns the 33-byte compressed public key carried in
+// the signer infos of transactions.
+func CompressedPubKey(pub *ecdsa.PublicKey) []byte {
+	return elliptic.MarshalCompressed(tss.S256(), pub.X, pub.Y)
+}
+
+// Address returns the bech32 account address of pub with the given
+// human-readable part: RIPEMD-160 of SHA-256 of the compressed public key.
+func Address(pub *ecdsa.PublicKey, hrp string) (string, error) {
+	data, err := bech32.ConvertBits(btcutil.Hash160(CompressedPubKey(pub)), 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	address, err := bech32.Encode(hrp, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode address: %w", err)
+	}
+	return address, nil
+}
+
+// Signature turns a threshold signature of a sign doc digest into the
+// 64-byte low-S R || S signature the SDK verifies.
+func Signature(hash [32]byte, data *tsscommon.SignatureData, pub *ecdsa.PublicKey) ([]byte, error) {
+	sig, err := chain.FromTSS(data)
+	if err != nil {
+		return nil, err
+	}
+	sig = sig.Normalize()
+	if !ecdsa.Verify(pub, hash[:], sig.R, sig.S) {
+		return nil, errors.New("signature does not verify against the public key")
+	}
+	return sig.Bytes(), nil
+}
+
+func parseUint64(raw json.RawMessage) (uint64, error) {
+	if len(raw) == 0 {
+		return 0, nil
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		value = string(raw)
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+func firstNonEmpty[T ~[]byte | ~string](values ...T) T {
+	for _, value := range values {
+		if len(value) > 0 {
+			return value
+		}
+	}
+	var zero T
+	return zero
+}
@@ -0,0 +1,130 @@
+package cosmos
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	tsscommon "github.com/bnb-chain/tss-lib/v2/common"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"github.com/keruch/thesis/poc/chain/internal/chaintest"
+)
+
+// The hash160 of the compressed generator point, the public key of the
+// private key 1, as in the examples of BIP173.
+const generatorHash160 = "751e76e8199196d454941c45d1b3a323f1433bd6"
+
+func TestSignDocBytes(t *testing.T) {
+	doc := &SignDoc{
+		BodyBytes:     []byte{0x0a, 0x00},
+		AuthInfoBytes: []byte{0x12, 0x00},
+		ChainID:       "test-1",
+		AccountNumber: 300,
+	}
+	// Fields 1 to 4 with the varint 300 = 0xac 0x02
+	want, _ := hex.DecodeString("0a020a00" + "12021200" + "1a06" + hex.EncodeToString([]byte("test-1")) + "20ac02")
+	if got := doc.Bytes(); !bytes.Equal(got, want) {
+		t.Fatalf("sign doc bytes %x, want %x", got, want)
+	}
+	if doc.Hash() != sha256.Sum256(want) {
+		t.Fatal("sign doc hash is not the SHA-256 of its bytes")
+	}
+
+	// Account number 0 is omitted as protobuf default
+	doc.AccountNumber = 0
+	if got := doc.Bytes(); !bytes.Equal(got, want[:len(want)-3]) {
+		t.Fatalf("sign doc bytes %x, want %x", got, want[:len(want)-3])
+	}
+}
+
+func TestParseSignDoc(t *testing.T) {
+	want := &SignDoc{
+		BodyBytes:     []byte{0x0a, 0x00},
+		AuthInfoBytes: []byte{0x12, 0x00},
+		ChainID:       "test-1",
+		AccountNumber: 300,
+	}
+	for _, input := range []string{
+		`{"bodyBytes":"CgA=","authInfoBytes":"EgA=","chainId":"test-1","accountNumber":"300"}`,
+		`{"body_bytes":"CgA=","auth_info_bytes":"EgA=","chain_id":"test-1","account_number":300}`,
+	} {
+		doc, err := ParseSignDoc([]byte(input))
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", input, err)
+		}
+		if !bytes.Equal(doc.Bytes(), want.Bytes()) {
+			t.Fatalf("parsed %+v, want %+v", doc, want)
+		}
+	}
+
+	for _, input := range []string{
+		`{"authInfoBytes":"EgA=","chainId":"test-1","accountNumber":"1"}`,
+		`{"bodyBytes":"CgA=","authInfoBytes":"EgA=","accountNumber":"1"}`,
+		`{"bodyBytes":"CgA=","authInfoBytes":"EgA=","chainId":"test-1","accountNumber":"-1"}`,
+	} {
+		if _, err := ParseSignDoc([]byte(input)); err == nil {
+			t.Fatalf("expected an error for %s", input)
+		}
+	}
+}
+
+func TestAddress(t *testing.T) {
+	_, pub := btcec.PrivKeyFromBytes([]byte{1})
+
+	for _, hrp := range []string{DefaultHRP, "osmo"} {
+		address, err := Address(pub.ToECDSA(), hrp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotHRP, data, err := bech32.Decode(address)
+		if err != nil {
+			t.Fatalf("invalid bech32 address %s: %v", address, err)
+		}
+		program, err := bech32.ConvertBits(data, 5, 8, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotHRP != hrp || hex.EncodeToString(program) != generatorHash160 {
+			t.Fatalf("address %s decodes to %s and %x, want %s and %s", address, gotHRP, program, hrp, generatorHash160)
+		}
+	}
+}
+
+func TestSignature(t *testing.T) {
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := key.PubKey().ToECDSA()
+	doc := &SignDoc{BodyBytes: []byte{1}, AuthInfoBytes: []byte{2}, ChainID: "test-1", AccountNumber: 7}
+	hash := doc.Hash()
+
+	data := chaintest.TSSSign(t, key.ToECDSA(), hash[:])
+	// The Cosmos SDK secp256k1 verifier only accepts low-S signatures, both
+	// forms must come out as the same 64-byte R || S
+	for _, data := range []*tsscommon.SignatureData{data, chaintest.HighS(data)} {
+		sig, err := Signature(hash, data, pub)
+		if err != nil {
+			t.Fatalf("failed to build signature: %v", err)
+		}
+		if len(sig) != 64 {
+			t.Fatalf("signature has %d bytes, want 64", len(sig))
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if s.Cmp(new(big.Int).Rsh(btcec.S256().N, 1)) > 0 {
+			t.Fatal("signature has high S")
+		}
+		if !ecdsa.Verify(pub, hash[:], r, s) {
+			t.Fatal("signature does not verify")
+		}
+	}
+
+	other, _ := btcec.NewPrivateKey()
+	if _, err := Signature(hash, data, other.PubKey().ToECDSA()); err == nil {
+		t.Fatal("expected an error for a signature of another key")
+	}
+}
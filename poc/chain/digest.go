@@ -0,0 +1,52 @@
+package chain
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+)
+
+// Hash is the hash function turning a message into the digest that is signed.
+type Hash string
+
+const (
+	HashSHA256     Hash = "sha256"
+	HashKeccak256  Hash = "keccak256"
+	HashSHA512_256 Hash = "sha512_256"
+	// HashNone signs the message as given, which must then already be a
+	// digest for ECDSA keys.
+	HashNone Hash = "none"
+)
+
+// Hashes lists the supported hash functions.
+var Hashes = []Hash{HashSHA256, HashKeccak256, HashSHA512_256, HashNone}
+
+// ParseHash returns the hash function named s.
+func ParseHash(s string) (Hash, error) {
+	for _, hash := range Hashes {
+		if string(hash) == s {
+			return hash, nil
+		}
+	}
+	return "", fmt.Errorf("unknown hash function: %q", s)
+}
+
+// Digest hashes message.
+func (h Hash) Digest(message []byte) ([]byte, error) {
+	switch h {
+	case HashSHA256:
+		digest := sha256.Sum256(message)
+		return digest[:], nil
+	case HashKeccak256:
+		return ethcrypto.Keccak256(message), nil
+	case HashSHA512_256:
+		digest := sha512.Sum512_256(message)
+		return digest[:], nil
+	case HashNone:
+		return message, nil
+	default:
+		return nil, fmt.Errorf("unknown hash function: %q", h)
+	}
+}
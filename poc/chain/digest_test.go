@@ -0,0 +1,27 @@
+package chain
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestHashDigest(t *testing.T) {
+	for hash, want := range map[Hash]string{
+		HashSHA256:     "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		HashKeccak256:  "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45",
+		HashSHA512_256: "53048e2681941ef99b2e29b76b4c7dabe4c2d0c634fc6d46e0e2f13107e7af23",
+		HashNone:       "616263",
+	} {
+		digest, err := hash.Digest([]byte("abc"))
+		if err != nil {
+			t.Fatalf("failed to hash with %s: %v", hash, err)
+		}
+		if got := hex.EncodeToString(digest); got != want {
+			t.Fatalf("%s digest of abc is %s, want %s", hash, got, want)
+		}
+	}
+
+	if _, err := ParseHash("md5"); err == nil {
+		t.Fatal("expected an error for an unknown hash function")
+	}
+}
@@ -0,0 +1,227 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	btcecdsa "github.com/btcsuite/btcd/btcec/v2/ecdsa"
+)
+
+// Format is an encoding of a Signature.
+type Format string
+
+const (
+	// FormatDER is the ASN.1 DER SEQUENCE of R and S.
+	FormatDER Format = "der"
+	// FormatCompact is the 64-byte R || S.
+	FormatCompact Format = "compact"
+	// FormatRecoverable is the 65-byte R || S || recovery ID.
+	FormatRecoverable Format = "recoverable"
+	// FormatJSON is a JSON object with hex-encoded R and S and the recovery ID.
+	FormatJSON Format = "json"
+)
+
+// Formats lists the supported signature encodings.
+var Formats = []Format{FormatDER, FormatCompact, FormatRecoverable, FormatJSON}
+
+// ParseFormat returns the signature encoding named s.
+func ParseFormat(s string) (Format, error) {
+	for _, format := range Formats {
+		if string(format) == s {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown signature format: %q", s)
+}
+
+// signatureJSON is the JSON encoding of a signature.
+type signatureJSON struct {
+	R          string `json:"r"`
+	S          string `json:"s"`
+	RecoveryID *byte  `json:"recovery_id,omitempty"`
+}
+
+// derSignature is the ASN.1 structure of a DER signature.
+type derSignature struct {
+	R, S *big.Int
+}
+
+// DER returns the ASN.1 DER encoding of the signature.
+func (sig Signature) DER() []byte {
+	// Marshalling positive integers cannot fail
+	out, _ := asn1.Marshal(derSignature{R: sig.R, S: sig.S})
+	return out
+}
+
+// Recoverable returns the 65-byte R || S || recovery ID encoding.
+func (sig Signature) Recoverable() []byte {
+	return append(sig.Bytes(), sig.RecoveryID)
+}
+
+// Encode returns the signature in the given encoding.
+func (sig Signature) Encode(format Format) ([]byte, error) {
+	switch format {
+	case FormatDER:
+		return sig.DER(), nil
+	case FormatCompact:
+		return sig.Bytes(), nil
+	case FormatRecoverable:
+		return sig.Recoverable(), nil
+	case FormatJSON:
+		compact := sig.Bytes()
+		recoveryID := sig.RecoveryID
+		return json.Marshal(signatureJSON{
+			R:          hex.EncodeToString(compact[:32]),
+			S:          hex.EncodeToString(compact[32:]),
+			RecoveryID: &recoveryID,
+		})
+	default:
+		return nil, fmt.Errorf("unknown signature format: %q", format)
+	}
+}
+
+// Verify reports whether the signature of digest verifies against pub. Both
+// low and high S are accepted.
+func (sig Signature) Verify(pub *ecdsa.PublicKey, digest []byte) bool {
+	return ecdsa.Verify(pub, digest, sig.R, sig.S)
+}
+
+// RecoverPublicKey returns the public key that signed digest, found with the
+// recovery ID.
+func (sig Signature) RecoverPublicKey(digest []byte) (*ecdsa.PublicKey, error) {
+	if sig.RecoveryID > 3 {
+		return nil, fmt.Errorf("invalid recovery ID: %d", sig.RecoveryID)
+	}
+	// RecoverCompact takes the recovery ID in a Bitcoin message signature header
+	compact := append([]byte{27 + sig.RecoveryID}, sig.Bytes()...)
+	pub, _, err := btcecdsa.RecoverCompact(compact, digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recover public key: %w", err)
+	}
+	return pub.ToECDSA(), nil
+}
+
+// WithRecoveryID returns the signature with the recovery ID under which the
+// signature of digest recovers to pub.
+func (sig Signature) WithRecoveryID(pub *ecdsa.PublicKey, digest []byte) (Signature, error) {
+	for recoveryID := byte(0); recoveryID <= 3; recoveryID++ {
+		sig.RecoveryID = recoveryID
+		if recovered, err := sig.RecoverPublicKey(digest); err == nil && recovered.X.Cmp(pub.X) == 0 && recovered.Y.Cmp(pub.Y) == 0 {
+			return sig, nil
+		}
+	}
+	return Signature{}, errors.New("signature does not recover to the public key")
+}
+
+// ParsedSignature is a signature decoded by ParseSignature. Recoverable is set
+// if the encoding carried the recovery ID.
+type ParsedSignature struct {
+	Signature
+	Format      Format
+	Recoverable bool
+}
+
+// ParseSignature decodes a signature given as JSON or as hex in DER, compact or
+// recoverable encoding. The recovery ID of a recoverable signature may also be
+// given as 27 to 30, as Ethereum and Bitcoin message signatures do.
+func ParseSignature(input []byte) (*ParsedSignature, error) {
+	input = bytes.TrimSpace(input)
+	if bytes.HasPrefix(input, []byte("{")) {
+		return parseSignatureJSON(input)
+	}
+
+	raw, err := hex.DecodeString(strings.TrimPrefix(string(input), "0x"))
+	if err != nil {
+		return nil, errors.New("signature is neither JSON nor hex encoded")
+	}
+
+	parsed := &ParsedSignature{}
+	switch {
+	case len(raw) > 0 && raw[0] == 0x30 && isDER(raw):
+		var der derSignature
+		// isDER checked that raw decodes
+		asn1.Unmarshal(raw, &der)
+		parsed.Signature = Signature{R: der.R, S: der.S}
+		parsed.Format = FormatDER
+	case len(raw) == 64:
+		parsed.Signature = Signature{R: new(big.Int).SetBytes(raw[:32]), S: new(big.Int).SetBytes(raw[32:])}
+		parsed.Format = FormatCompact
+	case len(raw) == 65:
+		recoveryID := raw[64]
+		if recoveryID >= 27 {
+			recoveryID -= 27
+		}
+		if recoveryID > 3 {
+			return nil, fmt.Errorf("invalid recovery ID: %d", raw[64])
+		}
+		parsed.Signature = Signature{R: new(big.Int).SetBytes(raw[:32]), S: new(big.Int).SetBytes(raw[32:64]), RecoveryID: recoveryID}
+		parsed.Format = FormatRecoverable
+		parsed.Recoverable = true
+	default:
+		return nil, fmt.Errorf("signature of %d bytes is neither DER, compact nor recoverable", len(raw))
+	}
+
+	if err := parsed.checkRange(); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+func parseSignatureJSON(input []byte) (*ParsedSignature, error) {
+	var encoded signatureJSON
+	if err := json.Unmarshal(input, &encoded); err != nil {
+		return nil, fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	parsed := &ParsedSignature{Format: FormatJSON}
+	for name, value := range map[string]struct {
+		hex string
+		out **big.Int
+	}{
+		"r": {encoded.R, &parsed.R},
+		"s": {encoded.S, &parsed.S},
+	} {
+		raw, err := hex.DecodeString(strings.TrimPrefix(value.hex, "0x"))
+		if err != nil || len(raw) == 0 || len(raw) > 32 {
+			return nil, fmt.Errorf("invalid %s: %q", name, value.hex)
+		}
+		*value.out = new(big.Int).SetBytes(raw)
+	}
+	if encoded.RecoveryID != nil {
+		if *encoded.RecoveryID > 3 {
+			return nil, fmt.Errorf("invalid recovery ID: %d", *encoded.RecoveryID)
+		}
+		parsed.RecoveryID = *encoded.RecoveryID
+		parsed.Recoverable = true
+	}
+
+	if err := parsed.checkRange(); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// isDER reports whether raw is a strictly DER-encoded signature.
+func isDER(raw []byte) bool {
+	var der derSignature
+	rest, err := asn1.Unmarshal(raw, &der)
+	if err != nil || len(rest) > 0 || der.R.Sign() <= 0 || der.S.Sign() <= 0 {
+		return false
+	}
+	// encoding/asn1 accepts some BER, re-encoding rules it out
+	return bytes.Equal(Signature{R: der.R, S: der.S}.DER(), raw)
+}
+
+func (sig Signature) checkRange() error {
+	if sig.R.Sign() == 0 || sig.R.Cmp(secp256k1N) >= 0 || sig.S.Sign() == 0 || sig.S.Cmp(secp256k1N) >= 0 {
+		return errors.New("signature values out of range")
+	}
+	return nil
+}
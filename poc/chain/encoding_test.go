@@ -0,0 +1,139 @@
+package chain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	btcecdsa "github.com/btcsuite/btcd/btcec/v2/ecdsa"
+)
+
+// signDigest signs digest and returns the low-S signature with its recovery ID.
+func signDigest(t *testing.T, digest []byte) (*btcec.PrivateKey, Signature) {
+	t.Helper()
+
+	priv, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	compact, err := btcecdsa.SignCompact(priv, digest, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, Signature{
+		R:          new(big.Int).SetBytes(compact[1:33]),
+		S:          new(big.Int).SetBytes(compact[33:]),
+		RecoveryID: compact[0] - 27,
+	}
+}
+
+func TestSignatureEncodings(t *testing.T) {
+	digest := sha256.Sum256([]byte("signature encodings"))
+	priv, sig := signDigest(t, digest[:])
+
+	der, err := btcecdsa.ParseDERSignature(sig.DER())
+	if err != nil {
+		t.Fatalf("btcec rejects the DER signature: %v", err)
+	}
+	if !der.Verify(digest[:], priv.PubKey()) {
+		t.Fatal("DER signature does not verify with btcec")
+	}
+
+	for _, format := range Formats {
+		encoded, err := sig.Encode(format)
+		if err != nil {
+			t.Fatalf("failed to encode %s: %v", format, err)
+		}
+		if format != FormatJSON {
+			encoded = []byte(hex.EncodeToString(encoded))
+		}
+
+		parsed, err := ParseSignature(encoded)
+		if err != nil {
+			t.Fatalf("failed to parse %s signature %s: %v", format, encoded, err)
+		}
+		if parsed.Format != format {
+			t.Fatalf("%s signature parsed as %s", format, parsed.Format)
+		}
+		if parsed.R.Cmp(sig.R) != 0 || parsed.S.Cmp(sig.S) != 0 {
+			t.Fatalf("%s signature parsed to other values", format)
+		}
+		wantRecoverable := format == FormatRecoverable || format == FormatJSON
+		if parsed.Recoverable != wantRecoverable || (wantRecoverable && parsed.RecoveryID != sig.RecoveryID) {
+			t.Fatalf("%s signature parsed with recoverable %t and recovery ID %d", format, parsed.Recoverable, parsed.RecoveryID)
+		}
+		if !parsed.Verify(priv.PubKey().ToECDSA(), digest[:]) {
+			t.Fatalf("parsed %s signature does not verify", format)
+		}
+	}
+
+	ethereumStyle := append(sig.Bytes(), 27+sig.RecoveryID)
+	parsed, err := ParseSignature([]byte("0x" + hex.EncodeToString(ethereumStyle)))
+	if err != nil || parsed.RecoveryID != sig.RecoveryID {
+		t.Fatalf("failed to parse a signature with v = %d: %v", ethereumStyle[64], err)
+	}
+}
+
+func TestRecoverPublicKey(t *testing.T) {
+	digest := sha256.Sum256([]byte("public key recovery"))
+	priv, sig := signDigest(t, digest[:])
+	want := priv.PubKey().ToECDSA()
+
+	// A malleated high-S signature recovers the same key with the other parity
+	highS := Signature{R: sig.R, S: new(big.Int).Sub(secp256k1N, sig.S), RecoveryID: sig.RecoveryID ^ 1}
+	if highS.IsLowS() {
+		t.Fatal("malleated signature is low-S")
+	}
+	if norm := highS.Normalize(); norm.S.Cmp(sig.S) != 0 || norm.RecoveryID != sig.RecoveryID {
+		t.Fatal("normalizing the malleated signature does not restore the original")
+	}
+
+	for name, s := range map[string]Signature{"low-S": sig, "high-S": highS} {
+		pub, err := s.RecoverPublicKey(digest[:])
+		if err != nil {
+			t.Fatalf("failed to recover %s public key: %v", name, err)
+		}
+		if !pub.Equal(want) {
+			t.Fatalf("%s signature recovers another public key", name)
+		}
+		if !s.Verify(pub, digest[:]) {
+			t.Fatalf("%s signature does not verify", name)
+		}
+	}
+
+	if found, err := (Signature{R: sig.R, S: sig.S}).WithRecoveryID(want, digest[:]); err != nil || found.RecoveryID != sig.RecoveryID {
+		t.Fatalf("found recovery ID %d (%v), want %d", found.RecoveryID, err, sig.RecoveryID)
+	}
+
+	wrong := sig
+	wrong.RecoveryID ^= 1
+	if pub, err := wrong.RecoverPublicKey(digest[:]); err == nil && pub.X.Cmp(priv.PubKey().X()) == 0 {
+		t.Fatal("wrong recovery ID recovers the signing key")
+	}
+}
+
+func TestParseSignatureErrors(t *testing.T) {
+	digest := sha256.Sum256([]byte("invalid signatures"))
+	_, sig := signDigest(t, digest[:])
+	compact := sig.Bytes()
+
+	// A DER signature with a non-minimal length encoding
+	der := sig.DER()
+	ber := append([]byte{0x30, 0x81, der[1]}, der[2:]...)
+
+	for name, input := range map[string]string{
+		"BER":              hex.EncodeToString(ber),
+		"short":            hex.EncodeToString(compact[:63]),
+		"zero R":           hex.EncodeToString(append(make([]byte, 32), compact[32:]...)),
+		"S over the order": hex.EncodeToString(append(sig.R.FillBytes(make([]byte, 32)), secp256k1N.Bytes()...)),
+		"recovery ID 31":   hex.EncodeToString(append(compact, 31)),
+		"not hex":          "signature",
+		"JSON without s":   `{"r":"01"}`,
+	} {
+		if _, err := ParseSignature([]byte(input)); err == nil {
+			t.Fatalf("expected an error for a %s signature", name)
+		}
+	}
+}
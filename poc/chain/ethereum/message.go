@@ -0,0 +1,91 @@
+package ethereum
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	tsscommon "github.com/bnb-chain/tss-lib/v2/common"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/keruch/thesis/poc/chain"
+)
+
+// MessageSignatureLength is the length of an R || S || V message signature.
+const MessageSignatureLength = 65
+
+// PersonalMessageHash returns the EIP-191 digest signed by personal_sign:
+// the hash of message prefixed with "\x19Ethereum Signed Message:\n" and
+// its length.
+func PersonalMessageHash(message []byte) common.Hash {
+	return common.BytesToHash(accounts.TextHash(message))
+}
+
+// TypedDataHash returns the EIP-712 digest of a typed data JSON document as
+// accepted by eth_signTypedData_v4.
+func TypedDataHash(document []byte) (common.Hash, error) {
+	var typedData apitypes.TypedData
+	if err := json.Unmarshal(document, &typedData); err != nil {
+		return common.Hash{}, fmt.Errorf("failed to decode typed data: %w", err)
+	}
+
+	hash, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to hash typed data: %w", err)
+	}
+	return common.BytesToHash(hash), nil
+}
+
+// MessageSignature turns a threshold signature of hash into the 65-byte
+// signature returned by personal_sign and eth_signTypedData: R || S || V with
+// low S and V 27 or 28. The signature must recover to the address of pub.
+func MessageSignature(hash common.Hash, data *tsscommon.SignatureData, pub *ecdsa.PublicKey) ([]byte, error) {
+	sig, err := chain.FromTSS(data)
+	if err != nil {
+		return nil, err
+	}
+	sig = sig.Normalize()
+	if sig.RecoveryID > 1 {
+		return nil, fmt.Errorf("unsupported recovery ID: %d", sig.RecoveryID)
+	}
+
+	out := append(sig.Bytes(), 27+sig.RecoveryID)
+	if err := VerifyMessageSignature(hash, out, Address(pub)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// VerifyMessageSignature checks that a 65-byte message signature of hash
+// recovers to address. V may be 27 or 28, or the bare recovery ID 0 or 1.
+// High-S signatures are rejected, as most contracts checking them do.
+func VerifyMessageSignature(hash common.Hash, sig []byte, address common.Address) error {
+	if len(sig) != MessageSignatureLength {
+		return fmt.Errorf("invalid signature length: %d, want %d", len(sig), MessageSignatureLength)
+	}
+
+	rsv := make([]byte, MessageSignatureLength)
+	copy(rsv, sig)
+	if rsv[64] >= 27 {
+		rsv[64] -= 27
+	}
+	if rsv[64] > 1 {
+		return fmt.Errorf("invalid signature V: %d", sig[64])
+	}
+	if !crypto.ValidateSignatureValues(rsv[64], new(big.Int).SetBytes(rsv[:32]), new(big.Int).SetBytes(rsv[32:64]), true) {
+		return errors.New("invalid signature values: R or S out of range or S not low")
+	}
+
+	pub, err := crypto.SigToPub(hash[:], rsv)
+	if err != nil {
+		return fmt.Errorf("failed to recover public key: %w", err)
+	}
+	if recovered := crypto.PubkeyToAddress(*pub); recovered != address {
+		return fmt.Errorf("signature recovers to %s instead of %s", recovered, address)
+	}
+	return nil
+}
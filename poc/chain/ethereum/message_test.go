@@ -0,0 +1,123 @@
+package ethereum
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/keruch/thesis/poc/chain/internal/chaintest"
+)
+
+// The example of EIP-712, signed by the key keccak256("cow").
+const (
+	eip712Mail = `{
+  "types": {
+    "EIP712Domain": [
+      {"name": "name", "type": "string"},
+      {"name": "version", "type": "string"},
+      {"name": "chainId", "type": "uint256"},
+      {"name": "verifyingContract", "type": "address"}
+    ],
+    "Person": [
+      {"name": "name", "type": "string"},
+      {"name": "wallet", "type": "address"}
+    ],
+    "Mail": [
+      {"name": "from", "type": "Person"},
+      {"name": "to", "type": "Person"},
+      {"name": "contents", "type": "string"}
+    ]
+  },
+  "primaryType": "Mail",
+  "domain": {
+    "name": "Ether Mail",
+    "version": "1",
+    "chainId": 1,
+    "verifyingContract": "0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC"
+  },
+  "message": {
+    "from": {"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},
+    "to": {"name": "Bob", "wallet": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"},
+    "contents": "Hello, Bob!"
+  }
+}`
+	eip712Hash      = "0xbe609aee343fb3c4b28e1df9e632fca64fcfaede20f02e86244efddf30957bd2"
+	eip712Signature = "0x4355c47d63924e8a72e509b65029052eb6c299d53a04e167c5775fd466751c9d07299936d304c153f6443dfa05f40ff007d72911b6f72307f996231605b915621c"
+)
+
+func TestTypedDataExample(t *testing.T) {
+	key, err := crypto.ToECDSA(crypto.Keccak256([]byte("cow")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := TypedDataHash([]byte(eip712Mail))
+	if err != nil {
+		t.Fatalf("failed to hash typed data: %v", err)
+	}
+	if hash.Hex() != eip712Hash {
+		t.Fatalf("typed data hash %s, want %s", hash.Hex(), eip712Hash)
+	}
+
+	sig, err := MessageSignature(hash, chaintest.HighS(chaintest.TSSSign(t, key, hash[:])), &key.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to build signature: %v", err)
+	}
+	if got := hexutil.Encode(sig); got != eip712Signature {
+		t.Fatalf("signature\n got %s\nwant %s", got, eip712Signature)
+	}
+}
+
+func TestPersonalMessage(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := crypto.PubkeyToAddress(key.PublicKey)
+
+	hash := PersonalMessageHash([]byte("Hello, world!"))
+	if want := crypto.Keccak256Hash([]byte("\x19Ethereum Signed Message:\n13Hello, world!")); hash != want {
+		t.Fatalf("message hash %s, want %s", hash, want)
+	}
+
+	sig, err := MessageSignature(hash, chaintest.TSSSign(t, key, hash[:]), &key.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to build signature: %v", err)
+	}
+	if v := sig[64]; v != 27 && v != 28 {
+		t.Fatalf("unexpected V: %d", v)
+	}
+	if err := VerifyMessageSignature(hash, sig, address); err != nil {
+		t.Fatalf("signature does not verify: %v", err)
+	}
+
+	// V as bare recovery ID
+	bare := append([]byte(nil), sig...)
+	bare[64] -= 27
+	if err := VerifyMessageSignature(hash, bare, address); err != nil {
+		t.Fatalf("signature with bare recovery ID does not verify: %v", err)
+	}
+
+	other := PersonalMessageHash([]byte("Hello, world?"))
+	if err := VerifyMessageSignature(other, sig, address); err == nil {
+		t.Fatal("signature verified for another message")
+	}
+	if err := VerifyMessageSignature(hash, sig, common.Address{1}); err == nil {
+		t.Fatal("signature verified for another address")
+	}
+}
+
+func TestVerifyMessageSignatureRejectsHighS(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := PersonalMessageHash([]byte("malleable"))
+	data := chaintest.HighS(chaintest.TSSSign(t, key, hash[:]))
+
+	sig := append(append(append([]byte(nil), data.R...), data.S...), 27+data.SignatureRecovery[0])
+	if err := VerifyMessageSignature(hash, sig, crypto.PubkeyToAddress(key.PublicKey)); err == nil {
+		t.Fatal("expected a high-S signature to be rejected")
+	}
+}
@@ -0,0 +1,310 @@
+// Package ethereum signs Ethereum transactions with threshold signatures.
+package ethereum
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	tsscommon "github.com/bnb-chain/tss-lib/v2/common"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/keruch/thesis/poc/chain"
+)
+
+// txJSON is an unsigned transaction in the format of eth_signTransaction.
+// The type defaults to a legacy transaction, or to EIP-1559 when fee caps
+// are given.
+type txJSON struct {
+	Type                 *hexutil.Uint64   `json:"type"`
+	ChainID              *hexutil.Big      `json:"chainId"`
+	Nonce                hexutil.Uint64    `json:"nonce"`
+	To                   *common.Address   `json:"to"`
+	Gas                  hexutil.Uint64    `json:"gas"`
+	GasPrice             *hexutil.Big      `json:"gasPrice"`
+	MaxPriorityFeePerGas *hexutil.Big      `json:"maxPriorityFeePerGas"`
+	MaxFeePerGas         *hexutil.Big      `json:"maxFeePerGas"`
+	Value                *hexutil.Big      `json:"value"`
+	Input                *hexutil.Bytes    `json:"input"`
+	Data                 *hexutil.Bytes    `json:"data"`
+	AccessList           *types.AccessList `json:"accessList"`
+}
+
+// The RLP encodings of unsigned transactions, i.e. of their signing payloads.
+type (
+	unsignedLegacyTx struct {
+		Nonce    uint64
+		GasPrice *big.Int
+		Gas      uint64
+		To       *common.Address `rlp:"nil"`
+		Value    *big.Int
+		Data     []byte
+		// EIP-155 transactions sign chainId, 0, 0 in place of the signature
+		ChainID *big.Int `rlp:"optional"`
+		Zero1   uint     `rlp:"optional"`
+		Zero2   uint     `rlp:"optional"`
+	}
+
+	unsignedAccessListTx struct {
+		ChainID    *big.Int
+		Nonce      uint64
+		GasPrice   *big.Int
+		Gas        uint64
+		To         *common.Address `rlp:"nil"`
+		Value      *big.Int
+		Data       []byte
+		AccessList types.AccessList
+	}
+
+	unsignedDynamicFeeTx struct {
+		ChainID    *big.Int
+		Nonce      uint64
+		GasTipCap  *big.Int
+		GasFeeCap  *big.Int
+		Gas        uint64
+		To         *common.Address `rlp:"nil"`
+		Value      *big.Int
+		Data       []byte
+		AccessList types.AccessList
+	}
+)
+
+// ParseTransaction decodes an unsigned legacy, EIP-2930 or EIP-1559
+// transaction for chainID, given as JSON or as hex-encoded RLP of its signing
+// payload. A chain ID in the transaction must match chainID.
+func ParseTransaction(input []byte, chainID *big.Int) (*types.Transaction, error) {
+	if chainID == nil || chainID.Sign() <= 0 {
+		return nil, errors.New("chain ID must be positive")
+	}
+
+	input = bytes.TrimSpace(input)
+	var (
+		tx  *types.Transaction
+		err error
+	)
+	if bytes.HasPrefix(input, []byte("{")) {
+		tx, err = parseJSON(input, chainID)
+	} else {
+		tx, err = parseRLP(input, chainID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Legacy transactions report a chain ID derived from their signature
+	if tx.Type() != types.LegacyTxType && tx.ChainId().Cmp(chainID) != 0 {
+		return nil, fmt.Errorf("transaction chain ID %s does not match %s", tx.ChainId(), chainID)
+	}
+	return tx, nil
+}
+
+func parseJSON(input []byte, chainID *big.Int) (*types.Transaction, error) {
+	var dec txJSON
+	if err := json.Unmarshal(input, &dec); err != nil {
+		return nil, fmt.Errorf("failed to decode transaction JSON: %w", err)
+	}
+
+	if dec.ChainID != nil && dec.ChainID.ToInt().Cmp(chainID) != 0 {
+		return nil, fmt.Errorf("transaction chain ID %s does not match %s", dec.ChainID.ToInt(), chainID)
+	}
+	data := dec.Input
+	if data == nil {
+		data = dec.Data
+	}
+	var payload []byte
+	if data != nil {
+		payload = *data
+	}
+	var accessList types.AccessList
+	if dec.AccessList != nil {
+		accessList = *dec.AccessList
+	}
+
+	txType := uint64(types.LegacyTxType)
+	switch {
+	case dec.Type != nil:
+		txType = uint64(*dec.Type)
+	case dec.MaxFeePerGas != nil:
+		txType = types.DynamicFeeTxType
+	case dec.AccessList != nil:
+		txType = types.AccessListTxType
+	}
+
+	switch txType {
+	case types.LegacyTxType:
+		if dec.GasPrice == nil {
+			return nil, errors.New("missing gasPrice")
+		}
+		if dec.AccessList != nil {
+			return nil, errors.New("legacy transactions have no access list")
+		}
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    uint64(dec.Nonce),
+			GasPrice: dec.GasPrice.ToInt(),
+			Gas:      uint64(dec.Gas),
+			To:       dec.To,
+			Value:    bigOrZero(dec.Value),
+			Data:     payload,
+		}), nil
+
+	case types.AccessListTxType:
+		if dec.GasPrice == nil {
+			return nil, errors.New("missing gasPrice")
+		}
+		return types.NewTx(&types.AccessListTx{
+			ChainID:    chainID,
+			Nonce:      uint64(dec.Nonce),
+			GasPrice:   dec.GasPrice.ToInt(),
+			Gas:        uint64(dec.Gas),
+			To:         dec.To,
+			Value:      bigOrZero(dec.Value),
+			Data:       payload,
+			AccessList: accessList,
+		}), nil
+
+	case types.DynamicFeeTxType:
+		if dec.MaxFeePerGas == nil || dec.MaxPriorityFeePerGas == nil {
+			return nil, errors.New("missing maxFeePerGas or maxPriorityFeePerGas")
+		}
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID:    chainID,
+			Nonce:      uint64(dec.Nonce),
+			GasTipCap:  dec.MaxPriorityFeePerGas.ToInt(),
+			GasFeeCap:  dec.MaxFeePerGas.ToInt(),
+			Gas:        uint64(dec.Gas),
+			To:         dec.To,
+			Value:      bigOrZero(dec.Value),
+			Data:       payload,
+			AccessList: accessList,
+		}), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported transaction type: %d", txType)
+	}
+}
+
+func parseRLP(input []byte, chainID *big.Int) (*types.Transaction, error) {
+	raw, err := hexutil.Decode("0x" + strings.TrimPrefix(string(input), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode transaction hex: %w", err)
+	}
+	if len(raw) == 0 {
+		return nil, errors.New("empty transaction")
+	}
+
+	switch raw[0] {
+	case types.AccessListTxType:
+		var dec unsignedAccessListTx
+		if err := rlp.DecodeBytes(raw[1:], &dec); err != nil {
+			return nil, fmt.Errorf("failed to decode EIP-2930 transaction: %w", err)
+		}
+		return types.NewTx(&types.AccessListTx{
+			ChainID:    dec.ChainID,
+			Nonce:      dec.Nonce,
+			GasPrice:   dec.GasPrice,
+			Gas:        dec.Gas,
+			To:         dec.To,
+			Value:      dec.Value,
+			Data:       dec.Data,
+			AccessList: dec.AccessList,
+		}), nil
+
+	case types.DynamicFeeTxType:
+		var dec unsignedDynamicFeeTx
+		if err := rlp.DecodeBytes(raw[1:], &dec); err != nil {
+			return nil, fmt.Errorf("failed to decode EIP-1559 transaction: %w", err)
+		}
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID:    dec.ChainID,
+			Nonce:      dec.Nonce,
+			GasTipCap:  dec.GasTipCap,
+			GasFeeCap:  dec.GasFeeCap,
+			Gas:        dec.Gas,
+			To:         dec.To,
+			Value:      dec.Value,
+			Data:       dec.Data,
+			AccessList: dec.AccessList,
+		}), nil
+	}
+
+	if raw[0] < 0xc0 {
+		return nil, fmt.Errorf("unsupported transaction type: %d", raw[0])
+	}
+	var dec unsignedLegacyTx
+	if err := rlp.DecodeBytes(raw, &dec); err != nil {
+		return nil, fmt.Errorf("failed to decode legacy transaction: %w", err)
+	}
+	if dec.ChainID != nil && (dec.ChainID.Cmp(chainID) != 0 || dec.Zero1 != 0 || dec.Zero2 != 0) {
+		return nil, fmt.Errorf("transaction chain ID %s does not match %s", dec.ChainID, chainID)
+	}
+	return types.NewTx(&types.LegacyTx{
+		Nonce:    dec.Nonce,
+		GasPrice: dec.GasPrice,
+		Gas:      dec.Gas,
+		To:       dec.To,
+		Value:    dec.Value,
+		Data:     dec.Data,
+	}), nil
+}
+
+func bigOrZero(b *hexutil.Big) *big.Int {
+	if b == nil {
+		return new(big.Int)
+	}
+	return b.ToInt()
+}
+
+// Signer returns the signer of transactions on chainID. Legacy transactions
+// are signed with EIP-155 replay protection.
+func Signer(chainID *big.Int) types.Signer {
+	return types.LatestSignerForChainID(chainID)
+}
+
+// SigningHash returns the digest to sign for tx on chainID.
+func SigningHash(tx *types.Transaction, chainID *big.Int) common.Hash {
+	return Signer(chainID).Hash(tx)
+}
+
+// Address returns the Ethereum address of a public key.
+func Address(pub *ecdsa.PublicKey) common.Address {
+	return crypto.PubkeyToAddress(*pub)
+}
+
+// SignTransaction attaches a threshold signature of the signing hash to tx.
+// The signature is normalized to low S, its recovery ID becomes v or
+// yParity, and the sender recovered from the result must be the address of
+// pub, the public key of the signing committee.
+func SignTransaction(tx *types.Transaction, chainID *big.Int, data *tsscommon.SignatureData, pub *ecdsa.PublicKey) (*types.Transaction, error) {
+	sig, err := chain.FromTSS(data)
+	if err != nil {
+		return nil, err
+	}
+	sig = sig.Normalize()
+	// The x coordinate of R overflowing the order has negligible probability
+	// and can't be expressed in v
+	if sig.RecoveryID > 1 {
+		return nil, fmt.Errorf("unsupported recovery ID: %d", sig.RecoveryID)
+	}
+
+	signer := Signer(chainID)
+	signed, err := tx.WithSignature(signer, append(sig.Bytes(), sig.RecoveryID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach signature: %w", err)
+	}
+
+	sender, err := types.Sender(signer, signed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recover sender: %w", err)
+	}
+	if want := Address(pub); sender != want {
+		return nil, fmt.Errorf("signature recovers to %s instead of %s", sender, want)
+	}
+	return signed, nil
+}
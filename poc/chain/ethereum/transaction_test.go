@@ -0,0 +1,166 @@
+package ethereum
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/keruch/thesis/poc/chain/internal/chaintest"
+)
+
+// The example transaction of EIP-155.
+const (
+	eip155Key         = "4646464646464646464646464646464646464646464646464646464646464646"
+	eip155SigningData = "0xec098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a764000080018080"
+	eip155SigningHash = "0xdaf5a779ae972f972197303d7b574746c7ef83eadac0f2791ad23db92e4c8e53"
+	eip155SignedTx    = "0xf86c098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83"
+	eip155JSON        = `{"nonce":"0x9","gasPrice":"0x4a817c800","gas":"0x5208","to":"0x3535353535353535353535353535353535353535","value":"0xde0b6b3a7640000","input":"0x"}`
+)
+
+func TestSignEIP155Example(t *testing.T) {
+	key, err := crypto.HexToECDSA(eip155Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainID := big.NewInt(1)
+
+	for name, input := range map[string]string{"rlp": eip155SigningData, "json": eip155JSON} {
+		t.Run(name, func(t *testing.T) {
+			tx, err := ParseTransaction([]byte(input), chainID)
+			if err != nil {
+				t.Fatalf("failed to parse transaction: %v", err)
+			}
+			hash := SigningHash(tx, chainID)
+			if hash.Hex() != eip155SigningHash {
+				t.Fatalf("signing hash %s, want %s", hash.Hex(), eip155SigningHash)
+			}
+
+			// Since EIP-2 nodes reject transactions with S above N/2, a high-S
+			// signature must come out low-S with the recovery id flipped
+			signed, err := SignTransaction(tx, chainID, chaintest.HighS(chaintest.TSSSign(t, key, hash[:])), &key.PublicKey)
+			if err != nil {
+				t.Fatalf("failed to sign transaction: %v", err)
+			}
+			raw, err := signed.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hexutil.Encode(raw); got != eip155SignedTx {
+				t.Fatalf("signed transaction\n got %s\nwant %s", got, eip155SignedTx)
+			}
+		})
+	}
+}
+
+func TestSignTypedTransactions(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainID := big.NewInt(11155111)
+	to := common.HexToAddress("0x3535353535353535353535353535353535353535")
+	accessList := types.AccessList{{Address: to, StorageKeys: []common.Hash{{1}}}}
+
+	tests := []struct {
+		name    string
+		txType  byte
+		payload any
+		json    string
+	}{
+		{
+			name:   "eip2930",
+			txType: types.AccessListTxType,
+			payload: unsignedAccessListTx{
+				ChainID: chainID, Nonce: 3, GasPrice: big.NewInt(1e9), Gas: 50000,
+				To: &to, Value: big.NewInt(7), Data: []byte{0xde, 0xad}, AccessList: accessList,
+			},
+			json: `{"type":"0x1","chainId":"0xaa36a7","nonce":"0x3","gasPrice":"0x3b9aca00","gas":"0xc350","to":"0x3535353535353535353535353535353535353535","value":"0x7","input":"0xdead",
+				"accessList":[{"address":"0x3535353535353535353535353535353535353535","storageKeys":["0x0100000000000000000000000000000000000000000000000000000000000000"]}]}`,
+		},
+		{
+			name:   "eip1559",
+			txType: types.DynamicFeeTxType,
+			payload: unsignedDynamicFeeTx{
+				ChainID: chainID, Nonce: 3, GasTipCap: big.NewInt(2e9), GasFeeCap: big.NewInt(3e10), Gas: 50000,
+				To: &to, Value: big.NewInt(7), Data: []byte{0xde, 0xad},
+			},
+			json: `{"chainId":"0xaa36a7","nonce":"0x3","maxPriorityFeePerGas":"0x77359400","maxFeePerGas":"0x6fc23ac00","gas":"0xc350","to":"0x3535353535353535353535353535353535353535","value":"0x7","data":"0xdead"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc, err := rlp.EncodeToBytes(tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signingData := append([]byte{tt.txType}, enc...)
+
+			fromRLP, err := ParseTransaction([]byte(hexutil.Encode(signingData)), chainID)
+			if err != nil {
+				t.Fatalf("failed to parse RLP: %v", err)
+			}
+			fromJSON, err := ParseTransaction([]byte(tt.json), chainID)
+			if err != nil {
+				t.Fatalf("failed to parse JSON: %v", err)
+			}
+
+			// The signing hash is the hash of the signing payload
+			hash := SigningHash(fromRLP, chainID)
+			if want := crypto.Keccak256Hash(signingData); hash != want {
+				t.Fatalf("signing hash %s, want %s", hash, want)
+			}
+			if jsonHash := SigningHash(fromJSON, chainID); jsonHash != hash {
+				t.Fatalf("JSON transaction has signing hash %s, want %s", jsonHash, hash)
+			}
+
+			signed, err := SignTransaction(fromRLP, chainID, chaintest.TSSSign(t, key, hash[:]), &key.PublicKey)
+			if err != nil {
+				t.Fatalf("failed to sign transaction: %v", err)
+			}
+			if signed.Type() != tt.txType {
+				t.Fatalf("signed transaction has type %d, want %d", signed.Type(), tt.txType)
+			}
+			sender, err := types.Sender(types.LatestSignerForChainID(chainID), signed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := crypto.PubkeyToAddress(key.PublicKey); sender != want {
+				t.Fatalf("sender %s, want %s", sender, want)
+			}
+		})
+	}
+}
+
+func TestSignTransactionRejectsForeignKey(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	chainID := big.NewInt(1)
+
+	tx, err := ParseTransaction([]byte(eip155JSON), chainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := SigningHash(tx, chainID)
+	if _, err := SignTransaction(tx, chainID, chaintest.TSSSign(t, key, hash[:]), &other.PublicKey); err == nil {
+		t.Fatal("expected an error for a signature of another key")
+	}
+}
+
+func TestParseTransactionChainIDMismatch(t *testing.T) {
+	tests := []struct {
+		input   string
+		chainID int64
+	}{
+		{eip155SigningData, 5},
+		{`{"chainId":"0x5","nonce":"0x0","maxPriorityFeePerGas":"0x1","maxFeePerGas":"0x1","gas":"0x5208","value":"0x0"}`, 1},
+	}
+	for _, tt := range tests {
+		if _, err := ParseTransaction([]byte(tt.input), big.NewInt(tt.chainID)); err == nil {
+			t.Fatalf("expected a chain ID mismatch for %s on chain %d", tt.input, tt.chainID)
+		}
+	}
+}
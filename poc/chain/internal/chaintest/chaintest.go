@@ -0,0 +1,42 @@
+// Package chaintest provides the signatures the chain packages are tested
+// with, shaped like the output of a tss-lib signing session.
+package chaintest
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	tsscommon "github.com/bnb-chain/tss-lib/v2/common"
+	"github.com/btcsuite/btcd/btcec/v2"
+	btcecdsa "github.com/btcsuite/btcd/btcec/v2/ecdsa"
+)
+
+// TSSSign signs hash with the secp256k1 key the way a tss-lib signing
+// session reports it. The signature is deterministic, as in RFC 6979.
+func TSSSign(t testing.TB, key *ecdsa.PrivateKey, hash []byte) *tsscommon.SignatureData {
+	t.Helper()
+
+	priv, _ := btcec.PrivKeyFromBytes(key.D.FillBytes(make([]byte, 32)))
+	compact, err := btcecdsa.SignCompact(priv, hash, true)
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	return &tsscommon.SignatureData{
+		R:                 compact[1:33],
+		S:                 compact[33:65],
+		SignatureRecovery: []byte{compact[0] - 27 - 4},
+		M:                 hash,
+	}
+}
+
+// HighS returns the malleated form of a signature, which verifies as well.
+func HighS(data *tsscommon.SignatureData) *tsscommon.SignatureData {
+	s := new(big.Int).Sub(btcec.S256().N, new(big.Int).SetBytes(data.S))
+	return &tsscommon.SignatureData{
+		R:                 data.R,
+		S:                 s.Bytes(),
+		SignatureRecovery: []byte{data.SignatureRecovery[0] ^ 1},
+		M:                 data.M,
+	}
+}
@@ -0,0 +1,75 @@
+// Package chain turns threshold signatures into the signed payloads of
+// blockchains.
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/bnb-chain/tss-lib/v2/common"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+)
+
+var (
+	secp256k1N     = tss.S256().Params().N
+	secp256k1HalfN = new(big.Int).Rsh(secp256k1N, 1)
+)
+
+// Signature is a secp256k1 ECDSA signature. RecoveryID tells which of the
+// candidate public keys for R verifies it: bit 0 is the parity of the y
+// coordinate of R, bit 1 is set if its x coordinate overflowed the order.
+type Signature struct {
+	R, S       *big.Int
+	RecoveryID byte
+}
+
+// FromTSS converts the output of a tss-lib signing session.
+func FromTSS(data *common.SignatureData) (Signature, error) {
+	if data == nil || len(data.GetR()) == 0 || len(data.GetS()) == 0 {
+		return Signature{}, errors.New("empty signature")
+	}
+	if len(data.GetSignatureRecovery()) != 1 {
+		return Signature{}, fmt.Errorf("invalid recovery byte: %x", data.GetSignatureRecovery())
+	}
+
+	sig := Signature{
+		R:          new(big.Int).SetBytes(data.GetR()),
+		S:          new(big.Int).SetBytes(data.GetS()),
+		RecoveryID: data.GetSignatureRecovery()[0],
+	}
+	if err := sig.checkRange(); err != nil {
+		return Signature{}, err
+	}
+	if sig.RecoveryID > 3 {
+		return Signature{}, fmt.Errorf("invalid recovery ID: %d", sig.RecoveryID)
+	}
+	return sig, nil
+}
+
+// IsLowS reports whether S is in the lower half of the curve order, as most
+// chains require to rule out malleated signatures.
+func (sig Signature) IsLowS() bool {
+	return sig.S.Cmp(secp256k1HalfN) <= 0
+}
+
+// Normalize returns the low-S form of the signature. Negating S negates R's
+// point too, so the parity bit of the recovery ID flips along.
+func (sig Signature) Normalize() Signature {
+	if sig.IsLowS() {
+		return sig
+	}
+	return Signature{
+		R:          sig.R,
+		S:          new(big.Int).Sub(secp256k1N, sig.S),
+		RecoveryID: sig.RecoveryID ^ 1,
+	}
+}
+
+// Bytes returns the 64-byte R || S encoding.
+func (sig Signature) Bytes() []byte {
+	out := make([]byte, 64)
+	sig.R.FillBytes(out[:32])
+	sig.S.FillBytes(out[32:])
+	return out
+}
@@ -0,0 +1,225 @@
+// Package solana signs Solana transactions with threshold Ed25519 keys.
+package solana
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	tsscommon "github.com/bnb-chain/tss-lib/v2/common"
+	"github.com/btcsuite/btcd/btcutil/base58"
+)
+
+const (
+	// SignatureLength is the length of an Ed25519 signature.
+	SignatureLength = ed25519.SignatureSize
+
+	// MaxTransactionSize is the largest transaction the network accepts.
+	MaxTransactionSize = 1232
+
+	// LegacyVersion is the version of messages without a version prefix.
+	LegacyVersion = -1
+
+	versionPrefix = 0x80
+)
+
+// Message is a serialized Solana transaction message: the bytes every
+// required signer signs.
+type Message struct {
+	Raw []byte
+
+	// Version is LegacyVersion or the number of a versioned message
+	Version int
+
+	NumRequiredSignatures       int
+	NumReadonlySignedAccounts   int
+	NumReadonlyUnsignedAccounts int
+	AccountKeys                 []ed25519.PublicKey
+	RecentBlockhash             []byte
+	Instructions                int
+	AddressTableLookups         int
+}
+
+// ParseMessage decodes a serialized message given in base64 or base58.
+func ParseMessage(input []byte) (*Message, error) {
+	input = bytes.TrimSpace(input)
+
+	var errs []error
+	if raw, err := base64.StdEncoding.DecodeString(string(input)); err == nil {
+		msg, err := DecodeMessage(raw)
+		if err == nil {
+			return msg, nil
+		}
+		errs = append(errs, fmt.Errorf("base64: %w", err))
+	}
+	if raw := base58.Decode(string(input)); len(raw) > 0 {
+		msg, err := DecodeMessage(raw)
+		if err == nil {
+			return msg, nil
+		}
+		errs = append(errs, fmt.Errorf("base58: %w", err))
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("message is neither base64 nor base58 encoded")
+	}
+	return nil, fmt.Errorf("invalid message: %w", errors.Join(errs...))
+}
+
+// DecodeMessage parses a legacy or v0 message.
+func DecodeMessage(raw []byte) (*Message, error) {
+	r := &reader{data: raw}
+	msg := &Message{Raw: raw, Version: LegacyVersion}
+
+	if len(raw) > 0 && raw[0]&versionPrefix != 0 {
+		msg.Version = int(raw[0] &^ versionPrefix)
+		if msg.Version != 0 {
+			return nil, fmt.Errorf("unsupported message version: %d", msg.Version)
+		}
+		r.pos++
+	}
+
+	header := r.next(3)
+	accounts := r.compactU16()
+	keys := r.next(accounts * ed25519.PublicKeySize)
+	msg.RecentBlockhash = r.next(32)
+	if r.err != nil {
+		return nil, r.err
+	}
+	msg.NumRequiredSignatures = int(header[0])
+	msg.NumReadonlySignedAccounts = int(header[1])
+	msg.NumReadonlyUnsignedAccounts = int(header[2])
+	for i := 0; i < accounts; i++ {
+		msg.AccountKeys = append(msg.AccountKeys, ed25519.PublicKey(keys[i*ed25519.PublicKeySize:(i+1)*ed25519.PublicKeySize]))
+	}
+
+	if msg.NumRequiredSignatures == 0 || msg.NumRequiredSignatures > accounts {
+		return nil, fmt.Errorf("invalid number of required signatures: %d of %d accounts", msg.NumRequiredSignatures, accounts)
+	}
+	if msg.NumReadonlySignedAccounts >= msg.NumRequiredSignatures {
+		return nil, errors.New("fee payer is read-only")
+	}
+	if msg.NumReadonlyUnsignedAccounts > accounts-msg.NumRequiredSignatures {
+		return nil, errors.New("too many read-only unsigned accounts")
+	}
+
+	msg.Instructions = r.compactU16()
+	for i := 0; i < msg.Instructions && r.err == nil; i++ {
+		r.next(1) // program ID index
+		r.next(r.compactU16())
+		r.next(r.compactU16())
+	}
+	if msg.Version == 0 {
+		msg.AddressTableLookups = r.compactU16()
+		for i := 0; i < msg.AddressTableLookups && r.err == nil; i++ {
+			r.next(32) // table account
+			r.next(r.compactU16())
+			r.next(r.compactU16())
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.pos != len(raw) {
+		return nil, fmt.Errorf("%d trailing bytes after message", len(raw)-r.pos)
+	}
+	return msg, nil
+}
+
+// SignerIndex returns the position of pub among the required signers.
+func (m *Message) SignerIndex(pub ed25519.PublicKey) (int, error) {
+	for i, key := range m.AccountKeys[:m.NumRequiredSignatures] {
+		if key.Equal(pub) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%s is not a required signer of the message", Address(pub))
+}
+
+// Address returns the base58 account address of pub.
+func Address(pub ed25519.PublicKey) string {
+	return base58.Encode(pub)
+}
+
+// Signature returns the Ed25519 signature of a threshold EdDSA signing
+// session, checked against message and pub.
+func Signature(message []byte, data *tsscommon.SignatureData, pub ed25519.PublicKey) ([]byte, error) {
+	sig := data.GetSignature()
+	if len(sig) != SignatureLength {
+		return nil, fmt.Errorf("invalid signature length: %d, want %d", len(sig), SignatureLength)
+	}
+	if !ed25519.Verify(pub, message, sig) {
+		return nil, errors.New("signature does not verify against the public key")
+	}
+	return sig, nil
+}
+
+// SignedTransaction serializes the transaction of msg with the signature of
+// pub at its signer position. The signatures of other required signers are
+// left zero for them to fill in.
+func SignedTransaction(msg *Message, pub ed25519.PublicKey, sig []byte) ([]byte, error) {
+	index, err := msg.SignerIndex(pub)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(pub, msg.Raw, sig) {
+		return nil, errors.New("signature does not verify against the public key")
+	}
+
+	tx := appendCompactU16(nil, msg.NumRequiredSignatures)
+	signatures := make([]byte, msg.NumRequiredSignatures*SignatureLength)
+	copy(signatures[index*SignatureLength:], sig)
+	tx = append(append(tx, signatures...), msg.Raw...)
+	if len(tx) > MaxTransactionSize {
+		return nil, fmt.Errorf("transaction of %d bytes exceeds the limit of %d", len(tx), MaxTransactionSize)
+	}
+	return tx, nil
+}
+
+// reader reads the fields of a message, keeping the first error.
+type reader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.data)-r.pos {
+		r.err = errors.New("message is truncated")
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+// compactU16 reads the variable-length integer Solana uses for lengths.
+func (r *reader) compactU16() int {
+	var value int
+	for i := 0; i < 3; i++ {
+		b := r.next(1)
+		if b == nil {
+			return 0
+		}
+		value |= int(b[0]&0x7f) << (7 * i)
+		if b[0]&0x80 == 0 {
+			return value
+		}
+	}
+	r.err = errors.New("invalid compact length")
+	return 0
+}
+
+func appendCompactU16(b []byte, value int) []byte {
+	for {
+		if value < 0x80 {
+			return append(b, byte(value))
+		}
+		b = append(b, byte(value&0x7f)|0x80)
+		value >>= 7
+	}
+}
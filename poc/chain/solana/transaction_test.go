@@ -0,0 +1,165 @@
+package solana
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+
+	tsscommon "github.com/bnb-chain/tss-lib/v2/common"
+	"github.com/btcsuite/btcd/btcutil/base58"
+)
+
+// systemProgram is the address of the system program, 32 zero bytes.
+const systemProgram = "11111111111111111111111111111111"
+
+// transferMessage builds the message of a system transfer from the first
+// signer to recipient. With a version it is a v0 message with one address
+// table lookup.
+func transferMessage(signers []ed25519.PublicKey, recipient ed25519.PublicKey, version int) []byte {
+	var msg []byte
+	if version != LegacyVersion {
+		msg = append(msg, versionPrefix|byte(version))
+	}
+	msg = append(msg, byte(len(signers)), 0, 1)
+
+	keys := append(append(signers, recipient), make(ed25519.PublicKey, ed25519.PublicKeySize))
+	msg = append(msg, byte(len(keys)))
+	for _, key := range keys {
+		msg = append(msg, key...)
+	}
+	msg = append(msg, bytes.Repeat([]byte{7}, 32)...)
+
+	data := binary.LittleEndian.AppendUint32(nil, 2)
+	data = binary.LittleEndian.AppendUint64(data, 1_000_000)
+	msg = append(msg, 1, byte(len(keys)-1), 2, 0, byte(len(signers)), byte(len(data)))
+	msg = append(msg, data...)
+
+	if version != LegacyVersion {
+		msg = append(msg, 1)
+		msg = append(msg, bytes.Repeat([]byte{9}, 32)...)
+		msg = append(msg, 1, 3, 0)
+	}
+	return msg
+}
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+func TestAddress(t *testing.T) {
+	if got := Address(make(ed25519.PublicKey, ed25519.PublicKeySize)); got != systemProgram {
+		t.Fatalf("address of the zero key is %s, want %s", got, systemProgram)
+	}
+}
+
+func TestDecodeMessage(t *testing.T) {
+	payer, _ := newKey(t)
+	recipient, _ := newKey(t)
+
+	for _, version := range []int{LegacyVersion, 0} {
+		raw := transferMessage([]ed25519.PublicKey{payer}, recipient, version)
+		msg, err := DecodeMessage(raw)
+		if err != nil {
+			t.Fatalf("failed to decode version %d message: %v", version, err)
+		}
+		if msg.Version != version || msg.NumRequiredSignatures != 1 || len(msg.AccountKeys) != 3 || msg.Instructions != 1 {
+			t.Fatalf("unexpected version %d message: %+v", version, msg)
+		}
+		if wantLookups := version + 1; msg.AddressTableLookups != wantLookups {
+			t.Fatalf("version %d message has %d address table lookups, want %d", version, msg.AddressTableLookups, wantLookups)
+		}
+		if Address(msg.AccountKeys[2]) != systemProgram {
+			t.Fatalf("third account is %s, want the system program", Address(msg.AccountKeys[2]))
+		}
+
+		for name, encoded := range map[string]string{
+			"base64": base64.StdEncoding.EncodeToString(raw),
+			"base58": base58.Encode(raw),
+		} {
+			parsed, err := ParseMessage([]byte(encoded + "\n"))
+			if err != nil {
+				t.Fatalf("failed to parse %s message: %v", name, err)
+			}
+			if !bytes.Equal(parsed.Raw, raw) {
+				t.Fatalf("%s message decodes to other bytes", name)
+			}
+		}
+	}
+
+	raw := transferMessage([]ed25519.PublicKey{payer}, recipient, LegacyVersion)
+	versioned := transferMessage([]ed25519.PublicKey{payer}, recipient, 0)
+	versioned[0] = versionPrefix | 1
+	for name, invalid := range map[string][]byte{
+		"truncated":   raw[:len(raw)-1],
+		"trailing":    append(append([]byte(nil), raw...), 0),
+		"no signers":  append([]byte{0}, raw[1:]...),
+		"version 1":   versioned,
+		"empty input": nil,
+	} {
+		if _, err := DecodeMessage(invalid); err == nil {
+			t.Fatalf("expected an error for a %s message", name)
+		}
+	}
+}
+
+func TestSignedTransaction(t *testing.T) {
+	payer, payerKey := newKey(t)
+	committee, committeeKey := newKey(t)
+	recipient, _ := newKey(t)
+
+	msg, err := DecodeMessage(transferMessage([]ed25519.PublicKey{payer, committee}, recipient, LegacyVersion))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index, err := msg.SignerIndex(committee); err != nil || index != 1 {
+		t.Fatalf("committee signer index %d (%v), want 1", index, err)
+	}
+	if _, err := msg.SignerIndex(recipient); err == nil {
+		t.Fatal("expected the recipient not to be a signer")
+	}
+
+	data := &tsscommon.SignatureData{Signature: ed25519.Sign(committeeKey, msg.Raw), M: msg.Raw}
+	sig, err := Signature(msg.Raw, data, committee)
+	if err != nil {
+		t.Fatalf("invalid signature: %v", err)
+	}
+	if _, err := Signature(msg.Raw, data, payer); err == nil {
+		t.Fatal("expected an error for a signature of another key")
+	}
+
+	tx, err := SignedTransaction(msg, committee, sig)
+	if err != nil {
+		t.Fatalf("failed to build transaction: %v", err)
+	}
+
+	// Two signature slots, the payer's left for the payer to fill in
+	if tx[0] != 2 {
+		t.Fatalf("transaction has %d signatures, want 2", tx[0])
+	}
+	signatures, message := tx[1:1+2*SignatureLength], tx[1+2*SignatureLength:]
+	if !bytes.Equal(message, msg.Raw) {
+		t.Fatal("transaction does not end with the message")
+	}
+	if !bytes.Equal(signatures[:SignatureLength], make([]byte, SignatureLength)) {
+		t.Fatal("payer signature slot is not empty")
+	}
+	if !ed25519.Verify(committee, message, signatures[SignatureLength:]) {
+		t.Fatal("committee signature does not verify")
+	}
+
+	if _, err := SignedTransaction(msg, payer, sig); err == nil {
+		t.Fatal("expected an error for a signature attached to another signer")
+	}
+	copy(signatures, ed25519.Sign(payerKey, msg.Raw))
+	if !ed25519.Verify(payer, message, signatures[:SignatureLength]) {
+		t.Fatal("payer signature does not fit its slot")
+	}
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+	"github.com/keruch/thesis/poc/session"
+	"github.com/spf13/cobra"
+)
+
+const (
+	benchOperationKeygen = "keygen"
+	benchOperationSign   = "sign"
+
+	benchSessionID = "bench"
+
+	// How often the heap is sampled while a session runs
+	heapSampleInterval = 10 * time.Millisecond
+)
+
+type benchFlags struct {
+	parties      string
+	threshold    int
+	signers      int
+	iterations   int
+	output       string
+	format       string
+	preParamsDir string
+	timeout      time.Duration
+}
+
+func NewBenchCmd() *cobra.Command {
+	var flags benchFlags
+
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Measure time and memory of TSS sessions for different numbers of parties",
+		Long: `Runs in-process keygen or signing sessions for every number of parties and
+records the wall time per round, the bytes sent by every party, the peak heap
+and the allocations. Results are written as CSV or JSON for plotting.`,
+	}
+
+	cmd.PersistentFlags().StringVar(&flags.parties, "parties", "3..5", `Numbers of parties: a range "3..7", a list "3,5,7" or a single number`)
+	cmd.PersistentFlags().IntVar(&flags.threshold, "threshold", 0, "Threshold of the key (default: parties-1)")
+	cmd.PersistentFlags().IntVar(&flags.iterations, "iterations", 3, "Number of sessions run for every number of parties")
+	cmd.PersistentFlags().StringVarP(&flags.output, "output", "o", "", "Write the results to a file instead of stdout")
+	cmd.PersistentFlags().StringVar(&flags.format, "format", "", `Output format: "csv" or "json" (default: from the output file extension, else csv)`)
+	cmd.PersistentFlags().StringVar(&flags.preParamsDir, "pre-params-dir", "data/bench", "Directory caching the pre-parameters of the parties")
+	cmd.PersistentFlags().DurationVar(&flags.timeout, "timeout", 10*time.Minute, "Abort a session if it does not complete in time")
+
+	keygenCmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Benchmark key generation",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBench(benchOperationKeygen, flags)
+		},
+	}
+
+	signCmd := &cobra.Command{
+		Use:   "sign",
+		Short: "Benchmark signing",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBench(benchOperationSign, flags)
+		},
+	}
+	signCmd.Flags().IntVar(&flags.signers, "signers", 0, "Number of parties taking part in signing (default: threshold+1)")
+
+	cmd.AddCommand(keygenCmd, signCmd)
+	return cmd
+}
+
+// benchResult holds the measurements of a single session run.
+type benchResult struct {
+	Operation string `json:"operation"`
+	Parties   int    `json:"parties"`
+	Threshold int    `json:"threshold"`
+	// Parties taking part in the session; all parties for keygen
+	Signers   int `json:"signers"`
+	Iteration int `json:"iteration"`
+
+	WallTimeMs float64 `json:"wall_time_ms"`
+	// Time spent in every round, averaged over the parties
+	RoundTimesMs []float64 `json:"round_times_ms"`
+	// Bytes sent by every party, counting a broadcast once per recipient
+	BytesSent []int64 `json:"bytes_sent"`
+	// Peak heap of all parties above the heap in use before the session
+	PeakHeapBytes uint64 `json:"peak_heap_bytes"`
+	AllocBytes    uint64 `json:"alloc_bytes"`
+	Allocs        uint64 `json:"allocs"`
+}
+
+func runBench(operation string, flags benchFlags) error {
+	counts, err := parsePartyCounts(flags.parties)
+	if err != nil {
+		return err
+	}
+	if flags.iterations < 1 {
+		return fmt.Errorf("invalid number of iterations: %d", flags.iterations)
+	}
+
+	format := flags.format
+	if format == "" {
+		format = "csv"
+		if filepath.Ext(flags.output) == ".json" {
+			format = "json"
+		}
+	}
+	if format != "csv" && format != "json" {
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+
+	var results []benchResult
+	for _, parties := range counts {
+		threshold := flags.threshold
+		if threshold == 0 {
+			threshold = parties - 1
+		}
+		if threshold < 1 || threshold >= parties {
+			return fmt.Errorf("invalid threshold %d for %d parties", threshold, parties)
+		}
+
+		preParams, err := loadBenchPreParams(flags.preParamsDir, parties)
+		if err != nil {
+			return err
+		}
+
+		var res []benchResult
+		switch operation {
+		case benchOperationKeygen:
+			res, err = benchKeygen(parties, threshold, flags.iterations, preParams, flags.timeout)
+		case benchOperationSign:
+			signers := flags.signers
+			if signers == 0 {
+				signers = threshold + 1
+			}
+			if signers <= threshold || signers > parties {
+				return fmt.Errorf("invalid number of signers %d for %d parties with threshold %d", signers, parties, threshold)
+			}
+			res, err = benchSign(parties, threshold, signers, flags.iterations, preParams, flags.timeout)
+		}
+		if err != nil {
+			return err
+		}
+		results = append(results, res...)
+	}
+
+	out := os.Stdout
+	if flags.output != "" {
+		file, err := os.Create(flags.output)
+		if err != nil {
+			return fmt.Errorf("create output file: %v", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if format == "json" {
+		return writeBenchJSON(out, results)
+	}
+	return writeBenchCSV(out, results)
+}
+
+func benchKeygen(parties, threshold, iterations int, preParams []*keygen.LocalPreParams, timeout time.Duration) ([]benchResult, error) {
+	results := make([]benchResult, 0, iterations)
+	for i := 0; i < iterations; i++ {
+		partyIDs := generatePartyIDs(parties)
+		peerCtx := tss.NewPeerContext(partyIDs)
+
+		res, _, err := measureSessions(partyIDs, timeout, func(j int, transport session.Transport) *session.Session {
+			params := tss.NewParameters(tss.S256(), peerCtx, partyIDs[j], parties, threshold)
+			return session.NewKeygen(benchSessionID, params, preParams[j], transport)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("keygen with %d parties: %w", parties, err)
+		}
+
+		res.Operation = benchOperationKeygen
+		res.Parties = parties
+		res.Threshold = threshold
+		res.Signers = parties
+		res.Iteration = i
+		results = append(results, res)
+		fmt.Fprintf(os.Stderr, "keygen: %d parties, threshold %d, iteration %d: %.0fms\n", parties, threshold, i, res.WallTimeMs)
+	}
+	return results, nil
+}
+
+func benchSign(parties, threshold, signers, iterations int, preParams []*keygen.LocalPreParams, timeout time.Duration) ([]benchResult, error) {
+	// The key shares come from an unmeasured keygen
+	partyIDs := generatePartyIDs(parties)
+	peerCtx := tss.NewPeerContext(partyIDs)
+	_, sessions, err := measureSessions(partyIDs, timeout, func(j int, transport session.Transport) *session.Session {
+		params := tss.NewParameters(tss.S256(), peerCtx, partyIDs[j], parties, threshold)
+		return session.NewKeygen(benchSessionID, params, preParams[j], transport)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("keygen with %d parties: %w", parties, err)
+	}
+	keyShares := make([]*keygen.LocalPartySaveData, len(sessions))
+	for j := range sessions {
+		keyShares[j] = sessions[j].SaveData()
+	}
+
+	results := make([]benchResult, 0, iterations)
+	for i := 0; i < iterations; i++ {
+		// The first signers parties sign; their sorted order matches the key shares
+		signerIDs := generatePartyIDs(parties)[:signers]
+		signCtx := tss.NewPeerContext(tss.SortPartyIDs(signerIDs))
+
+		msg, err := rand.Int(rand.Reader, tss.S256().Params().N)
+		if err != nil {
+			return nil, fmt.Errorf("generate message: %v", err)
+		}
+
+		res, _, err := measureSessions(signerIDs, timeout, func(j int, transport session.Transport) *session.Session {
+			params := tss.NewParameters(tss.S256(), signCtx, signerIDs[j], signers, threshold)
+			return session.NewSigning(benchSessionID, params, msg, *keyShares[j], transport)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("signing with %d of %d parties: %w", signers, parties, err)
+		}
+
+		res.Operation = benchOperationSign
+		res.Parties = parties
+		res.Threshold = threshold
+		res.Signers = signers
+		res.Iteration = i
+		results = append(results, res)
+		fmt.Fprintf(os.Stderr, "sign: %d of %d parties, threshold %d, iteration %d: %.0fms\n", signers, parties, threshold, i, res.WallTimeMs)
+	}
+	return results, nil
+}
+
+// measureSessions runs a session for every party over an in-process network
+// and measures it.
+func measureSessions(partyIDs []*tss.PartyID, timeout time.Duration, newSession func(i int, transport session.Transport) *session.Session) (benchResult, []*session.Session, error) {
+	network := session.NewMemoryNetwork()
+
+	transports := make([]*meteredTransport, len(partyIDs))
+	sessions := make([]*session.Session, len(partyIDs))
+	for i := range partyIDs {
+		transports[i] = &meteredTransport{
+			Transport:  network.Transport(partyIDs[i].Id),
+			recipients: len(partyIDs) - 1,
+		}
+		sessions[i] = newSession(i, transports[i])
+	}
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	sampler := newHeapSampler()
+	start := time.Now()
+	err := runSessions(sessions, session.Timeouts{Overall: timeout}, 0)
+	wallTime := time.Since(start)
+	peakHeap := sampler.stop()
+
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+
+	if err != nil {
+		return benchResult{}, nil, err
+	}
+
+	res := benchResult{
+		WallTimeMs: milliseconds(wallTime),
+		BytesSent:  make([]int64, len(transports)),
+		AllocBytes: after.TotalAlloc - before.TotalAlloc,
+		Allocs:     after.Mallocs - before.Mallocs,
+	}
+	if peakHeap > before.HeapAlloc {
+		res.PeakHeapBytes = peakHeap - before.HeapAlloc
+	}
+	for i, t := range transports {
+		res.BytesSent[i] = t.sent.Load()
+	}
+
+	for _, s := range sessions {
+		for round, d := range s.RoundDurations() {
+			if round >= len(res.RoundTimesMs) {
+				res.RoundTimesMs = append(res.RoundTimesMs, 0)
+			}
+			res.RoundTimesMs[round] += milliseconds(d) / float64(len(sessions))
+		}
+	}
+
+	return res, sessions, nil
+}
+
+// meteredTransport counts the bytes a party sends.
+type meteredTransport struct {
+	session.Transport
+
+	// Number of parties a broadcast is delivered to
+	recipients int
+	sent       atomic.Int64
+}
+
+func (t *meteredTransport) Send(ctx context.Context, to string, msg *session.Message) error {
+	t.sent.Add(int64(messageSize(msg)))
+	return t.Transport.Send(ctx, to, msg)
+}
+
+func (t *meteredTransport) Broadcast(ctx context.Context, msg *session.Message) error {
+	t.sent.Add(int64(messageSize(msg) * t.recipients))
+	return t.Transport.Broadcast(ctx, msg)
+}
+
+func messageSize(msg *session.Message) int {
+	size := len(msg.WireBytes) + len(msg.EchoType)
+	for partyID, digest := range msg.Digests {
+		size += len(partyID) + len(digest)
+	}
+	return size
+}
+
+// heapSampler tracks the peak heap in use until stopped.
+type heapSampler struct {
+	peak uint64
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newHeapSampler() *heapSampler {
+	hs := &heapSampler{done: make(chan struct{})}
+	hs.wg.Add(1)
+	go func() {
+		defer hs.wg.Done()
+
+		ticker := time.NewTicker(heapSampleInterval)
+		defer ticker.Stop()
+		for {
+			hs.sample()
+			select {
+			case <-ticker.C:
+			case <-hs.done:
+				return
+			}
+		}
+	}()
+	return hs
+}
+
+func (hs *heapSampler) sample() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	if stats.HeapAlloc > hs.peak {
+		hs.peak = stats.HeapAlloc
+	}
+}
+
+func (hs *heapSampler) stop() uint64 {
+	close(hs.done)
+	hs.wg.Wait()
+	hs.sample()
+	return hs.peak
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// parsePartyCounts parses "3..7", "3,5,7" or "5".
+func parsePartyCounts(s string) ([]int, error) {
+	var counts []int
+	if from, to, found := strings.Cut(s, ".."); found {
+		lo, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			return nil, fmt.Errorf("invalid number of parties %q: %v", s, err)
+		}
+		hi, err := strconv.Atoi(strings.TrimSpace(to))
+		if err != nil {
+			return nil, fmt.Errorf("invalid number of parties %q: %v", s, err)
+		}
+		for n := lo; n <= hi; n++ {
+			counts = append(counts, n)
+		}
+	} else {
+		for _, part := range strings.Split(s, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				return nil, fmt.Errorf("invalid number of parties %q: %v", s, err)
+			}
+			counts = append(counts, n)
+		}
+	}
+
+	if len(counts) == 0 {
+		return nil, fmt.Errorf("invalid number of parties %q: empty range", s)
+	}
+	for _, n := range counts {
+		if n < 2 {
+			return nil, fmt.Errorf("invalid number of parties %d: at least 2 parties are required", n)
+		}
+	}
+	return counts, nil
+}
+
+// loadBenchPreParams loads the pre-parameters of the first n parties from
+// dir, generating and caching the missing ones. Generating pre-parameters is
+// not part of the protocol and takes a while, so it is kept out of the measurements.
+func loadBenchPreParams(dir string, n int) ([]*keygen.LocalPreParams, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create pre-params dir: %v", err)
+	}
+
+	preParams := make([]*keygen.LocalPreParams, n)
+	for i := range preParams {
+		path := filepath.Join(dir, fmt.Sprintf("pre-params-%d.json", i))
+
+		data, err := os.ReadFile(path)
+		if err == nil {
+			var pp keygen.LocalPreParams
+			if err := json.Unmarshal(data, &pp); err != nil {
+				return nil, fmt.Errorf("decode pre-params %s: %v", path, err)
+			}
+			preParams[i] = &pp
+			continue
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("read pre-params %s: %v", path, err)
+		}
+
+		fmt.Fprintf(os.Stderr, "Generating pre-parameters of party %d...\n", i)
+		pp, err := keygen.GeneratePreParams(5 * time.Minute)
+		if err != nil {
+			return nil, fmt.Errorf("generate pre-parameters: %v", err)
+		}
+		data, err = json.MarshalIndent(pp, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("encode pre-params: %v", err)
+		}
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			return nil, fmt.Errorf("write pre-params %s: %v", path, err)
+		}
+		preParams[i] = pp
+	}
+	return preParams, nil
+}
+
+func writeBenchJSON(w io.Writer, results []benchResult) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(results); err != nil {
+		return fmt.Errorf("encode results: %v", err)
+	}
+	return nil
+}
+
+// writeBenchCSV writes a row per session with a column per round. The bytes
+// sent are summarized as the mean and maximum over the parties.
+func writeBenchCSV(w io.Writer, results []benchResult) error {
+	rounds := 0
+	for _, res := range results {
+		rounds = max(rounds, len(res.RoundTimesMs))
+	}
+
+	header := []string{"operation", "parties", "threshold", "signers", "iteration", "wall_time_ms"}
+	for i := 1; i <= rounds; i++ {
+		header = append(header, fmt.Sprintf("round_%d_ms", i))
+	}
+	header = append(header, "bytes_sent_mean", "bytes_sent_max", "bytes_sent_total", "peak_heap_bytes", "alloc_bytes", "allocs")
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return fmt.Errorf("write results: %v", err)
+	}
+
+	for _, res := range results {
+		row := []string{
+			res.Operation,
+			strconv.Itoa(res.Parties),
+			strconv.Itoa(res.Threshold),
+			strconv.Itoa(res.Signers),
+			strconv.Itoa(res.Iteration),
+			formatMs(res.WallTimeMs),
+		}
+		for i := 0; i < rounds; i++ {
+			if i < len(res.RoundTimesMs) {
+				row = append(row, formatMs(res.RoundTimesMs[i]))
+			} else {
+				row = append(row, "")
+			}
+		}
+
+		var total, peak int64
+		for _, sent := range res.BytesSent {
+			total += sent
+			peak = max(peak, sent)
+		}
+		row = append(row,
+			strconv.FormatInt(total/int64(len(res.BytesSent)), 10),
+			strconv.FormatInt(peak, 10),
+			strconv.FormatInt(total, 10),
+			strconv.FormatUint(res.PeakHeapBytes, 10),
+			strconv.FormatUint(res.AllocBytes, 10),
+			strconv.FormatUint(res.Allocs, 10),
+		)
+
+		if err := writer.Write(row); err != nil {
+			return fmt.Errorf("write results: %v", err)
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("write results: %v", err)
+	}
+	return nil
+}
+
+func formatMs(ms float64) string {
+	return strconv.FormatFloat(ms, 'f', 3, 64)
+}
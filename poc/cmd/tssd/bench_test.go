@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files in testdata")
+
+func TestParsePartyCounts(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []int
+		err   bool
+	}{
+		{input: "3..5", want: []int{3, 4, 5}},
+		{input: " 2 .. 3 ", want: []int{2, 3}},
+		{input: "4..4", want: []int{4}},
+		{input: "3,5,7", want: []int{3, 5, 7}},
+		{input: "3, 5", want: []int{3, 5}},
+		{input: "5", want: []int{5}},
+		{input: "5..3", err: true},
+		{input: "", err: true},
+		{input: "three", err: true},
+		{input: "3..", err: true},
+		{input: "..5", err: true},
+		{input: "3,,5", err: true},
+		{input: "1", err: true},
+		{input: "1..3", err: true},
+		{input: "3,0", err: true},
+		{input: "-2..3", err: true},
+	}
+
+	for _, tc := range tests {
+		got, err := parsePartyCounts(tc.input)
+		if tc.err {
+			if err == nil {
+				t.Errorf("parsePartyCounts(%q) = %v, want an error", tc.input, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePartyCounts(%q) failed: %v", tc.input, err)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("parsePartyCounts(%q) = %v, want %v", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestRunBenchRejectsInvalidThreshold(t *testing.T) {
+	// The sizes are checked before any pre-parameters are loaded or generated
+	for _, flags := range []benchFlags{
+		{parties: "3", threshold: 3, iterations: 1},
+		{parties: "2..4", threshold: 2, iterations: 1},
+		{parties: "3", threshold: -1, iterations: 1},
+	} {
+		if err := runBench(benchOperationKeygen, flags); err == nil {
+			t.Errorf("expected an error for threshold %d of %s parties", flags.threshold, flags.parties)
+		}
+	}
+}
+
+// testBenchResults are two sessions with a different number of rounds, as
+// keygen and signing have.
+func testBenchResults() []benchResult {
+	return []benchResult{
+		{
+			Operation:     benchOperationKeygen,
+			Parties:       3,
+			Threshold:     2,
+			Signers:       3,
+			Iteration:     0,
+			WallTimeMs:    1234.5678,
+			RoundTimesMs:  []float64{100.25, 200.5, 300.125},
+			BytesSent:     []int64{1000, 2000, 3001},
+			PeakHeapBytes: 4096,
+			AllocBytes:    8192,
+			Allocs:        64,
+		},
+		{
+			Operation:     benchOperationSign,
+			Parties:       3,
+			Threshold:     2,
+			Signers:       3,
+			Iteration:     1,
+			WallTimeMs:    99.9,
+			RoundTimesMs:  []float64{10, 20},
+			BytesSent:     []int64{500, 500, 500},
+			PeakHeapBytes: 1024,
+			AllocBytes:    2048,
+			Allocs:        16,
+		},
+	}
+}
+
+func TestWriteBenchResults(t *testing.T) {
+	for _, tc := range []struct {
+		golden string
+		write  func(io.Writer, []benchResult) error
+	}{
+		{golden: "bench.csv", write: writeBenchCSV},
+		{golden: "bench.json", write: writeBenchJSON},
+	} {
+		t.Run(tc.golden, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := tc.write(&out, testBenchResults()); err != nil {
+				t.Fatalf("failed to write results: %v", err)
+			}
+
+			path := filepath.Join("testdata", tc.golden)
+			if *update {
+				if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out.Bytes(), want) {
+				t.Fatalf("results differ from %s:\n got:\n%s\nwant:\n%s", path, out.Bytes(), want)
+			}
+		})
+	}
+}
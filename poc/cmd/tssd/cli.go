@@ -22,5 +22,7 @@ func initRootCmd(rootCmd *cobra.Command) {
 		NewInitCmd(),
 		NewKeygenSimulateCmd(),
 		NewKeysignSimulateCmd(),
+		NewBenchCmd(),
+		NewVerifyCmd(),
 	)
 }
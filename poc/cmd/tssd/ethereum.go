@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+
+	"github.com/bnb-chain/tss-lib/v2/common"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/keruch/thesis/poc/chain/ethereum"
+)
+
+// ethereumTx is an unsigned Ethereum transaction to sign.
+type ethereumTx struct {
+	tx      *types.Transaction
+	chainID *big.Int
+	hash    ethcommon.Hash
+}
+
+func parseEthereumTx(input, chainIDStr string) (*ethereumTx, error) {
+	chainID, ok := new(big.Int).SetString(chainIDStr, 0)
+	if !ok {
+		return nil, fmt.Errorf("invalid chain ID: %s", chainIDStr)
+	}
+
+	tx, err := ethereum.ParseTransaction([]byte(input), chainID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ethereumTx{
+		tx:      tx,
+		chainID: chainID,
+		hash:    ethereum.SigningHash(tx, chainID),
+	}, nil
+}
+
+// printSigned attaches the signature to the transaction and prints the raw
+// signed transaction.
+func (t *ethereumTx) printSigned(sig *common.SignatureData, pub *ecdsa.PublicKey) error {
+	signed, err := ethereum.SignTransaction(t.tx, t.chainID, sig, pub)
+	if err != nil {
+		return fmt.Errorf("sign transaction: %v", err)
+	}
+	raw, err := signed.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("encode transaction: %v", err)
+	}
+
+	v, _, _ := signed.RawSignatureValues()
+	fmt.Println("Sender:          ", ethereum.Address(pub).Hex())
+	fmt.Println("Transaction hash:", signed.Hash().Hex())
+	if signed.Type() == types.LegacyTxType {
+		fmt.Println("V:               ", v)
+	} else {
+		fmt.Println("yParity:         ", v)
+	}
+	fmt.Println("Raw transaction: ", hexutil.Encode(raw))
+	return nil
+}
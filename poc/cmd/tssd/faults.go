@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/keruch/thesis/poc/session"
+	"github.com/spf13/cobra"
+)
+
+// simulateFlags configures the network of a simulation.
+type simulateFlags struct {
+	timeout       time.Duration
+	roundTimeout  time.Duration
+	workers       int
+	latency       string
+	dropRate      float64
+	duplicateRate float64
+	reorderRate   float64
+	reorderDelay  time.Duration
+	partitions    []string
+	seed          int64
+}
+
+func addSimulateFlags(cmd *cobra.Command, f *simulateFlags, timeout time.Duration) {
+	cmd.Flags().DurationVar(&f.timeout, "timeout", timeout, "Abort the session if it does not complete in time")
+	cmd.Flags().DurationVar(&f.roundTimeout, "round-timeout", 0, "Abort the session if a round does not complete in time (default: disabled)")
+	cmd.Flags().IntVar(&f.workers, "workers", 0, "Protocol updates computed at a time across all parties (default: one per CPU)")
+	cmd.Flags().StringVar(&f.latency, "latency", "", `Message latency: "50ms", "uniform:10ms-100ms" or "normal:100ms,20ms"`)
+	cmd.Flags().Float64Var(&f.dropRate, "drop", 0, "Probability that a message is lost")
+	cmd.Flags().Float64Var(&f.duplicateRate, "duplicate", 0, "Probability that a message is delivered twice")
+	cmd.Flags().Float64Var(&f.reorderRate, "reorder", 0, "Probability that a message is held back and overtaken by later ones")
+	cmd.Flags().DurationVar(&f.reorderDelay, "reorder-delay", 100*time.Millisecond, "How long reordered messages are held back")
+	cmd.Flags().StringArrayVar(&f.partitions, "partition", nil, `Isolate parties for a while, e.g. "poc-party-id-0,poc-party-id-1@5s+10s" (repeatable)`)
+	cmd.Flags().Int64Var(&f.seed, "seed", 0, "Seed of the injected faults (default: random)")
+}
+
+func (f *simulateFlags) timeouts() session.Timeouts {
+	return session.Timeouts{Round: f.roundTimeout, Overall: f.timeout}
+}
+
+func (f *simulateFlags) faults() (session.FaultConfig, error) {
+	cfg := session.FaultConfig{
+		DropRate:      f.dropRate,
+		DuplicateRate: f.duplicateRate,
+		ReorderRate:   f.reorderRate,
+		ReorderDelay:  f.reorderDelay,
+		Seed:          f.seed,
+	}
+
+	if f.latency != "" {
+		latency, err := session.ParseLatency(f.latency)
+		if err != nil {
+			return session.FaultConfig{}, err
+		}
+		cfg.Latency = latency
+	}
+
+	for _, s := range f.partitions {
+		p, err := session.ParsePartition(s)
+		if err != nil {
+			return session.FaultConfig{}, err
+		}
+		cfg.Partitions = append(cfg.Partitions, p)
+	}
+
+	for _, rate := range []float64{cfg.DropRate, cfg.DuplicateRate, cfg.ReorderRate} {
+		if rate < 0 || rate > 1 {
+			return session.FaultConfig{}, fmt.Errorf("invalid rate %v: must be between 0 and 1", rate)
+		}
+	}
+
+	return cfg, nil
+}
+
+// simulatedTransport returns the transport of a simulated party, injecting the configured faults.
+func simulatedTransport(network *session.MemoryNetwork, partyID string, faults session.FaultConfig) session.Transport {
+	transport := network.Transport(partyID)
+	if !faults.Enabled() {
+		return transport
+	}
+	return session.NewFaultyTransport(transport, partyID, faults)
+}
@@ -1,103 +1,79 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
 	"github.com/bnb-chain/tss-lib/v2/tss"
+	"github.com/keruch/thesis/poc/session"
 	"github.com/spf13/cobra"
 )
 
 const (
 	tssPartyID      = "poc-party-id"
 	tssPartyMoniker = "poc-moniker"
+
+	keygenSessionID  = "keygen-simulate"
+	keysignSessionID = "keysign-simulate"
 )
 
 func NewKeygenSimulateCmd() *cobra.Command {
+	var flags simulateFlags
+
 	cmd := &cobra.Command{
 		Use:   "keygen-simulate",
 		Short: "Simulate TSS keygen",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			keygenSimulate()
+			faults, err := flags.faults()
+			if err != nil {
+				return err
+			}
+			keygenSimulate(faults, flags.timeouts(), flags.workers)
 			return nil
 		},
 	}
+	addSimulateFlags(cmd, &flags, 5*time.Minute)
 	return cmd
 }
 
-func keygenSimulate() {
+func keygenSimulate(faults session.FaultConfig, timeouts session.Timeouts, workers int) {
 	partyIDs := generatePartyIDs(4)
 	ctx := tss.NewPeerContext(partyIDs)
 
 	// Select an elliptic curve
 	curve := tss.S256()
 
-	// Sey up channels for communication
-	errCh := make(chan *tss.Error, len(partyIDs))
-	outCh := make(chan tss.Message, len(partyIDs))
-	endCh := make(chan *keygen.LocalPartySaveData, len(partyIDs))
-
 	// TODO: make threshold configurable
 	const threshold = 3
 
-	parties := make([]*keygen.LocalParty, len(partyIDs))
+	// Parties exchange messages through an in-memory transport
+	network := session.NewMemoryNetwork()
+
+	sessions := make([]*session.Session, len(partyIDs))
 	for i := range partyIDs {
 		// Create the party
 		params := tss.NewParameters(curve, ctx, partyIDs[i], len(partyIDs), threshold)
-		party := keygen.NewLocalParty(params, outCh, endCh)
-		parties[i] = party.(*keygen.LocalParty)
-		fmt.Printf("Created party: Index %d, Moniker %s, PartyID %s\n", party.PartyID().Index, party.PartyID().Moniker, party.PartyID().Id)
+		sessions[i] = session.NewKeygen(keygenSessionID, params, nil, simulatedTransport(network, partyIDs[i].Id, faults))
+		fmt.Printf("Created party: Index %d, Moniker %s, PartyID %s\n", partyIDs[i].Index, partyIDs[i].Moniker, partyIDs[i].Id)
 	}
 
-	for i := range parties {
-		go func() {
-			if err := parties[i].Start(); err != nil {
-				log.Fatal(err)
-			}
-		}()
+	if err := runSessions(sessions, timeouts, workers); err != nil {
+		log.Fatal(err)
 	}
 
-	var keyShares []*keygen.LocalPartySaveData
-outer:
-	for {
-		select {
-		case err := <-errCh:
-			log.Fatal(err)
-
-		case msg := <-outCh:
-			to := msg.GetTo()
-			if to == nil { // broadcast
-				to = partyIDs
-			}
-
-			// log format:
-			// Type: binance.tsslib.ecdsa.keygen.KGRound2Message2, From: {1,P[2]}, To: all
-			fmt.Printf("%s\n", msg.String())
-
-			for _, p := range to {
-				go handlePartyMessage(parties[p.Index], msg, errCh)
-			}
-
-		case keyShare := <-endCh:
-			// in future every party will need to persist the signature
-			// for now we just print it
-			partyIdx, err := keyShare.OriginalIndex()
-			if err != nil {
-				panic(err)
-			}
-			partyID := parties[partyIdx].PartyID()
-			fmt.Printf("Patry got signature: moniker %s\n", partyID.Moniker)
-			keyShares = append(keyShares, keyShare)
-
-			if len(keyShares) == len(parties) {
-				// all parties have finished
-				break outer
-			}
-		}
+	keyShares := make([]*keygen.LocalPartySaveData, len(sessions))
+	for i := range sessions {
+		keyShares[i] = sessions[i].SaveData()
+		fmt.Printf("Party got key share: moniker %s\n", partyIDs[i].Moniker)
 	}
+
 	fmt.Println("All parties have finished, saving key shares...")
 	for i := range keyShares {
 		err := saveKeyShare(keyShares[i])
@@ -107,21 +83,25 @@ outer:
 	}
 }
 
-func handlePartyMessage(to tss.Party, msg tss.Message, errCh chan<- *tss.Error) {
-	// do not send a message from this party back to itself
-	if to.PartyID() == msg.GetFrom() {
-		return
-	}
+// runSessions runs the sessions of all parties to completion. The protocol
+// updates of all parties share a pool of workers, one per CPU if workers is 0.
+func runSessions(sessions []*session.Session, timeouts session.Timeouts, workers int) error {
+	mux := session.NewMux(workers)
 
-	bz, _, err := msg.WireBytes()
-	if err != nil {
-		errCh <- to.WrapError(err)
-		return
-	}
-	_, wErr := to.UpdateFromBytes(bz, msg.GetFrom(), msg.IsBroadcast())
-	if wErr != nil {
-		errCh <- wErr
+	var wg sync.WaitGroup
+	errs := make([]error, len(sessions))
+	for i := range sessions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sessions[i].Timeouts = timeouts
+			sessions[i].Mux = mux
+			errs[i] = sessions[i].Run(context.Background())
+		}()
 	}
+	wg.Wait()
+
+	return errors.Join(errs...)
 }
 
 func saveKeyShare(keyShare *keygen.LocalPartySaveData) error {
@@ -2,43 +2,95 @@ package main
 
 import (
 	"crypto/ecdsa"
+	"crypto/rand"
 	"fmt"
 	"log"
 	"math/big"
-	"math/rand"
 	"reflect"
+	"time"
 
 	"github.com/bnb-chain/tss-lib/v2/common"
 	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
-	"github.com/bnb-chain/tss-lib/v2/ecdsa/signing"
 	"github.com/bnb-chain/tss-lib/v2/tss"
+	"github.com/keruch/thesis/poc/chain"
+	"github.com/keruch/thesis/poc/internal/cliinput"
+	"github.com/keruch/thesis/poc/session"
 	"github.com/spf13/cobra"
 )
 
 func NewKeysignSimulateCmd() *cobra.Command {
+	var (
+		flags      simulateFlags
+		msgFlags   messageFlags
+		ethTx      string
+		chainID    string
+		sigFormats []string
+		lowS       bool
+	)
+
 	cmd := &cobra.Command{
 		Use:   "keysign-simulate",
 		Short: "Simulate TSS keysign",
+		Long: `Simulate TSS keysign of a message, or of an Ethereum transaction with
+--eth-tx. The message is given as text with --message, as hex with
+--message-hex or read from --file ("-" reads standard input), and hashed with
+--hash into the 32-byte digest ECDSA signs; a random message is signed if none
+is given. The transaction is an unsigned legacy, EIP-2930 or EIP-1559
+transaction, either as JSON or as hex-encoded RLP of its signing payload.
+The signature is printed in the encodings of --sig-format, which the verify
+command reads back. tss-lib already returns signatures with S in the lower
+half of the curve order, --low-s normalizes the printed signature all the
+same.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			keysignSimulate()
-			return nil
+			faults, err := flags.faults()
+			if err != nil {
+				return err
+			}
+			formats, err := parseSignatureFormats(sigFormats)
+			if err != nil {
+				return err
+			}
+
+			if ethTx == "" {
+				hash, digest, err := msgFlags.digest()
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Hash: %s\n", hash)
+				sig, pub := keysignSimulate(new(big.Int).SetBytes(digest), faults, flags.timeouts(), flags.workers)
+				return printSignatureData(sig, pub, digest, formats, lowS)
+			}
+
+			tx, err := parseEthereumTx(ethTx, chainID)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Signing hash: %s\n", tx.hash)
+			sig, pub := keysignSimulate(new(big.Int).SetBytes(tx.hash[:]), faults, flags.timeouts(), flags.workers)
+			if err := printSignatureData(sig, pub, tx.hash[:], formats, lowS); err != nil {
+				return err
+			}
+			return tx.printSigned(sig, pub)
 		},
 	}
+	addSimulateFlags(cmd, &flags, 2*time.Minute)
+	addMessageFlags(cmd, &msgFlags)
+	cmd.Flags().StringVar(&ethTx, "eth-tx", "", "Unsigned Ethereum transaction to sign, as JSON or RLP hex")
+	cmd.Flags().StringVar(&chainID, "chain-id", "1", "Chain ID of the Ethereum transaction")
+	cmd.Flags().StringSliceVar(&sigFormats, "sig-format", []string{"der", "compact", "recoverable", "json"}, `Signature encodings to print: "der", "compact", "recoverable" and "json"`)
+	cmd.MarkFlagsMutuallyExclusive("message", "message-hex", "file", "eth-tx")
+	// The transaction type determines the signing hash
+	cmd.MarkFlagsMutuallyExclusive("hash", "eth-tx")
 	return cmd
 }
 
-func keysignSimulate() {
+func keysignSimulate(msg *big.Int, faults session.FaultConfig, timeouts session.Timeouts, workers int) (*common.SignatureData, *ecdsa.PublicKey) {
 	partyIDs := generatePartyIDs(4)
 	ctx := tss.NewPeerContext(partyIDs)
 
 	// Select an elliptic curve
 	curve := tss.S256()
 
-	// Sey up channels for communication
-	errCh := make(chan *tss.Error, len(partyIDs))
-	outCh := make(chan tss.Message, len(partyIDs))
-	endCh := make(chan *common.SignatureData, len(partyIDs))
-
 	// TODO: make threshold configurable
 	const threshold = 3
 
@@ -51,59 +103,24 @@ func keysignSimulate() {
 		keyShares[i] = ks
 	}
 
-	rawMsg := rand.Int63()
-	msg := big.NewInt(rawMsg)
-	fmt.Printf("Message: %s\n", msg)
+	// Parties exchange messages through an in-memory transport
+	network := session.NewMemoryNetwork()
 
-	parties := make([]*signing.LocalParty, len(partyIDs))
+	sessions := make([]*session.Session, len(partyIDs))
 	for i := range partyIDs {
 		// Create the party
 		params := tss.NewParameters(curve, ctx, partyIDs[i], len(partyIDs), threshold)
-		party := signing.NewLocalParty(msg, params, *keyShares[i], outCh, endCh)
-		parties[i] = party.(*signing.LocalParty)
-		fmt.Printf("Created party: Index %d, Moniker %s, PartyID %s\n", party.PartyID().Index, party.PartyID().Moniker, party.PartyID().Id)
+		sessions[i] = session.NewSigning(keysignSessionID, params, msg, *keyShares[i], simulatedTransport(network, partyIDs[i].Id, faults))
+		fmt.Printf("Created party: Index %d, Moniker %s, PartyID %s\n", partyIDs[i].Index, partyIDs[i].Moniker, partyIDs[i].Id)
 	}
 
-	for i := range parties {
-		go func() {
-			if err := parties[i].Start(); err != nil {
-				log.Fatal(err)
-			}
-		}()
+	if err := runSessions(sessions, timeouts, workers); err != nil {
+		log.Fatal(err)
 	}
 
-	var signatures []*common.SignatureData
-outer:
-	for {
-		select {
-		case err := <-errCh:
-			log.Fatal(err)
-
-		case msg := <-outCh:
-			to := msg.GetTo()
-			if to == nil { // broadcast
-				to = partyIDs
-			}
-
-			// log format:
-			// Type: binance.tsslib.ecdsa.keygen.KGRound2Message2, From: {1,P[2]}, To: all
-			fmt.Printf("%s\n", msg.String())
-
-			for _, p := range to {
-				go handlePartyMessage(parties[p.Index], msg, errCh)
-			}
-
-		case signature := <-endCh:
-			// in future every party will need to persist the signature
-			// for now we just print it
-			fmt.Printf("Patry got signature\n")
-			signatures = append(signatures, signature)
-
-			if len(signatures) == len(parties) {
-				// all parties have finished
-				break outer
-			}
-		}
+	signatures := make([]*common.SignatureData, len(sessions))
+	for i := range sessions {
+		signatures[i] = sessions[i].Signature()
 	}
 
 	fmt.Println("All parties have finished, validating signatures...")
@@ -136,4 +153,51 @@ outer:
 	fmt.Println("M: ", msg.String())
 	fmt.Println("R: ", r.String())
 	fmt.Println("S: ", s.String())
+
+	return sig, &pk
+}
+
+// messageFlags selects the message to sign and the hash turning it into the
+// signed digest.
+type messageFlags struct {
+	message    string
+	messageHex string
+	file       string
+	hash       string
+}
+
+func addMessageFlags(cmd *cobra.Command, f *messageFlags) {
+	cmd.Flags().StringVarP(&f.message, "message", "m", "", "Message to sign")
+	cmd.Flags().StringVar(&f.messageHex, "message-hex", "", "Hex-encoded message to sign")
+	cmd.Flags().StringVarP(&f.file, "file", "f", "", "File with the message to sign")
+	cmd.Flags().StringVar(&f.hash, "hash", string(chain.HashSHA256), `Hash of the message: "sha256", "keccak256", "sha512_256" or "none"`)
+}
+
+// digest returns the digest to sign. Without a message, a random one is
+// generated.
+func (f *messageFlags) digest() (chain.Hash, []byte, error) {
+	hash, err := chain.ParseHash(f.hash)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var msg []byte
+	if f.message == "" && f.messageHex == "" && f.file == "" {
+		msg = make([]byte, 32)
+		if _, err := rand.Read(msg); err != nil {
+			return "", nil, fmt.Errorf("failed to generate message: %w", err)
+		}
+		fmt.Printf("Message: %x\n", msg)
+	} else if msg, err = cliinput.ReadMessage(f.message, f.messageHex, f.file); err != nil {
+		return "", nil, err
+	}
+
+	digest, err := hash.Digest(msg)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(digest) != 32 {
+		return "", nil, fmt.Errorf("ECDSA signs 32-byte digests, got %d bytes (use --hash)", len(digest))
+	}
+	return hash, digest, nil
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeysignSimulateRejectsHashWithEthTx(t *testing.T) {
+	cmd := NewKeysignSimulateCmd()
+	cmd.SetArgs([]string{"--eth-tx", "{}", "--hash", "keccak256"})
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "hash") {
+		t.Fatalf("expected --hash and --eth-tx to be rejected together, got %v", err)
+	}
+}
+
+func TestMessageFlagsDigest(t *testing.T) {
+	f := messageFlags{file: filepath.Join(t.TempDir(), "missing"), hash: "sha256"}
+	if _, _, err := f.digest(); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected a missing message file to be reported, got %v", err)
+	}
+
+	f = messageFlags{messageHex: "zz", hash: "sha256"}
+	if _, _, err := f.digest(); err == nil {
+		t.Fatal("invalid message hex was accepted")
+	}
+
+	f = messageFlags{hash: "none"}
+	if _, digest, err := f.digest(); err != nil || len(digest) != 32 {
+		t.Fatalf("random message: digest %x, err %v", digest, err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bnb-chain/tss-lib/v2/common"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/keruch/thesis/poc/chain"
+	"github.com/spf13/cobra"
+)
+
+func NewVerifyCmd() *cobra.Command {
+	var (
+		pubKey    string
+		digestHex string
+		sigStr    string
+		lowS      bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify an ECDSA signature offline",
+		Long: `Verify a secp256k1 ECDSA signature of a digest. The signature is given as hex
+in DER, compact (64-byte) or recoverable (65-byte) encoding, or as the JSON
+printed by keysign-simulate. Without --pubkey, the public key is recovered from
+a recoverable signature. The signature is printed back in all encodings.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return verifySignature(pubKey, digestHex, sigStr, lowS)
+		},
+	}
+
+	cmd.Flags().StringVar(&pubKey, "pubkey", "", "Hex-encoded compressed or uncompressed public key of the signer")
+	cmd.Flags().StringVar(&digestHex, "digest", "", "Hex-encoded signed digest")
+	cmd.Flags().StringVar(&sigStr, "sig", "", "Signature to verify")
+	cmd.Flags().BoolVar(&lowS, "low-s", false, "Normalize S to the lower half of the curve order before printing the signature")
+	cmd.MarkFlagRequired("digest")
+	cmd.MarkFlagRequired("sig")
+	return cmd
+}
+
+func verifySignature(pubKeyHex, digestHex, sigStr string, lowS bool) error {
+	digest, err := hex.DecodeString(strings.TrimPrefix(digestHex, "0x"))
+	if err != nil || len(digest) == 0 {
+		return fmt.Errorf("invalid digest: %s", digestHex)
+	}
+	parsed, err := chain.ParseSignature([]byte(sigStr))
+	if err != nil {
+		return fmt.Errorf("parse signature: %v", err)
+	}
+	sig := parsed.Signature
+
+	var pub *ecdsa.PublicKey
+	if pubKeyHex != "" {
+		raw, err := hex.DecodeString(strings.TrimPrefix(pubKeyHex, "0x"))
+		if err != nil {
+			return fmt.Errorf("invalid public key: %v", err)
+		}
+		key, err := btcec.ParsePubKey(raw)
+		if err != nil {
+			return fmt.Errorf("invalid public key: %v", err)
+		}
+		pub = key.ToECDSA()
+	}
+
+	if parsed.Recoverable {
+		recovered, err := sig.RecoverPublicKey(digest)
+		if err != nil {
+			return fmt.Errorf("signature is invalid: %v", err)
+		}
+		fmt.Printf("Recovered public key: %x\n", compressPubKey(recovered))
+		if pub == nil {
+			pub = recovered
+		} else if !pub.Equal(recovered) {
+			return errors.New("signature is invalid: it recovers to another public key")
+		}
+	}
+	if pub == nil {
+		return fmt.Errorf("a %s signature has no recovery ID, --pubkey is required", parsed.Format)
+	}
+
+	if !sig.Verify(pub, digest) {
+		return errors.New("signature is invalid")
+	}
+	if !parsed.Recoverable {
+		if sig, err = sig.WithRecoveryID(pub, digest); err != nil {
+			return fmt.Errorf("signature is invalid: %v", err)
+		}
+	}
+
+	fmt.Printf("Format: %s\n", parsed.Format)
+	fmt.Printf("Low S: %t\n", sig.IsLowS())
+	fmt.Println("Signature is valid")
+
+	if lowS {
+		sig = sig.Normalize()
+	}
+	return printSignature(sig, chain.Formats)
+}
+
+// printSignature prints sig in the given encodings, binary ones as hex.
+func printSignature(sig chain.Signature, formats []chain.Format) error {
+	for _, format := range formats {
+		encoded, err := sig.Encode(format)
+		if err != nil {
+			return err
+		}
+		if format == chain.FormatJSON {
+			fmt.Printf("Signature (%s): %s\n", format, encoded)
+		} else {
+			fmt.Printf("Signature (%s): %x\n", format, encoded)
+		}
+	}
+	return nil
+}
+
+// printSignatureData prints the output of a signing session in the given
+// encodings, together with the public key and the signed digest. With lowS,
+// S is normalized to the lower half of the curve order first.
+func printSignatureData(data *common.SignatureData, pub *ecdsa.PublicKey, digest []byte, formats []chain.Format, lowS bool) error {
+	sig, err := chain.FromTSS(data)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Public key: %x\n", compressPubKey(pub))
+	fmt.Printf("Digest: %x\n", digest)
+	fmt.Printf("Low S: %t\n", sig.IsLowS())
+	if lowS {
+		sig = sig.Normalize()
+	}
+	return printSignature(sig, formats)
+}
+
+func parseSignatureFormats(names []string) ([]chain.Format, error) {
+	formats := make([]chain.Format, len(names))
+	for i, name := range names {
+		format, err := chain.ParseFormat(name)
+		if err != nil {
+			return nil, err
+		}
+		formats[i] = format
+	}
+	return formats, nil
+}
+
+func compressPubKey(pub *ecdsa.PublicKey) []byte {
+	return elliptic.MarshalCompressed(pub.Curve, pub.X, pub.Y)
+}
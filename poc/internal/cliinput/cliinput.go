@@ -0,0 +1,45 @@
+// Package cliinput reads the messages and documents given to the command line
+// tools as flags, files or standard input.
+package cliinput
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ReadInput reads file, or standard input if file is "-".
+func ReadInput(file string) ([]byte, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	return data, nil
+}
+
+// ReadMessage returns the message given as text, as hex or in file, whichever
+// is set.
+func ReadMessage(message, messageHex, file string) ([]byte, error) {
+	switch {
+	case messageHex != "":
+		msg, err := hex.DecodeString(strings.TrimPrefix(messageHex, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid message hex: %w", err)
+		}
+		return msg, nil
+	case file != "":
+		return ReadInput(file)
+	default:
+		return []byte(message), nil
+	}
+}
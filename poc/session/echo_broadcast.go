@@ -0,0 +1,260 @@
+package session
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// EquivocationError reports a member that provably sent different broadcast
+// messages of the same type to different members. Witness is the member whose
+// echo revealed the conflict, or this node if it received both versions itself.
+type EquivocationError struct {
+	Sender  string
+	MsgType string
+	Witness string
+}
+
+func (e *EquivocationError) Error() string {
+	return fmt.Sprintf("%s sent conflicting %s broadcasts (reported by %s)", e.Sender, e.MsgType, e.Witness)
+}
+
+// EchoConflictError reports echoes that disagree with the broadcast this node
+// received from Sender, where it can't tell whether Sender equivocated or the
+// Witnesses misreported what they received.
+type EchoConflictError struct {
+	Sender    string
+	MsgType   string
+	Witnesses []string
+}
+
+func (e *EchoConflictError) Error() string {
+	return fmt.Sprintf("echoes of the %s broadcast of %s conflict (reported by %s)", e.MsgType, e.Sender, strings.Join(e.Witnesses, ", "))
+}
+
+// InvalidEchoError reports an echo that does not cover every party member or
+// misreports the broadcast of this node.
+type InvalidEchoError struct {
+	From    string
+	MsgType string
+}
+
+func (e *InvalidEchoError) Error() string {
+	return fmt.Sprintf("invalid %s echo from %s", e.MsgType, e.From)
+}
+
+// echoBroadcast makes the broadcast channel of a transport reliable, as
+// tss-lib requires. Once a member has received the broadcast of a message
+// type from every member, it sends the digests of what it received to the
+// others. Messages of later rounds and the protocol output are held back
+// until every member reported the same digests, so an equivocating sender is
+// caught before anyone acts on its broadcast.
+type echoBroadcast struct {
+	self    string
+	members []string // Party IDs of all members, including self
+
+	mu sync.Mutex
+	// Digests of the broadcasts this node received (or sent), by message type and sender
+	digests map[string]map[string][]byte
+	// Digests reported by the other members, by message type and reporting member
+	echoes   map[string]map[string]map[string][]byte
+	verified map[string]bool
+	// Message types whose echo this node still has to send; the other members
+	// may still be waiting for it, so the session must not end before it is sent
+	echoing map[string]bool
+
+	// Signalled whenever a message type has been verified or echoed
+	notify chan struct{}
+}
+
+func newEchoBroadcast(self string, members []string) *echoBroadcast {
+	return &echoBroadcast{
+		self:     self,
+		members:  members,
+		digests:  make(map[string]map[string][]byte),
+		echoes:   make(map[string]map[string]map[string][]byte),
+		verified: make(map[string]bool),
+		echoing:  make(map[string]bool),
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// recordBroadcast records a broadcast of msgType authored by from. Once the
+// broadcasts of every member are known, it returns the digests this node
+// must echo to the other members.
+func (eb *echoBroadcast) recordBroadcast(msgType, from string, wireBytes []byte) (map[string][]byte, error) {
+	digest := sha256.Sum256(wireBytes)
+
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	received, exists := eb.digests[msgType]
+	if !exists {
+		received = make(map[string][]byte, len(eb.members))
+		eb.digests[msgType] = received
+	}
+	if prev, exists := received[from]; exists {
+		if !bytes.Equal(prev, digest[:]) {
+			return nil, &EquivocationError{Sender: from, MsgType: msgType, Witness: eb.self}
+		}
+		return nil, nil
+	}
+	received[from] = digest[:]
+
+	if len(received) < len(eb.members) {
+		return nil, nil
+	}
+
+	echo := make(map[string][]byte, len(received))
+	for sender, d := range received {
+		echo[sender] = d
+	}
+	eb.echoing[msgType] = true
+	return echo, eb.checkLocked(msgType)
+}
+
+// recordEcho records the digests of msgType broadcasts reported by from.
+func (eb *echoBroadcast) recordEcho(msgType, from string, digests map[string][]byte) error {
+	if len(digests) != len(eb.members) {
+		return &InvalidEchoError{From: from, MsgType: msgType}
+	}
+	for _, member := range eb.members {
+		if _, ok := digests[member]; !ok {
+			return &InvalidEchoError{From: from, MsgType: msgType}
+		}
+	}
+
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	echoes, exists := eb.echoes[msgType]
+	if !exists {
+		echoes = make(map[string]map[string][]byte, len(eb.members))
+		eb.echoes[msgType] = echoes
+	}
+	if _, exists := echoes[from]; exists {
+		return nil
+	}
+	echoes[from] = digests
+
+	return eb.checkLocked(msgType)
+}
+
+// checkLocked compares the echoes of msgType with the broadcasts this node
+// received, marking the type verified once every other member agreed.
+//
+// A conflicting echo alone does not show who lied. The sender is only blamed
+// if its own echo contradicts the broadcast it sent this node, or if most
+// members besides the sender contradict it, assuming those are mostly
+// honest. An echo that misreports this node's own broadcast is the witness's
+// fault. Any other conflict fails the session without a culprit once every
+// echo is in.
+func (eb *echoBroadcast) checkLocked(msgType string) error {
+	if eb.verified[msgType] {
+		return nil
+	}
+
+	received := eb.digests[msgType]
+	if len(received) < len(eb.members) {
+		return nil
+	}
+
+	echoes := eb.echoes[msgType]
+	var conflict *EchoConflictError
+	for _, sender := range eb.members {
+		var witnesses []string
+		for _, reporter := range eb.members {
+			reported, echoed := echoes[reporter]
+			if !echoed || bytes.Equal(received[sender], reported[sender]) {
+				continue
+			}
+			switch {
+			case sender == eb.self:
+				return &InvalidEchoError{From: reporter, MsgType: msgType}
+			case reporter == sender:
+				return &EquivocationError{Sender: sender, MsgType: msgType, Witness: reporter}
+			}
+			witnesses = append(witnesses, reporter)
+		}
+		if len(witnesses) == 0 {
+			continue
+		}
+		if 2*len(witnesses) > len(eb.members)-1 {
+			return &EquivocationError{Sender: sender, MsgType: msgType, Witness: witnesses[0]}
+		}
+		if conflict == nil {
+			conflict = &EchoConflictError{Sender: sender, MsgType: msgType, Witnesses: witnesses}
+		}
+	}
+	if len(echoes) < len(eb.members)-1 {
+		return nil
+	}
+	if conflict != nil {
+		return conflict
+	}
+
+	eb.verified[msgType] = true
+	eb.signal()
+	return nil
+}
+
+// echoed marks the echo of msgType as sent.
+func (eb *echoBroadcast) echoed(msgType string) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	delete(eb.echoing, msgType)
+	eb.signal()
+}
+
+func (eb *echoBroadcast) signal() {
+	select {
+	case eb.notify <- struct{}{}:
+	default:
+	}
+}
+
+// settled reports whether every broadcast of the rounds before round has
+// been verified and echoed. Pass 0 to check all rounds.
+func (eb *echoBroadcast) settled(round int) bool {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	for msgType := range eb.digests {
+		if round > 0 && typeRound(msgType) >= round {
+			continue
+		}
+		if !eb.verified[msgType] || eb.echoing[msgType] {
+			return false
+		}
+	}
+	return true
+}
+
+// waitingFor returns the members whose echo this node still needs.
+func (eb *echoBroadcast) waitingFor() []string {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	missing := make(map[string]struct{})
+	for msgType, received := range eb.digests {
+		if eb.verified[msgType] || len(received) < len(eb.members) {
+			continue
+		}
+		for _, member := range eb.members {
+			if _, echoed := eb.echoes[msgType][member]; !echoed && member != eb.self {
+				missing[member] = struct{}{}
+			}
+		}
+	}
+
+	result := make([]string, 0, len(missing))
+	for member := range missing {
+		result = append(result, member)
+	}
+	sort.Strings(result)
+	return result
+}
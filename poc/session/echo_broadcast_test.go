@@ -0,0 +1,174 @@
+package session
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bnb-chain/tss-lib/v2/tss"
+)
+
+// equivocatingTransport sends a second version of every broadcast to the
+// victims. The second version carries an extra protobuf field, so it parses
+// to the same message but has a different digest. If coverTracks is set, the
+// echoes sent to the victims report the version they received.
+type equivocatingTransport struct {
+	Transport
+	self        string
+	members     []string
+	victims     map[string]bool
+	coverTracks bool
+
+	mu       sync.Mutex
+	tampered map[string][]byte // Digest of the second version by digest of the first
+}
+
+func (t *equivocatingTransport) Broadcast(ctx context.Context, msg *Message) error {
+	if !msg.Broadcast {
+		return t.Transport.Broadcast(ctx, msg)
+	}
+
+	second := *msg
+	second.WireBytes = append(append([]byte(nil), msg.WireBytes...), 0x78, 0x01)
+	digest, tampered := sha256.Sum256(msg.WireBytes), sha256.Sum256(second.WireBytes)
+	t.mu.Lock()
+	t.tampered[string(digest[:])] = tampered[:]
+	t.mu.Unlock()
+
+	for _, member := range t.members {
+		if member == t.self {
+			continue
+		}
+		out := msg
+		if t.victims[member] {
+			out = &second
+		}
+		_ = t.Send(ctx, member, out)
+	}
+	return ctx.Err()
+}
+
+func (t *equivocatingTransport) Send(ctx context.Context, to string, msg *Message) error {
+	if !t.coverTracks || !msg.IsEcho() || !t.victims[to] {
+		return t.Transport.Send(ctx, to, msg)
+	}
+
+	t.mu.Lock()
+	tampered := t.tampered[string(msg.Digests[t.self])]
+	t.mu.Unlock()
+	return t.Transport.Send(ctx, to, withDigest(msg, t.self, tampered))
+}
+
+// lyingTransport misreports the broadcast of sender in every echo.
+type lyingTransport struct {
+	Transport
+	sender string
+}
+
+func (t *lyingTransport) Send(ctx context.Context, to string, msg *Message) error {
+	if !msg.IsEcho() {
+		return t.Transport.Send(ctx, to, msg)
+	}
+	forged := sha256.Sum256([]byte("forged"))
+	return t.Transport.Send(ctx, to, withDigest(msg, t.sender, forged[:]))
+}
+
+// withDigest returns a copy of the echo msg reporting digest for sender.
+func withDigest(msg *Message, sender string, digest []byte) *Message {
+	out := *msg
+	out.Digests = make(map[string][]byte, len(msg.Digests))
+	for member, d := range msg.Digests {
+		out.Digests[member] = d
+	}
+	out.Digests[sender] = digest
+	return &out
+}
+
+// culprits returns the party IDs blamed by a session error.
+func culprits(err error) []string {
+	var tssErr *tss.Error
+	if !errors.As(err, &tssErr) {
+		return nil
+	}
+	ids := make([]string, 0, len(tssErr.Culprits()))
+	for _, culprit := range tssErr.Culprits() {
+		ids = append(ids, culprit.Id)
+	}
+	return ids
+}
+
+func TestEchoBroadcastBlame(t *testing.T) {
+	ids := make([]string, testParties)
+	for i, partyID := range testPartyIDs() {
+		ids[i] = partyID.Id
+	}
+
+	tests := []struct {
+		name string
+		// The dishonest party and its transport
+		dishonest int
+		transport func(Transport) Transport
+		// The culprits blamed by each honest party
+		blames map[int][]string
+	}{
+		{
+			// The victims get the sender's own echo contradicting what it sent them
+			name:      "equivocating sender",
+			dishonest: 0,
+			transport: func(tr Transport) Transport {
+				return &equivocatingTransport{Transport: tr, self: ids[0], members: ids, victims: map[string]bool{ids[2]: true, ids[3]: true}, tampered: make(map[string][]byte)}
+			},
+			blames: map[int][]string{1: {ids[0]}, 2: {ids[0]}, 3: {ids[0]}},
+		},
+		{
+			// Only the party contradicted by most other members can tell
+			name:      "equivocating sender covering its tracks",
+			dishonest: 0,
+			transport: func(tr Transport) Transport {
+				return &equivocatingTransport{Transport: tr, self: ids[0], members: ids, victims: map[string]bool{ids[2]: true, ids[3]: true}, coverTracks: true, tampered: make(map[string][]byte)}
+			},
+			blames: map[int][]string{1: {ids[0]}, 2: nil, 3: nil},
+		},
+		{
+			// The sender knows what it sent, the others can't tell who lies
+			name:      "lying witness",
+			dishonest: 3,
+			transport: func(tr Transport) Transport {
+				return &lyingTransport{Transport: tr, sender: ids[0]}
+			},
+			blames: map[int][]string{0: {ids[3]}, 1: nil, 2: nil},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sessions := newTestSigningSessions(t, NewMemoryNetwork(), "test-signing")
+			for i, s := range sessions {
+				s.Timeouts.Round = 10 * time.Second
+				if i == tc.dishonest {
+					s.transport = tc.transport(s.transport)
+					s.Timeouts.Round = 2 * time.Second
+				}
+			}
+
+			errs := runAll(sessions)
+			for i, want := range tc.blames {
+				var (
+					equivocationErr *EquivocationError
+					conflictErr     *EchoConflictError
+					invalidErr      *InvalidEchoError
+				)
+				if !errors.As(errs[i], &equivocationErr) && !errors.As(errs[i], &conflictErr) && !errors.As(errs[i], &invalidErr) {
+					t.Fatalf("session %d: expected an echo broadcast error, got %v", i, errs[i])
+				}
+				if got := culprits(errs[i]); fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Fatalf("session %d blamed %v, want %v: %v", i, got, want, errs[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package session
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FaultConfig describes the network faults injected into the messages a
+// party receives.
+type FaultConfig struct {
+	// Latency delays every message; nil delivers messages right away
+	Latency LatencyDistribution
+	// DropRate is the probability that a message is lost
+	DropRate float64
+	// DuplicateRate is the probability that a message is delivered twice
+	DuplicateRate float64
+	// ReorderRate is the probability that a message is held back by
+	// ReorderDelay, letting later messages overtake it
+	ReorderRate  float64
+	ReorderDelay time.Duration
+	// Partitions cut parties off from the rest of the network for a while
+	Partitions []Partition
+	// Seed makes the injected faults reproducible; 0 picks a random seed
+	Seed int64
+}
+
+func (c FaultConfig) Enabled() bool {
+	return c.Latency != nil || c.DropRate > 0 || c.DuplicateRate > 0 || c.ReorderRate > 0 || len(c.Partitions) > 0
+}
+
+// Partition isolates Parties from all other parties between Start and
+// Start+Duration, measured from the moment the transport was created.
+type Partition struct {
+	Parties  []string
+	Start    time.Duration
+	Duration time.Duration
+}
+
+func (p Partition) contains(partyID string) bool {
+	for _, id := range p.Parties {
+		if id == partyID {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Partition) separates(a, b string, elapsed time.Duration) bool {
+	if elapsed < p.Start || elapsed >= p.Start+p.Duration {
+		return false
+	}
+	return p.contains(a) != p.contains(b)
+}
+
+// ParsePartition parses a partition such as "party-1,party-2@5s+10s": the
+// parties are isolated 5 seconds after start for 10 seconds.
+func ParsePartition(s string) (Partition, error) {
+	parties, window, found := strings.Cut(s, "@")
+	if !found {
+		return Partition{}, fmt.Errorf("invalid partition %q: expected <parties>@<start>+<duration>", s)
+	}
+	start, duration, found := strings.Cut(window, "+")
+	if !found {
+		return Partition{}, fmt.Errorf("invalid partition %q: expected <parties>@<start>+<duration>", s)
+	}
+
+	var p Partition
+	for _, id := range strings.Split(parties, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			p.Parties = append(p.Parties, id)
+		}
+	}
+	if len(p.Parties) == 0 {
+		return Partition{}, fmt.Errorf("invalid partition %q: no parties", s)
+	}
+
+	var err error
+	if p.Start, err = time.ParseDuration(start); err != nil {
+		return Partition{}, fmt.Errorf("invalid partition start %q: %w", start, err)
+	}
+	if p.Duration, err = time.ParseDuration(duration); err != nil {
+		return Partition{}, fmt.Errorf("invalid partition duration %q: %w", duration, err)
+	}
+	return p, nil
+}
+
+// LatencyDistribution samples the delay of a single message.
+type LatencyDistribution interface {
+	Sample(rng *rand.Rand) time.Duration
+}
+
+type FixedLatency time.Duration
+
+func (l FixedLatency) Sample(*rand.Rand) time.Duration {
+	return time.Duration(l)
+}
+
+type UniformLatency struct {
+	Min, Max time.Duration
+}
+
+func (l UniformLatency) Sample(rng *rand.Rand) time.Duration {
+	if l.Max <= l.Min {
+		return l.Min
+	}
+	return l.Min + time.Duration(rng.Int63n(int64(l.Max-l.Min)))
+}
+
+type NormalLatency struct {
+	Mean, StdDev time.Duration
+}
+
+func (l NormalLatency) Sample(rng *rand.Rand) time.Duration {
+	d := l.Mean + time.Duration(rng.NormFloat64()*float64(l.StdDev))
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// ParseLatency parses a latency distribution: "50ms" for a fixed latency,
+// "uniform:10ms-100ms" or "normal:100ms,20ms" for a mean and standard deviation.
+func ParseLatency(s string) (LatencyDistribution, error) {
+	kind, args, found := strings.Cut(s, ":")
+	if !found {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid latency %q: %w", s, err)
+		}
+		return FixedLatency(d), nil
+	}
+
+	var sep string
+	switch kind {
+	case "uniform":
+		sep = "-"
+	case "normal":
+		sep = ","
+	default:
+		return nil, fmt.Errorf("unknown latency distribution: %s", kind)
+	}
+
+	first, second, found := strings.Cut(args, sep)
+	if !found {
+		return nil, fmt.Errorf("invalid %s latency %q", kind, args)
+	}
+	a, err := time.ParseDuration(first)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s latency %q: %w", kind, args, err)
+	}
+	b, err := time.ParseDuration(second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s latency %q: %w", kind, args, err)
+	}
+
+	if kind == "uniform" {
+		return UniformLatency{Min: a, Max: b}, nil
+	}
+	return NormalLatency{Mean: a, StdDev: b}, nil
+}
+
+// faultyTransport injects faults into the messages received over another
+// transport. Faults are applied on the receiving side so that every
+// recipient of a broadcast is affected independently.
+type faultyTransport struct {
+	Transport
+
+	self  string
+	cfg   FaultConfig
+	start time.Time
+
+	rng   *rand.Rand
+	rngMu sync.Mutex
+
+	sessions map[string]chan struct{}
+	mu       sync.Mutex
+}
+
+func NewFaultyTransport(inner Transport, self string, cfg FaultConfig) Transport {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	// Parties sharing a seed must still see different faults
+	h := fnv.New64a()
+	h.Write([]byte(self))
+
+	return &faultyTransport{
+		Transport: inner,
+		self:      self,
+		cfg:       cfg,
+		start:     time.Now(),
+		rng:       rand.New(rand.NewSource(seed ^ int64(h.Sum64()))),
+		sessions:  make(map[string]chan struct{}),
+	}
+}
+
+func (t *faultyTransport) Receive(sessionID string) <-chan *Message {
+	in := t.Transport.Receive(sessionID)
+	out := make(chan *Message, MailboxSize)
+	done := make(chan struct{})
+
+	t.mu.Lock()
+	t.sessions[sessionID] = done
+	t.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case msg := <-in:
+				t.inject(msg, out, done)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+func (t *faultyTransport) Leave(sessionID string) {
+	t.mu.Lock()
+	if done, exists := t.sessions[sessionID]; exists {
+		close(done)
+		delete(t.sessions, sessionID)
+	}
+	t.mu.Unlock()
+
+	t.Transport.Leave(sessionID)
+}
+
+func (t *faultyTransport) inject(msg *Message, out chan<- *Message, done <-chan struct{}) {
+	elapsed := time.Since(t.start)
+	for _, p := range t.cfg.Partitions {
+		if p.separates(msg.From, t.self, elapsed) {
+			return
+		}
+	}
+
+	t.rngMu.Lock()
+	if t.rng.Float64() < t.cfg.DropRate {
+		t.rngMu.Unlock()
+		return
+	}
+	copies := 1
+	if t.rng.Float64() < t.cfg.DuplicateRate {
+		copies = 2
+	}
+	delays := make([]time.Duration, copies)
+	for i := range delays {
+		if t.cfg.Latency != nil {
+			delays[i] = t.cfg.Latency.Sample(t.rng)
+		}
+		if t.rng.Float64() < t.cfg.ReorderRate {
+			delays[i] += t.cfg.ReorderDelay
+		}
+	}
+	t.rngMu.Unlock()
+
+	deliver := func() {
+		select {
+		case out <- msg:
+		case <-done:
+		}
+	}
+	for _, d := range delays {
+		if d <= 0 {
+			deliver()
+			continue
+		}
+		time.AfterFunc(d, deliver)
+	}
+}
@@ -0,0 +1,141 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const testSessionID = "test-session"
+
+// receiveAll collects the messages received within the given time.
+func receiveAll(ch <-chan *Message, wait time.Duration) []*Message {
+	var msgs []*Message
+	timeout := time.After(wait)
+	for {
+		select {
+		case msg := <-ch:
+			msgs = append(msgs, msg)
+		case <-timeout:
+			return msgs
+		}
+	}
+}
+
+func sendN(t *testing.T, transport Transport, to string, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		msg := &Message{SessionID: testSessionID, From: "a", WireBytes: []byte{byte(i)}}
+		if err := transport.Send(context.Background(), to, msg); err != nil {
+			t.Fatalf("failed to send message: %v", err)
+		}
+	}
+}
+
+func TestFaultyTransport(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  FaultConfig
+		want func(received int) bool
+	}{
+		{
+			name: "no faults",
+			cfg:  FaultConfig{},
+			want: func(received int) bool { return received == 100 },
+		},
+		{
+			name: "drop all",
+			cfg:  FaultConfig{DropRate: 1},
+			want: func(received int) bool { return received == 0 },
+		},
+		{
+			name: "duplicate all",
+			cfg:  FaultConfig{DuplicateRate: 1},
+			want: func(received int) bool { return received == 200 },
+		},
+		{
+			name: "drop some",
+			cfg:  FaultConfig{DropRate: 0.5, Seed: 1},
+			want: func(received int) bool { return received > 20 && received < 80 },
+		},
+		{
+			name: "partitioned",
+			cfg:  FaultConfig{Partitions: []Partition{{Parties: []string{"a"}, Duration: time.Hour}}},
+			want: func(received int) bool { return received == 0 },
+		},
+		{
+			name: "partition over",
+			cfg:  FaultConfig{Partitions: []Partition{{Parties: []string{"a"}, Start: time.Hour, Duration: time.Hour}}},
+			want: func(received int) bool { return received == 100 },
+		},
+		{
+			name: "latency",
+			cfg:  FaultConfig{Latency: UniformLatency{Min: time.Millisecond, Max: 10 * time.Millisecond}},
+			want: func(received int) bool { return received == 100 },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network := NewMemoryNetwork()
+			sender := network.Transport("a")
+			receiver := NewFaultyTransport(network.Transport("b"), "b", tt.cfg)
+
+			inbox := receiver.Receive(testSessionID)
+			defer receiver.Leave(testSessionID)
+
+			sendN(t, sender, "b", 100)
+
+			received := len(receiveAll(inbox, 200*time.Millisecond))
+			if !tt.want(received) {
+				t.Fatalf("unexpected number of received messages: %d", received)
+			}
+		})
+	}
+}
+
+func TestParseLatency(t *testing.T) {
+	tests := []struct {
+		in   string
+		want LatencyDistribution
+	}{
+		{"50ms", FixedLatency(50 * time.Millisecond)},
+		{"uniform:10ms-100ms", UniformLatency{Min: 10 * time.Millisecond, Max: 100 * time.Millisecond}},
+		{"normal:100ms,20ms", NormalLatency{Mean: 100 * time.Millisecond, StdDev: 20 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		got, err := ParseLatency(tt.in)
+		if err != nil {
+			t.Fatalf("ParseLatency(%q) failed: %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Fatalf("ParseLatency(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"fast", "uniform:10ms", "pareto:1ms,2ms"} {
+		if _, err := ParseLatency(in); err == nil {
+			t.Fatalf("ParseLatency(%q) should fail", in)
+		}
+	}
+}
+
+func TestParsePartition(t *testing.T) {
+	p, err := ParsePartition("party-1, party-2@5s+10s")
+	if err != nil {
+		t.Fatalf("ParsePartition failed: %v", err)
+	}
+	if len(p.Parties) != 2 || p.Parties[0] != "party-1" || p.Parties[1] != "party-2" {
+		t.Fatalf("unexpected parties: %v", p.Parties)
+	}
+	if p.Start != 5*time.Second || p.Duration != 10*time.Second {
+		t.Fatalf("unexpected window: %s+%s", p.Start, p.Duration)
+	}
+
+	for _, in := range []string{"party-1", "party-1@5s", "@5s+10s", "party-1@soon+10s"} {
+		if _, err := ParsePartition(in); err == nil {
+			t.Fatalf("ParsePartition(%q) should fail", in)
+		}
+	}
+}
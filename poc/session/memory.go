@@ -0,0 +1,76 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// MemoryNetwork connects in-process parties, e.g. to simulate a protocol run
+// on a single machine.
+type MemoryNetwork struct {
+	parties map[string]*Mailboxes
+	mu      sync.RWMutex
+}
+
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		parties: make(map[string]*Mailboxes),
+	}
+}
+
+// Transport returns the transport of the party with the given ID, adding the
+// party to the network.
+func (n *MemoryNetwork) Transport(partyID string) Transport {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	mailboxes, exists := n.parties[partyID]
+	if !exists {
+		mailboxes = NewMailboxes()
+		n.parties[partyID] = mailboxes
+	}
+
+	return &memoryTransport{
+		Mailboxes: mailboxes,
+		self:      partyID,
+		network:   n,
+	}
+}
+
+type memoryTransport struct {
+	*Mailboxes
+	self    string
+	network *MemoryNetwork
+}
+
+func (t *memoryTransport) Send(ctx context.Context, to string, msg *Message) error {
+	t.network.mu.RLock()
+	mailboxes, exists := t.network.parties[to]
+	t.network.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("unknown party: %s", to)
+	}
+
+	if !mailboxes.Deliver(ctx, msg) {
+		return fmt.Errorf("party %s is not in session %s", to, msg.SessionID)
+	}
+	return nil
+}
+
+// Broadcast delivers msg to every other party that receives the session's messages.
+func (t *memoryTransport) Broadcast(ctx context.Context, msg *Message) error {
+	t.network.mu.RLock()
+	recipients := make([]*Mailboxes, 0, len(t.network.parties))
+	for partyID, mailboxes := range t.network.parties {
+		if partyID != t.self {
+			recipients = append(recipients, mailboxes)
+		}
+	}
+	t.network.mu.RUnlock()
+
+	for _, mailboxes := range recipients {
+		mailboxes.Deliver(ctx, msg)
+	}
+	return ctx.Err()
+}
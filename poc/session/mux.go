@@ -0,0 +1,105 @@
+package session
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+)
+
+// QueueSize is the number of tasks queued per key before Submit fails.
+const QueueSize = MailboxSize
+
+// ErrQueueFull is returned by Submit when the queue of a key is full.
+var ErrQueueFull = errors.New("queue is full")
+
+var defaultMux = NewMux(0)
+
+// Mux runs tasks on a bounded pool of workers. Tasks are queued by key,
+// usually a session ID: the tasks of a key run one at a time in submission
+// order, while the tasks of different keys run in parallel. Workers take
+// turns between the keys with queued tasks, so a busy session can't hold up
+// the others.
+type Mux struct {
+	workers int
+
+	mu     sync.Mutex
+	queues map[string]*taskQueue
+	// Queues with tasks waiting for a worker, in the order they are served
+	ready  []*taskQueue
+	active int
+}
+
+type taskQueue struct {
+	key   string
+	tasks []func()
+	// Set while the queue is ready or one of its tasks is running
+	scheduled bool
+}
+
+// NewMux creates a mux running at most workers tasks at a time, or
+// GOMAXPROCS tasks if workers is not positive. Workers are started on demand
+// and exit once there is nothing left to do.
+func NewMux(workers int) *Mux {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return &Mux{
+		workers: workers,
+		queues:  make(map[string]*taskQueue),
+	}
+}
+
+// Submit queues task to run after the tasks previously submitted with key.
+// It never blocks and returns ErrQueueFull if QueueSize tasks are queued.
+func (m *Mux) Submit(key string, task func()) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, exists := m.queues[key]
+	if !exists {
+		q = &taskQueue{key: key}
+		m.queues[key] = q
+	}
+	if len(q.tasks) >= QueueSize {
+		return ErrQueueFull
+	}
+	q.tasks = append(q.tasks, task)
+
+	if !q.scheduled {
+		q.scheduled = true
+		m.ready = append(m.ready, q)
+	}
+	if m.active < m.workers && len(m.ready) > 0 {
+		m.active++
+		go m.work()
+	}
+	return nil
+}
+
+func (m *Mux) work() {
+	for {
+		m.mu.Lock()
+		if len(m.ready) == 0 {
+			m.active--
+			m.mu.Unlock()
+			return
+		}
+		q := m.ready[0]
+		m.ready = m.ready[1:]
+		task := q.tasks[0]
+		m.mu.Unlock()
+
+		task()
+
+		m.mu.Lock()
+		q.tasks = q.tasks[1:]
+		if len(q.tasks) > 0 {
+			// Back of the line, behind the other keys
+			m.ready = append(m.ready, q)
+		} else {
+			q.scheduled = false
+			delete(m.queues, q.key)
+		}
+		m.mu.Unlock()
+	}
+}
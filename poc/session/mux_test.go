@@ -0,0 +1,129 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMuxOrderPerKey(t *testing.T) {
+	const (
+		keys  = 8
+		tasks = 100
+	)
+	mux := NewMux(4)
+
+	var (
+		mu   sync.Mutex
+		got  = make(map[string][]int)
+		wg   sync.WaitGroup
+		busy = make(map[string]bool)
+	)
+	for i := 0; i < tasks; i++ {
+		for k := 0; k < keys; k++ {
+			key := fmt.Sprintf("session-%d", k)
+			wg.Add(1)
+			err := mux.Submit(key, func() {
+				defer wg.Done()
+				mu.Lock()
+				if busy[key] {
+					t.Errorf("tasks of %s ran concurrently", key)
+				}
+				busy[key] = true
+				mu.Unlock()
+
+				time.Sleep(10 * time.Microsecond)
+
+				mu.Lock()
+				busy[key] = false
+				got[key] = append(got[key], i)
+				mu.Unlock()
+			})
+			if err != nil {
+				t.Fatalf("failed to submit task: %v", err)
+			}
+		}
+	}
+	wg.Wait()
+
+	for key, order := range got {
+		for i, task := range order {
+			if task != i {
+				t.Fatalf("tasks of %s ran out of order: %v", key, order)
+			}
+		}
+	}
+}
+
+func TestMuxBoundsWorkers(t *testing.T) {
+	const workers = 3
+	mux := NewMux(workers)
+
+	var (
+		running, peak atomic.Int32
+		wg            sync.WaitGroup
+	)
+	for k := 0; k < 10*workers; k++ {
+		wg.Add(1)
+		if err := mux.Submit(fmt.Sprint(k), func() {
+			defer wg.Done()
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+		}); err != nil {
+			t.Fatalf("failed to submit task: %v", err)
+		}
+	}
+	wg.Wait()
+
+	if p := peak.Load(); p != workers {
+		t.Fatalf("expected %d tasks to run at a time, got %d", workers, p)
+	}
+}
+
+func TestMuxNoHeadOfLineBlocking(t *testing.T) {
+	mux := NewMux(2)
+
+	// One session is stuck with a backlog of tasks
+	release := make(chan struct{})
+	defer close(release)
+	for i := 0; i < 10; i++ {
+		if err := mux.Submit("stuck", func() { <-release }); err != nil {
+			t.Fatalf("failed to submit task: %v", err)
+		}
+	}
+
+	done := make(chan struct{})
+	if err := mux.Submit("other", func() { close(done) }); err != nil {
+		t.Fatalf("failed to submit task: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("task of another session was blocked")
+	}
+}
+
+func TestMuxQueueFull(t *testing.T) {
+	mux := NewMux(1)
+
+	release := make(chan struct{})
+	defer close(release)
+	for i := 0; i < QueueSize; i++ {
+		if err := mux.Submit("session", func() { <-release }); err != nil {
+			t.Fatalf("failed to submit task %d: %v", i, err)
+		}
+	}
+	if err := mux.Submit("session", func() {}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected %v, got %v", ErrQueueFull, err)
+	}
+}
@@ -0,0 +1,668 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bnb-chain/tss-lib/v2/common"
+	"github.com/bnb-chain/tss-lib/v2/crypto"
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/signing"
+	eddsakeygen "github.com/bnb-chain/tss-lib/v2/eddsa/keygen"
+	eddsasigning "github.com/bnb-chain/tss-lib/v2/eddsa/signing"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/keruch/thesis/poc/session")
+
+var errAlreadyRun = errors.New("session has already been run")
+
+// RoundTimeoutError reports a session that did not receive all messages of a
+// round in time. Missing lists the members whose round messages, or echoes of
+// the previous round's broadcasts, had not arrived.
+type RoundTimeoutError struct {
+	SessionID string
+	Round     int
+	// Time spent in the round before it timed out
+	Elapsed time.Duration
+	Missing []string
+}
+
+func (e *RoundTimeoutError) Error() string {
+	return fmt.Sprintf("session %s timed out in round %d after %s waiting for %v", e.SessionID, e.Round, e.Elapsed.Round(time.Millisecond), e.Missing)
+}
+
+// Session drives a single keygen or signing protocol run of a local party
+// over a Transport.
+type Session struct {
+	ID string
+
+	// Timeouts, Mux and OnStateChange must be set before Run
+	Timeouts Timeouts
+	// Mux runs the protocol updates of the session; a mux shared by all
+	// sessions of the process if not set
+	Mux *Mux
+	// OnStateChange is called on every state transition
+	OnStateChange func(State)
+
+	self      *tss.PartyID
+	parties   map[string]*tss.PartyID
+	transport Transport
+	inbox     <-chan *Message
+
+	local       tss.Party
+	outCh       chan tss.Message
+	errCh       chan *tss.Error
+	saveCh      chan *keygen.LocalPartySaveData
+	eddsaSaveCh chan *eddsakeygen.LocalPartySaveData
+	sigCh       chan *common.SignatureData
+	echo        *echoBroadcast
+	logger      *slog.Logger
+
+	// Closed once the first round has been started; updates must not reach
+	// the party earlier or it would never re-check whether round 1 can proceed
+	started chan struct{}
+
+	mu             sync.Mutex
+	state          State
+	round          int
+	roundStarts    []time.Time             // When this party entered each round
+	heard          map[int]map[string]bool // Members that sent messages, by round
+	finished       time.Time
+	firstRoundDone chan struct{}
+	saveData       *keygen.LocalPartySaveData
+	eddsaSaveData  *eddsakeygen.LocalPartySaveData
+	signature      *common.SignatureData
+}
+
+// NewKeygen creates a key generation session. Without pre-parameters they are
+// generated when the session starts, which takes a while.
+func NewKeygen(id string, params *tss.Parameters, preParams *keygen.LocalPreParams, transport Transport) *Session {
+	s := newSession(id, params, transport)
+	if preParams != nil {
+		s.local = keygen.NewLocalParty(params, s.outCh, s.saveCh, *preParams)
+	} else {
+		s.local = keygen.NewLocalParty(params, s.outCh, s.saveCh)
+	}
+	return s
+}
+
+// NewSigning creates a session signing msg with the given key share.
+func NewSigning(id string, params *tss.Parameters, msg *big.Int, key keygen.LocalPartySaveData, transport Transport) *Session {
+	s := newSession(id, params, transport)
+	s.local = signing.NewLocalParty(msg, params, key, s.outCh, s.sigCh)
+	return s
+}
+
+// NewDerivedSigning creates a session signing msg with the child key of key
+// whose key derivation delta is delta: the child key is the parent key plus
+// delta*G, and each share of it the parent share plus delta.
+func NewDerivedSigning(id string, params *tss.Parameters, msg *big.Int, key keygen.LocalPartySaveData, delta *big.Int, transport Transport) (*Session, error) {
+	childPub, err := key.ECDSAPub.Add(crypto.ScalarBaseMult(params.EC(), delta))
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive child public key: %w", err)
+	}
+	// The public shares are adjusted in place, so the stored key must not share them
+	key.BigXj = append([]*crypto.ECPoint(nil), key.BigXj...)
+	keys := []keygen.LocalPartySaveData{key}
+	if err := signing.UpdatePublicKeyAndAdjustBigXj(delta, keys, childPub.ToECDSAPubKey(), params.EC()); err != nil {
+		return nil, fmt.Errorf("failed to adjust key shares: %w", err)
+	}
+
+	s := newSession(id, params, transport)
+	s.local = signing.NewLocalPartyWithKDD(msg, params, keys[0], delta, s.outCh, s.sigCh)
+	return s, nil
+}
+
+// NewEdDSAKeygen creates an EdDSA key generation session. params must use
+// the Edwards curve.
+func NewEdDSAKeygen(id string, params *tss.Parameters, transport Transport) *Session {
+	s := newSession(id, params, transport)
+	s.local = eddsakeygen.NewLocalParty(params, s.outCh, s.eddsaSaveCh)
+	return s
+}
+
+// NewEdDSASigning creates a session signing msg with the given EdDSA key
+// share. The whole message is signed, as Ed25519 does, leading zero bytes
+// included.
+func NewEdDSASigning(id string, params *tss.Parameters, msg []byte, key eddsakeygen.LocalPartySaveData, transport Transport) *Session {
+	s := newSession(id, params, transport)
+	s.local = eddsasigning.NewLocalParty(new(big.Int).SetBytes(msg), params, key, s.outCh, s.sigCh, len(msg))
+	return s
+}
+
+func newSession(id string, params *tss.Parameters, transport Transport) *Session {
+	ids := params.Parties().IDs()
+	parties := make(map[string]*tss.PartyID, len(ids))
+	members := make([]string, 0, len(ids))
+	for _, partyID := range ids {
+		parties[partyID.Id] = partyID
+		members = append(members, partyID.Id)
+	}
+
+	return &Session{
+		ID:             id,
+		self:           params.PartyID(),
+		parties:        parties,
+		transport:      transport,
+		inbox:          transport.Receive(id),
+		outCh:          make(chan tss.Message, len(ids)*4),
+		errCh:          make(chan *tss.Error, len(ids)),
+		saveCh:         make(chan *keygen.LocalPartySaveData, 1),
+		eddsaSaveCh:    make(chan *eddsakeygen.LocalPartySaveData, 1),
+		sigCh:          make(chan *common.SignatureData, 1),
+		echo:           newEchoBroadcast(params.PartyID().Id, members),
+		logger:         slog.Default().With("session_id", id, "node", params.PartyID().Id),
+		started:        make(chan struct{}),
+		round:          1,
+		heard:          make(map[int]map[string]bool),
+		firstRoundDone: make(chan struct{}),
+	}
+}
+
+// FirstRoundDone is closed once this party has received every round 1 message.
+func (s *Session) FirstRoundDone() <-chan struct{} {
+	return s.firstRoundDone
+}
+
+func (s *Session) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+func (s *Session) setState(to State) {
+	s.mu.Lock()
+	from := s.state
+	if !canTransition(from, to) {
+		s.mu.Unlock()
+		s.logger.Warn("Invalid session state transition", "from", from.String(), "to", to.String())
+		return
+	}
+	s.state = to
+	s.mu.Unlock()
+
+	s.logger.Debug("Session state changed", "from", from.String(), "to", to.String())
+	if s.OnStateChange != nil {
+		s.OnStateChange(to)
+	}
+}
+
+func (s *Session) Round() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.round
+}
+
+// Missing returns the parties this party is still waiting for in the current
+// round, or for their echo of the previous round's broadcasts.
+func (s *Session) Missing() []string {
+	if missing := s.echo.waitingFor(); len(missing) > 0 {
+		return missing
+	}
+
+	// tss-lib stops checking a round at the first member it lacks messages
+	// from, so it reports the members after that one as well. Members that
+	// sent messages of the round are only missing if nobody else is.
+	s.mu.Lock()
+	heard := s.heard[s.round]
+	s.mu.Unlock()
+
+	var waiting, missing []string
+	for _, id := range s.local.WaitingFor() {
+		waiting = append(waiting, id.Id)
+		if !heard[id.Id] {
+			missing = append(missing, id.Id)
+		}
+	}
+	if len(missing) == 0 {
+		return waiting
+	}
+	return missing
+}
+
+func (s *Session) recordHeard(round int, from string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.heard[round] == nil {
+		s.heard[round] = make(map[string]bool)
+	}
+	s.heard[round][from] = true
+}
+
+// RoundDurations returns how long this party spent in each round of a
+// completed session, from entering the round until entering the next one.
+func (s *Session) RoundDurations() []time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.finished.IsZero() {
+		return nil
+	}
+	durations := make([]time.Duration, len(s.roundStarts))
+	for i, start := range s.roundStarts {
+		end := s.finished
+		if i+1 < len(s.roundStarts) {
+			end = s.roundStarts[i+1]
+		}
+		durations[i] = end.Sub(start)
+	}
+	return durations
+}
+
+// SaveData returns the key share of a completed key generation session.
+func (s *Session) SaveData() *keygen.LocalPartySaveData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveData
+}
+
+// EdDSASaveData returns the key share of a completed EdDSA key generation
+// session.
+func (s *Session) EdDSASaveData() *eddsakeygen.LocalPartySaveData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.eddsaSaveData
+}
+
+// Signature returns the signature of a completed signing session.
+func (s *Session) Signature() *common.SignatureData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.signature
+}
+
+// Run starts the protocol and returns once it completed or failed. A session
+// can only be run once. When a timeout expires, or ctx is cancelled with
+// context.DeadlineExceeded as cause, Run returns a RoundTimeoutError for the
+// round the session was stuck in.
+//
+// Every round is traced as a child span of the span in ctx; messages are
+// sent with the context of the round span.
+func (s *Session) Run(ctx context.Context) (err error) {
+	s.mu.Lock()
+	if s.state.Phase != PhasePending {
+		s.mu.Unlock()
+		return errAlreadyRun
+	}
+	s.roundStarts = []time.Time{time.Now()}
+	s.mu.Unlock()
+
+	defer s.transport.Leave(s.ID)
+	defer s.closeFirstRound()
+
+	if s.Timeouts.Overall > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, s.Timeouts.Overall, context.DeadlineExceeded)
+		defer cancel()
+	}
+
+	s.setState(State{Phase: PhaseRound, Round: 1})
+	defer func() {
+		var timeoutErr *RoundTimeoutError
+		switch {
+		case err == nil:
+			s.setState(State{Phase: PhaseCompleted})
+		case errors.As(err, &timeoutErr):
+			s.setState(State{Phase: PhaseTimedOut})
+		case ctx.Err() != nil:
+			s.setState(State{Phase: PhaseCancelled})
+		default:
+			s.setState(State{Phase: PhaseFailed})
+		}
+	}()
+
+	tracedRound := 1
+	roundCtx, roundSpan := s.startRoundSpan(ctx, tracedRound)
+	defer func() {
+		if err != nil {
+			roundSpan.RecordError(err)
+			roundSpan.SetStatus(codes.Error, err.Error())
+		}
+		roundSpan.End()
+	}()
+
+	go func() {
+		defer close(s.started)
+		if err := s.local.Start(); err != nil {
+			s.fail(err)
+		}
+	}()
+
+	var (
+		// Messages of a round are held until the broadcasts of the previous rounds are verified
+		held []tss.Message
+		// Protocol messages received before the party has started
+		early         []*Message
+		saveData      *keygen.LocalPartySaveData
+		eddsaSaveData *eddsakeygen.LocalPartySaveData
+		signature     *common.SignatureData
+
+		// The round timeout starts once the protocol has been started locally,
+		// so that generating pre-parameters does not count against round 1
+		started       = s.started
+		roundTimer    *time.Timer
+		roundDeadline <-chan time.Time
+	)
+	if s.Timeouts.Round > 0 {
+		roundTimer = time.NewTimer(s.Timeouts.Round)
+		roundTimer.Stop()
+		defer roundTimer.Stop()
+	}
+	resetRoundTimer := func() {
+		if roundTimer != nil {
+			roundTimer.Reset(s.Timeouts.Round)
+			roundDeadline = roundTimer.C
+		}
+	}
+
+	for {
+		select {
+		case msg := <-s.outCh:
+			s.advanceRound(messageRound(msg))
+			if round := s.Round(); round != tracedRound {
+				roundSpan.End()
+				tracedRound = round
+				roundCtx, roundSpan = s.startRoundSpan(ctx, round)
+				s.setState(State{Phase: PhaseRound, Round: round})
+				resetRoundTimer()
+			}
+			if err := s.recordOwnBroadcast(roundCtx, msg); err != nil {
+				s.logger.Warn("Error recording broadcast", "round", messageRound(msg), "msg_type", msg.Type(), "err", err)
+			}
+			held = s.sendSettled(roundCtx, append(held, msg))
+
+		case msg := <-s.inbox:
+			if started != nil && !msg.IsEcho() {
+				early = append(early, msg)
+				break
+			}
+			s.handleMessage(roundCtx, msg)
+
+		case <-s.echo.notify:
+			held = s.sendSettled(roundCtx, held)
+
+		case <-started:
+			started = nil
+			resetRoundTimer()
+			for _, msg := range early {
+				s.handleMessage(roundCtx, msg)
+			}
+			early = nil
+
+		case err := <-s.errCh:
+			return err
+
+		case saveData = <-s.saveCh:
+			s.setState(State{Phase: PhaseFinalizing})
+
+		case eddsaSaveData = <-s.eddsaSaveCh:
+			s.setState(State{Phase: PhaseFinalizing})
+
+		case signature = <-s.sigCh:
+			s.setState(State{Phase: PhaseFinalizing})
+
+		case <-roundDeadline:
+			return s.roundTimeout()
+
+		case <-ctx.Done():
+			if cause := context.Cause(ctx); !errors.Is(cause, context.DeadlineExceeded) {
+				return cause
+			}
+			return s.roundTimeout()
+		}
+
+		// The output is only accepted once the final broadcasts are verified as well
+		if (saveData == nil && eddsaSaveData == nil && signature == nil) || !s.echo.settled(0) {
+			continue
+		}
+
+		s.mu.Lock()
+		s.saveData = saveData
+		s.eddsaSaveData = eddsaSaveData
+		s.signature = signature
+		s.finished = time.Now()
+		s.mu.Unlock()
+		return nil
+	}
+}
+
+func (s *Session) roundTimeout() *RoundTimeoutError {
+	s.mu.Lock()
+	round := s.round
+	elapsed := time.Since(s.roundStarts[len(s.roundStarts)-1])
+	s.mu.Unlock()
+
+	return &RoundTimeoutError{
+		SessionID: s.ID,
+		Round:     round,
+		Elapsed:   elapsed,
+		Missing:   s.Missing(),
+	}
+}
+
+func (s *Session) handleMessage(ctx context.Context, msg *Message) {
+	from, isMember := s.parties[msg.From]
+	if !isMember || msg.From == s.self.Id {
+		return
+	}
+
+	if msg.IsEcho() {
+		if err := s.echo.recordEcho(msg.EchoType, msg.From, msg.Digests); err != nil {
+			s.failEcho(err)
+		}
+		return
+	}
+
+	// Update may run a whole round of computation, don't block the session loop
+	err := s.mux().Submit(s.queueKey(), func() {
+		parsed, err := tss.ParseWireMessage(msg.WireBytes, from, msg.Broadcast)
+		if err != nil {
+			s.fail(s.local.WrapError(err, from))
+			return
+		}
+		s.recordHeard(messageRound(parsed), msg.From)
+		if msg.Broadcast {
+			s.recordBroadcast(ctx, parsed.Type(), msg.From, msg.WireBytes)
+		}
+		if _, err := s.local.Update(parsed); err != nil {
+			s.fail(err)
+		}
+	})
+	if err != nil {
+		// The session can't complete without the message
+		s.fail(s.local.WrapError(fmt.Errorf("failed to queue message from %s: %w", msg.From, err)))
+	}
+}
+
+func (s *Session) mux() *Mux {
+	if s.Mux != nil {
+		return s.Mux
+	}
+	return defaultMux
+}
+
+// queueKey identifies the update queue of the session. Parties of one session
+// may share a mux when they are simulated in one process.
+func (s *Session) queueKey() string {
+	return s.ID + "/" + s.self.Id
+}
+
+// sendSettled sends the held messages whose previous rounds are verified and
+// returns the remaining ones.
+func (s *Session) sendSettled(ctx context.Context, held []tss.Message) []tss.Message {
+	remaining := held[:0]
+	for _, msg := range held {
+		if !s.echo.settled(messageRound(msg)) {
+			remaining = append(remaining, msg)
+			continue
+		}
+		if err := s.sendProtocolMessage(ctx, msg); err != nil {
+			s.logger.Warn("Error sending message", "round", messageRound(msg), "msg_type", msg.Type(), "err", err)
+		}
+	}
+	return remaining
+}
+
+func (s *Session) sendProtocolMessage(ctx context.Context, msg tss.Message) error {
+	wireBytes, routing, err := msg.WireBytes()
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	out := &Message{
+		SessionID: s.ID,
+		From:      s.self.Id,
+		WireBytes: wireBytes,
+		Broadcast: routing.IsBroadcast,
+	}
+
+	if routing.To == nil {
+		return s.transport.Broadcast(ctx, out)
+	}
+
+	for _, to := range routing.To {
+		if err := s.transport.Send(ctx, to.Id, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Session) recordOwnBroadcast(ctx context.Context, msg tss.Message) error {
+	wireBytes, routing, err := msg.WireBytes()
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	if routing.IsBroadcast {
+		s.recordBroadcast(ctx, msg.Type(), s.self.Id, wireBytes)
+	}
+	return nil
+}
+
+// recordBroadcast records a broadcast message and echoes the digests of the
+// message type to the other parties once it was received from everyone. The
+// echo is sent even if this party caught a conflict, as the other parties may
+// need it to tell who is at fault.
+func (s *Session) recordBroadcast(ctx context.Context, msgType, from string, wireBytes []byte) {
+	digests, err := s.echo.recordBroadcast(msgType, from, wireBytes)
+	if err != nil {
+		s.failEcho(err)
+	}
+	if digests == nil {
+		return
+	}
+
+	echo := &Message{
+		SessionID: s.ID,
+		From:      s.self.Id,
+		EchoType:  msgType,
+		Digests:   digests,
+	}
+	go func() {
+		defer s.echo.echoed(msgType)
+		for partyID := range s.parties {
+			if partyID == s.self.Id {
+				continue
+			}
+			if err := s.transport.Send(ctx, partyID, echo); err != nil {
+				s.logger.Warn("Error sending echo", "peer", partyID, "round", typeRound(msgType), "msg_type", msgType, "err", err)
+			}
+		}
+	}()
+}
+
+func (s *Session) fail(err *tss.Error) {
+	select {
+	case s.errCh <- err:
+	default:
+		// The session is already failing
+	}
+}
+
+// failEcho fails the session with an echo broadcast error, blaming the
+// offending party if the error names one.
+func (s *Session) failEcho(err error) {
+	var culpritID string
+	var equivocationErr *EquivocationError
+	var echoErr *InvalidEchoError
+	switch {
+	case errors.As(err, &equivocationErr):
+		culpritID = equivocationErr.Sender
+	case errors.As(err, &echoErr):
+		culpritID = echoErr.From
+	}
+
+	if culprit, isMember := s.parties[culpritID]; isMember {
+		s.fail(s.local.WrapError(err, culprit))
+		return
+	}
+	s.fail(s.local.WrapError(err))
+}
+
+func (s *Session) advanceRound(round int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if round <= s.round {
+		return
+	}
+	if s.round == 1 {
+		close(s.firstRoundDone)
+	}
+	// Rounds without outgoing messages are entered together with the next one
+	now := time.Now()
+	for s.round < round {
+		s.roundStarts = append(s.roundStarts, now)
+		s.round++
+	}
+}
+
+func (s *Session) startRoundSpan(ctx context.Context, round int) (context.Context, trace.Span) {
+	return tracer.Start(ctx, fmt.Sprintf("round %d", round), trace.WithAttributes(
+		attribute.String("tss.session_id", s.ID),
+		attribute.String("tss.party_id", s.self.Id),
+		attribute.Int("tss.round", round),
+	))
+}
+
+func (s *Session) closeFirstRound() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.round <= 1 {
+		close(s.firstRoundDone)
+	}
+}
+
+var roundPattern = regexp.MustCompile(`Round(\d+)`)
+
+// messageRound extracts the protocol round from a tss-lib message type such
+// as "binance.tsslib.ecdsa.signing.SignRound1Message1".
+func messageRound(msg tss.Message) int {
+	return typeRound(msg.Type())
+}
+
+func typeRound(msgType string) int {
+	match := roundPattern.FindStringSubmatch(msgType)
+	if match == nil {
+		return 0
+	}
+	round, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0
+	}
+	return round
+}
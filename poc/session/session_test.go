@@ -0,0 +1,322 @@
+package session
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+	"github.com/decred/dcrd/dcrec/edwards/v2"
+)
+
+// The key shares generated by keygen-simulate: 4 parties with threshold 3.
+const (
+	testKeySharesDir = "../../data"
+	testParties      = 4
+	testThreshold    = 3
+)
+
+// newTestSigningSessions creates a signing session for every party holding
+// one of the cached key shares, matching the party IDs of keygen-simulate.
+func newTestSigningSessions(t *testing.T, network *MemoryNetwork, id string) []*Session {
+	t.Helper()
+
+	partyIDs := testPartyIDs()
+	peerCtx := tss.NewPeerContext(partyIDs)
+	msg := big.NewInt(42)
+
+	sessions := make([]*Session, testParties)
+	for i, partyID := range partyIDs {
+		params := tss.NewParameters(tss.S256(), peerCtx, partyID, testParties, testThreshold)
+		sessions[i] = NewSigning(id, params, msg, loadTestKeyShare(t, i), network.Transport(partyID.Id))
+	}
+	return sessions
+}
+
+// testPartyIDs returns the party IDs of keygen-simulate.
+func testPartyIDs() tss.SortedPartyIDs {
+	ids := make(tss.UnSortedPartyIDs, testParties)
+	for i := range ids {
+		ids[i] = tss.NewPartyID(fmt.Sprintf("poc-party-id-%d", i), fmt.Sprintf("poc-moniker-%d", i), big.NewInt(int64(i+1)))
+	}
+	return tss.SortPartyIDs(ids)
+}
+
+func loadTestKeyShare(t *testing.T, i int) keygen.LocalPartySaveData {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(testKeySharesDir, fmt.Sprintf("key-share-%d.json", i)))
+	if err != nil {
+		t.Fatalf("failed to read key share: %v", err)
+	}
+	var share keygen.LocalPartySaveData
+	if err := json.Unmarshal(data, &share); err != nil {
+		t.Fatalf("failed to unmarshal key share: %v", err)
+	}
+	return share
+}
+
+func runAll(sessions []*Session) []error {
+	errs := make([]error, len(sessions))
+	var wg sync.WaitGroup
+	for i, s := range sessions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.Run(context.Background())
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestSessionStates(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping full signing session in short mode")
+	}
+
+	sessions := newTestSigningSessions(t, NewMemoryNetwork(), "test-signing")
+
+	var (
+		mu     sync.Mutex
+		states []State
+	)
+	sessions[0].OnStateChange = func(state State) {
+		mu.Lock()
+		defer mu.Unlock()
+		states = append(states, state)
+	}
+	for _, s := range sessions {
+		s.Timeouts = Timeouts{Round: time.Minute, Overall: 2 * time.Minute}
+	}
+
+	for i, err := range runAll(sessions) {
+		if err != nil {
+			t.Fatalf("session %d failed: %v", i, err)
+		}
+	}
+
+	// Signing runs through 9 rounds
+	var want []State
+	for round := 1; round <= 9; round++ {
+		want = append(want, State{Phase: PhaseRound, Round: round})
+	}
+	want = append(want, State{Phase: PhaseFinalizing}, State{Phase: PhaseCompleted})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(states) != fmt.Sprint(want) {
+		t.Fatalf("unexpected state transitions:\n got %v\nwant %v", states, want)
+	}
+
+	if err := sessions[0].Run(context.Background()); !errors.Is(err, errAlreadyRun) {
+		t.Fatalf("expected a completed session not to run again, got %v", err)
+	}
+}
+
+func TestConcurrentSessions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping full signing sessions in short mode")
+	}
+
+	// The parties take part in several signings at once, all updates
+	// computed by one pool of workers
+	const concurrent = 2
+	network := NewMemoryNetwork()
+	mux := NewMux(0)
+
+	var all []*Session
+	for i := 0; i < concurrent; i++ {
+		sessions := newTestSigningSessions(t, network, fmt.Sprintf("test-signing-%d", i))
+		for _, s := range sessions {
+			s.Mux = mux
+			s.Timeouts.Overall = 2 * time.Minute
+		}
+		all = append(all, sessions...)
+	}
+
+	for i, err := range runAll(all) {
+		if err != nil {
+			t.Fatalf("session %s of party %d failed: %v", all[i].ID, i%testParties, err)
+		}
+		if all[i].Signature() == nil {
+			t.Fatalf("session %s of party %d has no signature", all[i].ID, i%testParties)
+		}
+	}
+}
+
+func TestSessionRoundTimeout(t *testing.T) {
+	// tss-lib checks the members in order, so a missing first member must
+	// not take the members after it along
+	for _, absentIndex := range []int{0, testParties - 1} {
+		t.Run(fmt.Sprintf("party %d absent", absentIndex), func(t *testing.T) {
+			sessions := newTestSigningSessions(t, NewMemoryNetwork(), "test-signing")
+
+			absent := sessions[absentIndex]
+			running := append(append([]*Session(nil), sessions[:absentIndex]...), sessions[absentIndex+1:]...)
+			for _, s := range running {
+				s.Timeouts.Round = 2 * time.Second
+			}
+
+			for i, err := range runAll(running) {
+				var timeoutErr *RoundTimeoutError
+				if !errors.As(err, &timeoutErr) {
+					t.Fatalf("session %d: expected a round timeout, got %v", i, err)
+				}
+				if timeoutErr.Round != 1 {
+					t.Fatalf("session %d: expected a timeout in round 1, got round %d", i, timeoutErr.Round)
+				}
+				if len(timeoutErr.Missing) != 1 || timeoutErr.Missing[0] != absent.self.Id {
+					t.Fatalf("session %d: expected %s to be missing, got %v", i, absent.self.Id, timeoutErr.Missing)
+				}
+				if state := running[i].State(); state.Phase != PhaseTimedOut {
+					t.Fatalf("session %d: expected state %s, got %s", i, PhaseTimedOut, state)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionCancel(t *testing.T) {
+	sessions := newTestSigningSessions(t, NewMemoryNetwork(), "test-signing")
+	s := sessions[0]
+
+	cause := errors.New("aborted")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	time.AfterFunc(100*time.Millisecond, func() { cancel(cause) })
+
+	if err := s.Run(ctx); !errors.Is(err, cause) {
+		t.Fatalf("expected the cancellation cause, got %v", err)
+	}
+	if state := s.State(); state.Phase != PhaseCancelled {
+		t.Fatalf("expected state %s, got %s", PhaseCancelled, state)
+	}
+}
+
+func TestStateTransitions(t *testing.T) {
+	round := func(r int) State { return State{Phase: PhaseRound, Round: r} }
+
+	tests := []struct {
+		from, to State
+		want     bool
+	}{
+		{State{Phase: PhasePending}, round(1), true},
+		{State{Phase: PhasePending}, round(2), false},
+		{round(1), round(2), true},
+		{round(1), round(3), true},
+		{round(2), round(1), false},
+		{round(2), round(2), false},
+		{round(9), State{Phase: PhaseFinalizing}, true},
+		{State{Phase: PhasePending}, State{Phase: PhaseFinalizing}, false},
+		{State{Phase: PhaseFinalizing}, State{Phase: PhaseCompleted}, true},
+		{round(3), State{Phase: PhaseCompleted}, false},
+		{round(3), State{Phase: PhaseTimedOut}, true},
+		{State{Phase: PhasePending}, State{Phase: PhaseCancelled}, true},
+		{State{Phase: PhaseFinalizing}, State{Phase: PhaseFailed}, true},
+		{State{Phase: PhaseCompleted}, State{Phase: PhaseFailed}, false},
+		{State{Phase: PhaseFailed}, round(1), false},
+	}
+	for _, tt := range tests {
+		if got := canTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("canTransition(%s, %s) = %t, want %t", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestEdDSASigning(t *testing.T) {
+	const parties, threshold = 3, 1
+
+	ids := make(tss.UnSortedPartyIDs, parties)
+	for i := range ids {
+		ids[i] = tss.NewPartyID(fmt.Sprintf("eddsa-party-%d", i), "", big.NewInt(int64(i+1)))
+	}
+	partyIDs := tss.SortPartyIDs(ids)
+	peerCtx := tss.NewPeerContext(partyIDs)
+	params := make([]*tss.Parameters, parties)
+	for i, partyID := range partyIDs {
+		params[i] = tss.NewParameters(tss.Edwards(), peerCtx, partyID, parties, threshold)
+	}
+
+	network := NewMemoryNetwork()
+	keygens := make([]*Session, parties)
+	for i := range keygens {
+		keygens[i] = NewEdDSAKeygen("test-eddsa-keygen", params[i], network.Transport(partyIDs[i].Id))
+	}
+	for i, err := range runAll(keygens) {
+		if err != nil {
+			t.Fatalf("keygen session %d failed: %v", i, err)
+		}
+	}
+
+	// A message with a leading zero byte is signed as is
+	msg := []byte("\x00\x01threshold ed25519")
+	signings := make([]*Session, parties)
+	for i := range signings {
+		signings[i] = NewEdDSASigning("test-eddsa-signing", params[i], msg, *keygens[i].EdDSASaveData(), network.Transport(partyIDs[i].Id))
+	}
+	for i, err := range runAll(signings) {
+		if err != nil {
+			t.Fatalf("signing session %d failed: %v", i, err)
+		}
+	}
+
+	share := keygens[0].EdDSASaveData()
+	pub := edwards.NewPublicKey(share.EDDSAPub.X(), share.EDDSAPub.Y()).Serialize()
+	if !ed25519.Verify(pub, msg, signings[0].Signature().GetSignature()) {
+		t.Fatal("threshold signature does not verify as Ed25519")
+	}
+}
+
+func TestDerivedSigning(t *testing.T) {
+	partyIDs := testPartyIDs()
+	peerCtx := tss.NewPeerContext(partyIDs)
+	digest := sha256.Sum256([]byte("derived signing"))
+	delta := big.NewInt(0x0bad5eed)
+
+	network := NewMemoryNetwork()
+	shares := make([]keygen.LocalPartySaveData, testParties)
+	sessions := make([]*Session, testParties)
+	for i, partyID := range partyIDs {
+		shares[i] = loadTestKeyShare(t, i)
+		params := tss.NewParameters(tss.S256(), peerCtx, partyID, testParties, testThreshold)
+		s, err := NewDerivedSigning("test-derived-signing", params, new(big.Int).SetBytes(digest[:]), shares[i], delta, network.Transport(partyID.Id))
+		if err != nil {
+			t.Fatalf("failed to create derived signing session: %v", err)
+		}
+		sessions[i] = s
+	}
+	for i, err := range runAll(sessions) {
+		if err != nil {
+			t.Fatalf("signing session %d failed: %v", i, err)
+		}
+	}
+
+	parent := shares[0].ECDSAPub.ToECDSAPubKey()
+	deltaX, deltaY := tss.S256().ScalarBaseMult(delta.Bytes())
+	childX, childY := tss.S256().Add(parent.X, parent.Y, deltaX, deltaY)
+	child := &ecdsa.PublicKey{Curve: tss.S256(), X: childX, Y: childY}
+	sig := sessions[0].Signature()
+	r, s := new(big.Int).SetBytes(sig.GetR()), new(big.Int).SetBytes(sig.GetS())
+	if !ecdsa.Verify(child, digest[:], r, s) {
+		t.Fatal("signature does not verify against the child public key")
+	}
+	if ecdsa.Verify(parent, digest[:], r, s) {
+		t.Fatal("signature verifies against the parent public key")
+	}
+
+	// The shares the sessions were created from are left untouched
+	if !shares[0].ECDSAPub.Equals(loadTestKeyShare(t, 0).ECDSAPub) || !shares[0].BigXj[1].Equals(loadTestKeyShare(t, 0).BigXj[1]) {
+		t.Fatal("derived signing modified the parent key share")
+	}
+}
@@ -0,0 +1,98 @@
+package session
+
+import (
+	"fmt"
+	"time"
+)
+
+// Phase is the coarse state of a session.
+type Phase int
+
+const (
+	// PhasePending is a session that has not been run yet
+	PhasePending Phase = iota
+	// PhaseRound is a session exchanging the messages of a protocol round
+	PhaseRound
+	// PhaseFinalizing is a session that has its output and waits for the
+	// other members to confirm the final broadcasts
+	PhaseFinalizing
+	PhaseCompleted
+	PhaseFailed
+	PhaseTimedOut
+	PhaseCancelled
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhasePending:
+		return "pending"
+	case PhaseRound:
+		return "round"
+	case PhaseFinalizing:
+		return "finalizing"
+	case PhaseCompleted:
+		return "completed"
+	case PhaseFailed:
+		return "failed"
+	case PhaseTimedOut:
+		return "timed_out"
+	case PhaseCancelled:
+		return "cancelled"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(p))
+	}
+}
+
+// Terminal reports whether a session in this phase has finished.
+func (p Phase) Terminal() bool {
+	return p >= PhaseCompleted
+}
+
+// State is the state of a session; Round is set in PhaseRound only.
+type State struct {
+	Phase Phase
+	Round int
+}
+
+func (s State) String() string {
+	if s.Phase == PhaseRound {
+		return fmt.Sprintf("round %d", s.Round)
+	}
+	return s.Phase.String()
+}
+
+// canTransition reports whether a session may move from one state to
+// another. Sessions move through the rounds in order, possibly skipping
+// rounds without outgoing messages, and may end from any non-terminal state.
+func canTransition(from, to State) bool {
+	if from.Phase.Terminal() {
+		return false
+	}
+
+	switch to.Phase {
+	case PhaseRound:
+		switch from.Phase {
+		case PhasePending:
+			return to.Round == 1
+		case PhaseRound:
+			return to.Round > from.Round
+		}
+		return false
+	case PhaseFinalizing:
+		return from.Phase == PhaseRound
+	case PhaseCompleted:
+		return from.Phase == PhaseFinalizing
+	case PhaseFailed, PhaseTimedOut, PhaseCancelled:
+		return true
+	}
+	return false
+}
+
+// Timeouts bound how long a session may run. Zero disables a timeout.
+type Timeouts struct {
+	// Round limits the time spent in a single round, starting once the
+	// protocol has been started locally
+	Round time.Duration
+	// Overall limits the whole session, including pre-parameter generation
+	Overall time.Duration
+}
@@ -0,0 +1,103 @@
+package session
+
+import (
+	"context"
+	"sync"
+)
+
+// MailboxSize is the number of messages buffered per session before delivery blocks.
+const MailboxSize = 256
+
+// Message is a session message exchanged between parties. Parties are
+// addressed by their tss-lib party ID.
+type Message struct {
+	SessionID string
+	From      string
+
+	// Protocol messages
+	WireBytes []byte
+	Broadcast bool
+
+	// Echo messages: the digests of the broadcasts of EchoType received from each party
+	EchoType string
+	Digests  map[string][]byte
+}
+
+func (m *Message) IsEcho() bool {
+	return m.EchoType != ""
+}
+
+// Transport carries session messages between the parties of a session.
+type Transport interface {
+	// Send delivers msg to the party with the given ID.
+	Send(ctx context.Context, to string, msg *Message) error
+	// Broadcast delivers msg to every other party of the session.
+	Broadcast(ctx context.Context, msg *Message) error
+	// Receive returns the messages of a session addressed to this party.
+	// Messages of a session are only delivered once Receive was called for it.
+	Receive(sessionID string) <-chan *Message
+	// Leave stops receiving the messages of a session.
+	Leave(sessionID string)
+}
+
+type mailbox struct {
+	ch   chan *Message
+	done chan struct{}
+}
+
+// Mailboxes keeps a message queue per session for transport implementations.
+type Mailboxes struct {
+	boxes map[string]*mailbox
+	mu    sync.Mutex
+}
+
+func NewMailboxes() *Mailboxes {
+	return &Mailboxes{
+		boxes: make(map[string]*mailbox),
+	}
+}
+
+func (m *Mailboxes) Receive(sessionID string) <-chan *Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	box, exists := m.boxes[sessionID]
+	if !exists {
+		box = &mailbox{
+			ch:   make(chan *Message, MailboxSize),
+			done: make(chan struct{}),
+		}
+		m.boxes[sessionID] = box
+	}
+	return box.ch
+}
+
+func (m *Mailboxes) Leave(sessionID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if box, exists := m.boxes[sessionID]; exists {
+		close(box.done)
+		delete(m.boxes, sessionID)
+	}
+}
+
+// Deliver queues msg for its session, blocking while the queue is full. It
+// reports false if nobody receives the session's messages.
+func (m *Mailboxes) Deliver(ctx context.Context, msg *Message) bool {
+	m.mu.Lock()
+	box, exists := m.boxes[msg.SessionID]
+	m.mu.Unlock()
+	if !exists {
+		return false
+	}
+
+	select {
+	case box.ch <- msg:
+		return true
+	case <-box.done:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
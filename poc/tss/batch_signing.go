@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/bnb-chain/tss-lib/v2/common"
+	"github.com/keruch/thesis/poc/session"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// MaxBatchSessions bounds the signing sessions of a batch that run at a time.
+const MaxBatchSessions = 16
+
+type BatchAction string
+
+const (
+	// BatchActionAnnounce announces the parties of a batch
+	BatchActionAnnounce BatchAction = "announce"
+	// BatchActionStart starts the sessions of all parties of an announced batch
+	BatchActionStart BatchAction = "start"
+)
+
+// PartyBatch is the payload of batch messages. A batch groups the signing
+// parties of several digests with the same signers, so that they are formed
+// and started with one message per member instead of one per party.
+type PartyBatch struct {
+	ID     string      `json:"id"`
+	Action BatchAction `json:"action"`
+
+	// Announcements only
+	Parties []*Party `json:"parties,omitempty"`
+}
+
+// BatchSignResult is the outcome of signing one digest of a batch: either
+// Signature or Err is set.
+type BatchSignResult struct {
+	Digest    []byte
+	Signature *common.SignatureData
+	Err       error
+}
+
+// BatchSignError reports a batch in which some of the digests could not be signed.
+type BatchSignError struct {
+	Failed int
+	Total  int
+}
+
+func (e *BatchSignError) Error() string {
+	return fmt.Sprintf("failed to sign %d of %d digests", e.Failed, e.Total)
+}
+
+// SignBatch signs every digest with the key keyID and returns the results in
+// the order of the digests. The signers are selected once for the whole
+// batch and the sessions run in parallel, MaxBatchSessions at a time. If
+// signers turn out to be unreachable or stall the first round, they are
+// replaced and the affected digests are retried, like in Sign.
+//
+// When some digests could not be signed, the results are returned together
+// with a BatchSignError and carry the error of each failed digest.
+func (n *Node) SignBatch(ctx context.Context, keyID string, digests [][]byte) ([]BatchSignResult, error) {
+	record, err := n.keyStore.Load(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if !record.IsHolder(n.host.ID()) {
+		return nil, fmt.Errorf("node does not hold a share of key %s", keyID)
+	}
+	for i, digest := range digests {
+		if err := record.CheckMessage(digest); err != nil {
+			return nil, fmt.Errorf("digest %d: %w", i, err)
+		}
+	}
+
+	results := make([]BatchSignResult, len(digests))
+	remaining := make([]int, len(digests))
+	for i, digest := range digests {
+		results[i].Digest = digest
+		remaining[i] = i
+	}
+
+	excluded := make(map[peer.ID]struct{})
+	for attempt := 1; attempt <= MaxSigningAttempts && len(remaining) > 0; attempt++ {
+		signers, err := n.selectSigners(ctx, record, excluded)
+		if err != nil {
+			for _, i := range remaining {
+				if results[i].Err != nil {
+					results[i].Err = fmt.Errorf("%w (previous attempt: %v)", err, results[i].Err)
+				} else {
+					results[i].Err = err
+				}
+			}
+			break
+		}
+
+		var retry []int
+		for start := 0; start < len(remaining); start += MaxBatchSessions {
+			chunk := remaining[start:min(start+MaxBatchSessions, len(remaining))]
+			retry = n.signBatchChunk(ctx, record, signers, chunk, results, excluded)
+			if len(retry) > 0 {
+				// Select new signers before signing the rest of the batch
+				retry = append(retry, remaining[start+len(chunk):]...)
+				break
+			}
+		}
+		if len(retry) > 0 {
+			n.logger.Warn("Batch signing attempt failed, retrying without unresponsive holders", "attempt", attempt, "key_id", keyID, "retry", len(retry), "excluded", len(excluded))
+		}
+		remaining = retry
+	}
+
+	var failed int
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, &BatchSignError{Failed: failed, Total: len(results)}
+	}
+	return results, nil
+}
+
+// signBatchChunk signs the digests of results at the given indices with one
+// batch of parties. It records the outcome of each digest and returns the
+// indices worth retrying with other signers, adding the culprits to excluded.
+func (n *Node) signBatchChunk(ctx context.Context, record *KeyShareRecord, signers []peer.ID, chunk []int, results []BatchSignResult, excluded map[peer.ID]struct{}) []int {
+	digests := make([][]byte, len(chunk))
+	for j, i := range chunk {
+		digests[j] = results[i].Digest
+	}
+
+	parties, err := n.partyMgr.CreateSigningBatch(ctx, n.host.ID(), signers, record.Threshold, record.KeyID, digests)
+	if err != nil {
+		for _, i := range chunk {
+			results[i].Err = err
+		}
+		var unreachableErr *UnreachableMembersError
+		if !errors.As(err, &unreachableErr) {
+			return nil
+		}
+		for _, p := range unreachableErr.Members {
+			excluded[p] = struct{}{}
+		}
+		return chunk
+	}
+
+	sessions := make([]*Session, len(parties))
+	for j, party := range parties {
+		s, err := n.tssHandler.StartSession(n.ctx, party)
+		if err != nil {
+			results[chunk[j]].Err = fmt.Errorf("failed to start session: %w", err)
+			continue
+		}
+		sessions[j] = s
+	}
+
+	// Members that miss the start message show up as missing in the round 1 report
+	batchID := parties[0].BatchID
+	if err := n.partyMgr.StartBatch(ctx, n.host.ID(), signers, batchID); err != nil {
+		n.logger.Warn("Error starting batch on all members", "batch_id", batchID, LogKeyError, err)
+	}
+
+	var retry []int
+	for j, s := range sessions {
+		if s == nil {
+			continue
+		}
+		i := chunk[j]
+
+		if err := s.Wait(ctx); err != nil {
+			if ctx.Err() != nil {
+				s.Cancel()
+			}
+			results[i].Err = err
+
+			var timeoutErr *session.RoundTimeoutError
+			if errors.As(err, &timeoutErr) && timeoutErr.Round <= 1 {
+				for _, id := range timeoutErr.Missing {
+					if p, err := peer.Decode(id); err == nil {
+						excluded[p] = struct{}{}
+					}
+				}
+				retry = append(retry, i)
+			}
+			continue
+		}
+		results[i].Signature = s.Signature()
+		results[i].Err = nil
+	}
+
+	return retry
+}
+
+// handlePartyBatch joins or starts the parties of a batch announced by its initiator.
+func (n *Node) handlePartyBatch(msg *Message) error {
+	var batch PartyBatch
+	if err := json.Unmarshal(msg.Payload, &batch); err != nil {
+		return invalidMessage("failed to unmarshal party batch: %w", err)
+	}
+	if batch.ID != msg.PartyID {
+		return invalidMessage("party batch %s does not match its envelope", batch.ID)
+	}
+
+	switch batch.Action {
+	case BatchActionAnnounce:
+		for _, party := range batch.Parties {
+			if party.BatchID != batch.ID || party.Initiator != msg.From || party.Operation != TSSOperationSigning {
+				return invalidMessage("party %s does not belong to batch %s", party.ID, batch.ID)
+			}
+		}
+		var errs []error
+		for _, party := range batch.Parties {
+			if err := n.partyMgr.JoinParty(n.host.ID(), party); err != nil {
+				errs = append(errs, fmt.Errorf("failed to join party: %w", err))
+			}
+		}
+		n.logger.Info("Joined party batch", "batch_id", batch.ID, "parties", len(batch.Parties), "initiator", msg.From)
+		return errors.Join(errs...)
+
+	case BatchActionStart:
+		parties := n.partyMgr.ReadyBatchParties(batch.ID)
+		for _, party := range parties {
+			if party.Initiator != msg.From {
+				return invalidMessage("batch start from non-initiator %s", msg.From)
+			}
+		}
+		var errs []error
+		for _, party := range parties {
+			if _, err := n.tssHandler.StartSession(n.ctx, party); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+
+	default:
+		return invalidMessage("unknown batch action: %s", batch.Action)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func batchDigests(n int) [][]byte {
+	digests := make([][]byte, n)
+	for i := range digests {
+		d := sha256.Sum256([]byte(fmt.Sprintf("batch %d", i)))
+		digests[i] = d[:]
+	}
+	return digests
+}
+
+func TestSignBatchReportsFailedDigests(t *testing.T) {
+	key := ecdsaTestKey(t)
+	initiator, stalled := key.initiator(), key.net.nodes[2]
+
+	// The stalled holder never joins the second party of the batch, so that
+	// party times out in round 1 while the first one completes. The two
+	// sessions share the CPU, so the first one gets more room than a single
+	// signing would need
+	setSigningRoundTimeout(t, initiator, 5*time.Second)
+	interceptMessages(t, stalled, MessageTypePartyBatch, func(msg *Message, handle MessageHandler) error {
+		var batch PartyBatch
+		if err := json.Unmarshal(msg.Payload, &batch); err != nil || batch.Action != BatchActionAnnounce {
+			return handle(msg)
+		}
+		batch.Parties = batch.Parties[:1]
+		payload, err := json.Marshal(&batch)
+		if err != nil {
+			return err
+		}
+		announcement := *msg
+		announcement.Payload = payload
+		return handle(&announcement)
+	})
+
+	digests := batchDigests(2)
+	results, err := initiator.SignBatch(testContext(t, time.Minute), key.record.KeyID, digests)
+	var batchErr *BatchSignError
+	if !errors.As(err, &batchErr) || batchErr.Failed != 1 || batchErr.Total != 2 {
+		t.Fatalf("expected 1 of 2 digests to fail, got %v", err)
+	}
+
+	if results[0].Err != nil {
+		t.Fatalf("digest 0 failed: %v", results[0].Err)
+	}
+	verifySignature(t, key.publicKey(), digests[0], results[0].Signature)
+
+	// The retry without the stalled holder finds too few signers
+	failed := results[1]
+	if failed.Signature != nil || failed.Err == nil {
+		t.Fatal("expected digest 1 to fail without a signature")
+	}
+	if !strings.Contains(failed.Err.Error(), "have 1, need 2") {
+		t.Fatalf("expected too few signers for the retry of digest 1, got %v", failed.Err)
+	}
+	if !strings.Contains(failed.Err.Error(), "timed out in round 1") || !strings.Contains(failed.Err.Error(), stalled.host.ID().String()) {
+		t.Fatalf("expected the round 1 timeout of %s as the previous attempt of digest 1, got %v", stalled.host.ID(), failed.Err)
+	}
+}
+
+func TestSignBatchRetriesWithReplacementSigners(t *testing.T) {
+	net := newTestNetwork(t, 5)
+	initiator := net.nodes[0]
+	record := keyWithoutShares(t, net, 2)
+
+	// The fastest holders are picked first but refuse the batch
+	for _, node := range net.nodes[1:3] {
+		refuseParties(node)
+	}
+	for _, node := range net.nodes[3:] {
+		setLatency(net, initiator, node, 20*time.Millisecond)
+	}
+
+	results, err := initiator.SignBatch(testContext(t, 30*time.Second), record.KeyID, batchDigests(2))
+	var batchErr *BatchSignError
+	if !errors.As(err, &batchErr) || batchErr.Failed != 2 || batchErr.Total != 2 {
+		t.Fatalf("expected both digests to fail, got %v", err)
+	}
+
+	// The second attempt reaches its signers and only fails to start the
+	// sessions, as the key has no shares
+	var unreachableErr *UnreachableMembersError
+	for i, result := range results {
+		if errors.As(result.Err, &unreachableErr) || !strings.Contains(fmt.Sprint(result.Err), "failed to start session") {
+			t.Fatalf("expected digest %d to fail starting its session, got %v", i, result.Err)
+		}
+	}
+
+	want := fmt.Sprint([]peer.ID{initiator.host.ID(), net.nodes[3].host.ID(), net.nodes[4].host.ID()})
+	for _, node := range net.nodes[3:] {
+		parties := node.partyMgr.GetAllParties()
+		if len(parties) != len(results) {
+			t.Fatalf("replacement signer %s joined %d parties, want %d", node.host.ID(), len(parties), len(results))
+		}
+		for _, party := range parties {
+			if fmt.Sprint(party.Members) != want {
+				t.Fatalf("replacement signer %s joined a party of %v, want %s", node.host.ID(), party.Members, want)
+			}
+		}
+	}
+	for _, node := range net.nodes[1:3] {
+		if parties := node.partyMgr.GetAllParties(); len(parties) != 0 {
+			t.Fatalf("refusing holder %s joined %d parties", node.host.ID(), len(parties))
+		}
+	}
+}
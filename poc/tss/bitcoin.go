@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/keruch/thesis/poc/chain/bitcoin"
+)
+
+// SignPSBT signs the P2WPKH and P2PKH inputs of packet owned by the key keyID,
+// one signing session per input, and adds the signatures to packet. It
+// returns the signed inputs.
+func (n *Node) SignPSBT(ctx context.Context, keyID string, packet *psbt.Packet) ([]bitcoin.InputSighash, error) {
+	record, err := n.keyStore.Load(keyID)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := record.ECDSAPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	key, err := bitcoin.NewKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs, err := bitcoin.Sighashes(packet, key)
+	if err != nil {
+		return nil, err
+	}
+	digests := make([][]byte, len(inputs))
+	for i, input := range inputs {
+		digests[i] = input.Sighash
+	}
+
+	results, err := n.SignBatch(ctx, keyID, digests)
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range results {
+		if err := bitcoin.AddSignature(packet, inputs[i], result.Signature, key); err != nil {
+			return nil, fmt.Errorf("invalid threshold signature: %w", err)
+		}
+	}
+	return inputs, nil
+}
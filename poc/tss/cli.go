@@ -2,12 +2,25 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+	"github.com/btcsuite/btcd/btcutil/base58"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/keruch/thesis/poc/chain"
+	"github.com/keruch/thesis/poc/chain/bitcoin"
+	"github.com/keruch/thesis/poc/chain/cosmos"
+	"github.com/keruch/thesis/poc/chain/ethereum"
+	"github.com/keruch/thesis/poc/chain/solana"
+	"github.com/keruch/thesis/poc/internal/cliinput"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/spf13/cobra"
@@ -21,6 +34,8 @@ func main() {
 }
 
 func NewRootCmd() *cobra.Command {
+	logCfg := DefaultLogConfig()
+
 	rootCmd := &cobra.Command{
 		Use:   "tssd",
 		Short: "TSS daemon for threshold signature operations",
@@ -29,10 +44,20 @@ It provides peer discovery, party management, and secure communication for TSS o
 		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
 			cmd.SetOut(cmd.OutOrStdout())
 			cmd.SetErr(cmd.ErrOrStderr())
-			return nil
+			if err := setupLogging(cmd.ErrOrStderr(), logCfg); err != nil {
+				return err
+			}
+			// start creates its own node from the command flags
+			if cmd.Name() == "start" || cmd.Annotations[annotationOffline] == "true" || globalNode != nil {
+				return nil
+			}
+			return initGlobalNode(cmd.Context())
 		},
 	}
 
+	rootCmd.PersistentFlags().StringVar(&logCfg.Level, "log-level", logCfg.Level, `Log level: "debug", "info", "warn" or "error"`)
+	rootCmd.PersistentFlags().StringVar(&logCfg.Format, "log-format", logCfg.Format, `Log format: "text" or "json"`)
+
 	initRootCmd(rootCmd)
 	return rootCmd
 }
@@ -41,23 +66,48 @@ func initRootCmd(rootCmd *cobra.Command) {
 	rootCmd.AddCommand(
 		NewStartCmd(),
 		NewPartyCmd(),
+		NewPeersCmd(),
 		NewKeygenCmd(),
+		NewKeysCmd(),
 		NewSignCmd(),
+		NewSignBatchCmd(),
+		NewEthCmd(),
+		NewCosmosCmd(),
 	)
 }
 
 func NewStartCmd() *cobra.Command {
-	var keyFile string
+	var (
+		keyFile string
+		cfg     = DefaultNodeConfig()
+	)
 
 	cmd := &cobra.Command{
 		Use:   "start",
 		Short: "Start the TSS node",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return startNode(cmd.Context(), keyFile)
+			return startNode(cmd.Context(), keyFile, cfg)
 		},
 	}
 
 	cmd.Flags().StringVarP(&keyFile, "key", "k", "node_key", "Path to the node's private key file")
+	cmd.Flags().StringVar(&cfg.KeyStoreDir, "keystore", cfg.KeyStoreDir, "Directory holding the node's key shares")
+	cmd.Flags().StringSliceVar(&cfg.ListenAddrs, "listen", cfg.ListenAddrs, "Multiaddrs to listen on")
+	cmd.Flags().StringVar(&cfg.RegistryFile, "registry", "", "Committee registry file with the operators allowed to connect (default: allow all)")
+	cmd.Flags().StringSliceVar(&cfg.Discovery.BootstrapPeers, "bootstrap", nil, "Bootstrap peer multiaddrs including the /p2p/ peer ID")
+	cmd.Flags().StringVar(&cfg.Discovery.DHTProtocolPrefix, "dht-prefix", cfg.Discovery.DHTProtocolPrefix, "Protocol prefix of the private DHT")
+	cmd.Flags().StringVar(&cfg.Discovery.Namespace, "namespace", cfg.Discovery.Namespace, "Rendezvous namespace used to find other nodes")
+	cmd.Flags().BoolVar(&cfg.Discovery.EnableMDNS, "mdns", cfg.Discovery.EnableMDNS, "Discover peers on the local network with mDNS")
+	cmd.Flags().StringVar(&cfg.MetricsAddr, "metrics", "", `Address serving Prometheus metrics, e.g. "127.0.0.1:9464" (default: disabled)`)
+	cmd.Flags().DurationVar(&cfg.Timeouts.KeyGen.Round, "keygen-round-timeout", cfg.Timeouts.KeyGen.Round, "Fail keygen if a round does not complete in time (0 to disable)")
+	cmd.Flags().DurationVar(&cfg.Timeouts.KeyGen.Overall, "keygen-timeout", cfg.Timeouts.KeyGen.Overall, "Fail keygen if it does not complete in time (0 to disable)")
+	cmd.Flags().DurationVar(&cfg.Timeouts.Signing.Round, "signing-round-timeout", cfg.Timeouts.Signing.Round, "Fail signing if a round does not complete in time (0 to disable)")
+	cmd.Flags().DurationVar(&cfg.Timeouts.Signing.Overall, "signing-timeout", cfg.Timeouts.Signing.Overall, "Fail signing if it does not complete in time (0 to disable)")
+	cmd.Flags().IntVar(&cfg.Workers, "workers", 0, "Protocol updates computed at a time across all sessions (default: one per CPU)")
+	cmd.Flags().IntVar(&cfg.PreParamsPoolSize, "preparams-pool", cfg.PreParamsPoolSize, "Keygen pre-parameter sets generated ahead in the background (0 to disable)")
+	cmd.Flags().StringVar(&cfg.Tracing.Exporter, "trace-exporter", cfg.Tracing.Exporter, `Export session traces: "none", "stdout" or "otlp"`)
+	cmd.Flags().StringVar(&cfg.Tracing.OTLPEndpoint, "otlp-endpoint", cfg.Tracing.OTLPEndpoint, "Host and port of the OTLP/HTTP trace collector")
+	cmd.Flags().StringVar(&cfg.Discovery.KnownPeersFile, "peerstore", cfg.Discovery.KnownPeersFile, "File persisting known peers for reconnection (empty to disable)")
 	return cmd
 }
 
@@ -79,20 +129,25 @@ func NewPartyCmd() *cobra.Command {
 func NewPartyCreateCmd() *cobra.Command {
 	var (
 		members   string
+		size      int
 		threshold int
+		scheme    string
 	)
 
 	cmd := &cobra.Command{
 		Use:   "create",
 		Short: "Create a new TSS party",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return createParty(members, threshold)
+			return createParty(members, size, threshold, scheme)
 		},
 	}
 
 	cmd.Flags().StringVarP(&members, "members", "m", "", "Comma-separated list of peer IDs")
+	cmd.Flags().IntVarP(&size, "size", "s", 0, "Select this many members by peer reputation instead of --members")
 	cmd.Flags().IntVarP(&threshold, "threshold", "t", 2, "Threshold for the party")
-	cmd.MarkFlagRequired("members")
+	cmd.Flags().StringVar(&scheme, "scheme", string(SchemeECDSA), `Signature scheme of the key to generate: "ecdsa" or "eddsa"`)
+	cmd.MarkFlagsOneRequired("members", "size")
+	cmd.MarkFlagsMutuallyExclusive("members", "size")
 
 	return cmd
 }
@@ -124,6 +179,47 @@ func NewPartyInfoCmd() *cobra.Command {
 	return cmd
 }
 
+func NewPeersCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "peers",
+		Short: "Peer reputation commands",
+	}
+
+	cmd.AddCommand(
+		NewPeersListCmd(),
+		NewPeersInfoCmd(),
+	)
+
+	return cmd
+}
+
+func NewPeersListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List known peers ordered by reputation",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listPeers()
+		},
+	}
+}
+
+func NewPeersInfoCmd() *cobra.Command {
+	var peerID string
+
+	cmd := &cobra.Command{
+		Use:   "info",
+		Short: "Get the reputation of a specific peer",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return getPeerInfo(peerID)
+		},
+	}
+
+	cmd.Flags().StringVarP(&peerID, "peer-id", "p", "", "Peer ID to get information for")
+	cmd.MarkFlagRequired("peer-id")
+
+	return cmd
+}
+
 func NewKeygenCmd() *cobra.Command {
 	var partyID string
 
@@ -143,35 +239,408 @@ func NewKeygenCmd() *cobra.Command {
 
 func NewSignCmd() *cobra.Command {
 	var (
-		partyID string
-		message string
+		keyID      string
+		message    string
+		messageHex string
+		file       string
+		hash       string
+		pathStr    string
 	)
 
 	cmd := &cobra.Command{
 		Use:   "sign",
-		Short: "Initiate signing process for a party",
+		Short: "Sign a message with a committee key",
+		Long: `Sign a message with a committee key. The message is given as text with
+--message, as hex with --message-hex or read from --file ("-" reads standard
+input), and hashed with --hash. ECDSA keys only sign 32-byte digests, so with
+--hash none the message must already be one. With --derivation-path, an ECDSA
+key signs with its BIP32 child key at that path, which may only have
+non-hardened segments. The signers are selected automatically among the key
+holders.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return initiateSigningProcess(partyID, message)
+			msg, err := cliinput.ReadMessage(message, messageHex, file)
+			if err != nil {
+				return err
+			}
+			hashFunc, err := chain.ParseHash(hash)
+			if err != nil {
+				return err
+			}
+			var path DerivationPath
+			if pathStr != "" {
+				if path, err = ParseDerivationPath(pathStr); err != nil {
+					return err
+				}
+			}
+			return initiateSigningProcess(keyID, path, msg, hashFunc)
 		},
 	}
 
-	cmd.Flags().StringVarP(&partyID, "party-id", "p", "", "Party ID for signing")
+	cmd.Flags().StringVarP(&keyID, "key-id", "k", "", "Key ID to sign with")
 	cmd.Flags().StringVarP(&message, "message", "m", "", "Message to sign")
-	cmd.MarkFlagRequired("party-id")
+	cmd.Flags().StringVar(&messageHex, "message-hex", "", "Hex-encoded message to sign")
+	cmd.Flags().StringVarP(&file, "file", "f", "", "File with the message to sign")
+	cmd.Flags().StringVar(&hash, "hash", string(chain.HashSHA256), `Hash of the message: "sha256", "keccak256", "sha512_256" or "none"`)
+	cmd.Flags().StringVar(&pathStr, "derivation-path", "", `Sign with the child key at this BIP32 path, e.g. "m/0/7"`)
+	cmd.MarkFlagRequired("key-id")
+	cmd.MarkFlagsOneRequired("message", "message-hex", "file")
+	cmd.MarkFlagsMutuallyExclusive("message", "message-hex", "file")
+
+	cmd.AddCommand(
+		NewSignPSBTCmd(),
+		NewSignSolanaCmd(),
+	)
+
+	return cmd
+}
+
+func NewSignPSBTCmd() *cobra.Command {
+	var (
+		keyID  string
+		file   string
+		output string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "psbt",
+		Short: "Sign the inputs of a Bitcoin PSBT",
+		Long: `Sign the P2WPKH and P2PKH inputs of a PSBT spending outputs of a committee key,
+with one signing session per input. The PSBT is read from --file in base64, hex
+or binary form ("-" reads standard input) and written back in base64 with the
+signatures added, to standard output or to --output.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return signPSBT(keyID, file, output)
+		},
+	}
+
+	cmd.Flags().StringVarP(&keyID, "key-id", "k", "", "Key ID to sign with")
+	cmd.Flags().StringVarP(&file, "file", "f", "", "File with the PSBT to sign")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "File to write the signed PSBT to")
+	cmd.MarkFlagRequired("key-id")
+	cmd.MarkFlagRequired("file")
+
+	return cmd
+}
+
+func NewSignSolanaCmd() *cobra.Command {
+	var (
+		keyID string
+		file  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "solana",
+		Short: "Sign a Solana transaction message with an EdDSA key",
+		Long: `Sign a serialized Solana message with an EdDSA committee key, which must be one
+of the required signers. The message is read from --file in base64 or base58
+("-" reads standard input). The signed transaction is printed in base58 and
+base64; the signatures of other required signers are left empty.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return signSolana(keyID, file)
+		},
+	}
+
+	cmd.Flags().StringVarP(&keyID, "key-id", "k", "", "Key ID to sign with")
+	cmd.Flags().StringVarP(&file, "file", "f", "", "File with the message to sign")
+	cmd.MarkFlagRequired("key-id")
+	cmd.MarkFlagRequired("file")
+
+	return cmd
+}
+
+func NewSignBatchCmd() *cobra.Command {
+	var (
+		keyID string
+		file  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "sign-batch [digest...]",
+		Short: "Sign many digests with a committee key at once",
+		Long: `Sign hex-encoded digests with a committee key. The signers are selected once
+for the whole batch and the signing sessions run in parallel. Digests are read
+from the arguments and from --file, one per line ("-" reads standard input).`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			digests, err := readDigests(args, file)
+			if err != nil {
+				return err
+			}
+			return initiateBatchSigning(keyID, digests)
+		},
+	}
+
+	cmd.Flags().StringVarP(&keyID, "key-id", "k", "", "Key ID to sign with")
+	cmd.Flags().StringVarP(&file, "file", "f", "", "File with one hex-encoded digest per line")
+	cmd.MarkFlagRequired("key-id")
+
+	return cmd
+}
+
+func NewEthCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "eth",
+		Short: "Ethereum message signing commands",
+	}
+
+	cmd.AddCommand(
+		NewEthSignMessageCmd(),
+		NewEthSignTypedDataCmd(),
+		NewEthVerifyCmd(),
+	)
+
+	return cmd
+}
+
+func NewEthSignMessageCmd() *cobra.Command {
+	var (
+		keyID   string
+		message string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "sign-message",
+		Short: "Sign a message as personal_sign does",
+		Long: `Sign the EIP-191 digest of a message with a committee key. The 65-byte
+signature recovers to the Ethereum address of the key.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			signature, err := globalNode.SignPersonalMessage(context.Background(), keyID, []byte(message))
+			if err != nil {
+				return fmt.Errorf("failed to sign message: %w", err)
+			}
+			printEthereumSignature(signature)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&keyID, "key-id", "k", "", "Key ID to sign with")
+	cmd.Flags().StringVarP(&message, "message", "m", "", "Message to sign")
+	cmd.MarkFlagRequired("key-id")
 	cmd.MarkFlagRequired("message")
 
 	return cmd
 }
 
+func NewEthSignTypedDataCmd() *cobra.Command {
+	var (
+		keyID string
+		file  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "sign-typed-data",
+		Short: "Sign EIP-712 typed data",
+		Long: `Sign an EIP-712 typed data JSON document, as accepted by eth_signTypedData_v4,
+with a committee key ("-" reads standard input).`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			document, err := cliinput.ReadInput(file)
+			if err != nil {
+				return err
+			}
+			signature, err := globalNode.SignTypedData(context.Background(), keyID, document)
+			if err != nil {
+				return fmt.Errorf("failed to sign typed data: %w", err)
+			}
+			printEthereumSignature(signature)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&keyID, "key-id", "k", "", "Key ID to sign with")
+	cmd.Flags().StringVarP(&file, "file", "f", "", "File with the typed data JSON document")
+	cmd.MarkFlagRequired("key-id")
+	cmd.MarkFlagRequired("file")
+
+	return cmd
+}
+
+func NewEthVerifyCmd() *cobra.Command {
+	var (
+		address   string
+		signature string
+		message   string
+		typedData string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify an Ethereum message signature offline",
+		Long: `Verify that a 65-byte signature of a message or of an EIP-712 typed data
+document recovers to an Ethereum address. No node is started.`,
+		Annotations: map[string]string{annotationOffline: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return verifyEthereumSignature(address, signature, message, typedData)
+		},
+	}
+
+	cmd.Flags().StringVarP(&address, "address", "a", "", "Ethereum address of the signer")
+	cmd.Flags().StringVarP(&signature, "signature", "s", "", "Hex-encoded 65-byte signature")
+	cmd.Flags().StringVarP(&message, "message", "m", "", "Signed message")
+	cmd.Flags().StringVarP(&typedData, "typed-data", "f", "", "File with the signed typed data JSON document")
+	cmd.MarkFlagRequired("address")
+	cmd.MarkFlagRequired("signature")
+	cmd.MarkFlagsOneRequired("message", "typed-data")
+	cmd.MarkFlagsMutuallyExclusive("message", "typed-data")
+
+	return cmd
+}
+
+func NewCosmosCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cosmos",
+		Short: "Cosmos SDK signing commands",
+	}
+
+	cmd.AddCommand(
+		NewCosmosSignDirectCmd(),
+		NewCosmosAddressCmd(),
+	)
+
+	return cmd
+}
+
+func NewCosmosSignDirectCmd() *cobra.Command {
+	var (
+		keyID string
+		file  string
+		hrp   string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "sign-direct",
+		Short: "Sign a SignDoc in SIGN_MODE_DIRECT",
+		Long: `Sign the SHA-256 digest of a protobuf-encoded SignDoc with a committee key. The
+SignDoc is read from --file as JSON with base64 body and auth info bytes, the
+chain ID and the account number ("-" reads standard input).`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return signCosmosDirect(keyID, file, hrp)
+		},
+	}
+
+	cmd.Flags().StringVarP(&keyID, "key-id", "k", "", "Key ID to sign with")
+	cmd.Flags().StringVarP(&file, "file", "f", "", "File with the SignDoc JSON document")
+	cmd.Flags().StringVar(&hrp, "hrp", cosmos.DefaultHRP, "Human-readable part of the account address")
+	cmd.MarkFlagRequired("key-id")
+	cmd.MarkFlagRequired("file")
+
+	return cmd
+}
+
+func NewCosmosAddressCmd() *cobra.Command {
+	var (
+		keyID string
+		hrp   string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "address",
+		Short: "Show the account address of a committee key",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			address, err := globalNode.CosmosAddress(keyID, hrp)
+			if err != nil {
+				return fmt.Errorf("failed to get address: %w", err)
+			}
+			fmt.Printf("Address: %s\n", address)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&keyID, "key-id", "k", "", "Key ID")
+	cmd.Flags().StringVar(&hrp, "hrp", cosmos.DefaultHRP, "Human-readable part of the account address")
+	cmd.MarkFlagRequired("key-id")
+
+	return cmd
+}
+
+func NewKeysCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "keys",
+		Short: "Key share commands",
+	}
+
+	cmd.AddCommand(
+		NewKeysShowCmd(),
+	)
+
+	return cmd
+}
+
+func NewKeysShowCmd() *cobra.Command {
+	var (
+		keyStoreDir string
+		file        string
+		format      string
+		network     string
+		hrp         string
+		pathStr     string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "show [key-id]",
+		Short: "Show the public key and addresses of a committee key",
+		Long: `Show the public key of a committee key as compressed and uncompressed hex,
+SubjectPublicKeyInfo PEM and JWK, together with its Ethereum, Bitcoin P2PKH and
+P2WPKH and Cosmos addresses, or its Solana address for EdDSA keys. The key share
+is read from the key store, or from --file for a share written by the
+keygen-simulate command of the simulator. ECDSA keys generated with a chain
+code are shown with their BIP32 extended public key, and --derivation-path
+shows the child key at a non-hardened path instead. No node is started.`,
+		Args:        cobra.MaximumNArgs(1),
+		Annotations: map[string]string{annotationOffline: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (len(args) == 0) == (file == "") {
+				return errors.New("either a key ID or --file must be given")
+			}
+			var keyID string
+			if len(args) > 0 {
+				keyID = args[0]
+			}
+			var path DerivationPath
+			if pathStr != "" {
+				var err error
+				if path, err = ParseDerivationPath(pathStr); err != nil {
+					return err
+				}
+			}
+			return showKey(keyStoreDir, keyID, file, path, format, network, hrp)
+		},
+	}
+
+	cmd.Flags().StringVar(&keyStoreDir, "keystore", DefaultKeyStoreDir, "Directory holding the node's key shares")
+	cmd.Flags().StringVarP(&file, "file", "f", "", "File with an ECDSA key share of keygen-simulate instead of a key ID")
+	cmd.Flags().StringVar(&format, "format", "text", `Output format: "text" or "json"`)
+	cmd.Flags().StringVar(&network, "btc-network", "mainnet", `Bitcoin network of the addresses: "mainnet", "testnet", "signet" or "regtest"`)
+	cmd.Flags().StringVar(&hrp, "hrp", cosmos.DefaultHRP, "Human-readable part of the Cosmos address")
+	cmd.Flags().StringVar(&pathStr, "derivation-path", "", `Show the child key at this BIP32 path, e.g. "m/0/7"`)
+
+	return cmd
+}
+
 // Command execution functions
 
-func startNode(ctx context.Context, keyFile string) error {
+func startNode(ctx context.Context, keyFile string, cfg NodeConfig) error {
 	privKey, err := loadOrCreatePrivateKey(keyFile)
 	if err != nil {
 		return fmt.Errorf("failed to load or create private key: %w", err)
 	}
 
-	node, err := NewNode(ctx, privKey)
+	nodeID, err := peer.IDFromPrivateKey(privKey)
+	if err != nil {
+		return fmt.Errorf("failed to derive peer ID: %w", err)
+	}
+	shutdownTracing, err := setupTracing(ctx, cfg.Tracing, nodeID.String())
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	defer func() {
+		// ctx is already cancelled when the node stops
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			slog.Warn("Error flushing traces", LogKeyError, err)
+		}
+	}()
+
+	node, err := NewNode(ctx, privKey, cfg)
 	if err != nil {
 		return fmt.Errorf("failed to create node: %w", err)
 	}
@@ -180,25 +649,39 @@ func startNode(ctx context.Context, keyFile string) error {
 		return fmt.Errorf("failed to start node: %w", err)
 	}
 
-	fmt.Printf("Node started with ID: %s\n", node.host.ID().String())
+	node.logger.Info("Node started", "listen", node.host.Addrs())
 
 	// Wait for interrupt signal
 	<-ctx.Done()
 	return node.Stop()
 }
 
-func createParty(membersStr string, threshold int) error {
-	members := strings.Split(membersStr, ",")
-	peerIDs := make([]peer.ID, len(members))
-	for i, m := range members {
-		peerID, err := peer.Decode(m)
+func createParty(membersStr string, size int, threshold int, schemeStr string) error {
+	scheme, err := ParseScheme(schemeStr)
+	if err != nil {
+		return err
+	}
+
+	var peerIDs []peer.ID
+	if size > 0 {
+		selected, err := globalNode.SelectPartyMembers(size)
 		if err != nil {
-			return fmt.Errorf("invalid peer ID %s: %w", m, err)
+			return fmt.Errorf("failed to select party members: %w", err)
+		}
+		peerIDs = selected
+	} else {
+		members := strings.Split(membersStr, ",")
+		peerIDs = make([]peer.ID, len(members))
+		for i, m := range members {
+			peerID, err := peer.Decode(m)
+			if err != nil {
+				return fmt.Errorf("invalid peer ID %s: %w", m, err)
+			}
+			peerIDs[i] = peerID
 		}
-		peerIDs[i] = peerID
 	}
 
-	party, err := globalNode.CreateParty(context.Background(), peerIDs, threshold, TSSOperationKeyGen)
+	party, err := globalNode.CreateParty(context.Background(), peerIDs, threshold, TSSOperationKeyGen, scheme)
 	if err != nil {
 		return fmt.Errorf("failed to create party: %w", err)
 	}
@@ -234,40 +717,322 @@ func getPartyInfo(partyID string) error {
 	return nil
 }
 
-func initiateKeyGeneration(partyID string) error {
-	msg := &Message{
-		Type:    MessageTypeKeyGeneration,
-		PartyID: partyID,
-		From:    globalNode.host.ID(),
-		Payload: json.RawMessage(`{"action": "start_keygen"}`),
+func listPeers() error {
+	fmt.Println("Peers:")
+	for _, rep := range globalNode.PeerReputations() {
+		fmt.Printf("- ID: %s, Score: %.2f, Healthy: %t, Latency: %s, Completion: %.0f%%, Blames: %d, Invalid messages: %d\n",
+			rep.PeerID, rep.Score(), rep.Healthy(), rep.Latency, 100*rep.CompletionRate(), rep.BlameCount, rep.InvalidMessages)
+	}
+	return nil
+}
+
+func getPeerInfo(peerIDStr string) error {
+	peerID, err := peer.Decode(peerIDStr)
+	if err != nil {
+		return fmt.Errorf("invalid peer ID %s: %w", peerIDStr, err)
+	}
+
+	rep, exists := globalNode.PeerReputation(peerID)
+	if !exists {
+		return fmt.Errorf("no reputation recorded for peer: %s", peerID)
 	}
 
-	if err := globalNode.msgRouter.SendMessage(context.Background(), msg); err != nil {
+	fmt.Printf("Peer ID: %s\n", rep.PeerID)
+	fmt.Printf("Score: %.2f\n", rep.Score())
+	fmt.Printf("Healthy: %t\n", rep.Healthy())
+	fmt.Printf("Last seen: %s\n", rep.LastSeen.Format(time.RFC3339))
+	fmt.Printf("Latency: %s\n", rep.Latency)
+	fmt.Printf("Ping failures: %d\n", rep.PingFailures)
+	fmt.Printf("Sessions: %d/%d completed\n", rep.SessionsCompleted, rep.SessionsStarted)
+	fmt.Printf("Blames: %d\n", rep.BlameCount)
+	fmt.Printf("Invalid messages: %d\n", rep.InvalidMessages)
+	return nil
+}
+
+func initiateKeyGeneration(partyID string) error {
+	session, err := globalNode.StartKeyGeneration(context.Background(), partyID)
+	if err != nil {
 		return fmt.Errorf("failed to initiate key generation: %w", err)
 	}
 
 	fmt.Printf("Key generation initiated for party: %s\n", partyID)
+
+	if err := session.Wait(context.Background()); err != nil {
+		return fmt.Errorf("key generation failed: %w", err)
+	}
+
+	fmt.Printf("Key generated with ID: %s\n", partyID)
+	return nil
+}
+
+func initiateSigningProcess(keyID string, path DerivationPath, message []byte, hash chain.Hash) error {
+	digest, err := hash.Digest(message)
+	if err != nil {
+		return err
+	}
+
+	signature, err := globalNode.SignDerived(context.Background(), keyID, path, digest)
+	if err != nil {
+		return fmt.Errorf("failed to sign message: %w", err)
+	}
+
+	if len(path) > 0 {
+		record, err := globalNode.GetKeyShare(keyID)
+		if err != nil {
+			return err
+		}
+		child, _, err := record.DeriveKey(path)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Derivation path: %s\n", path)
+		fmt.Printf("Public key: %x\n", cosmos.CompressedPubKey(child.PublicKey()))
+	}
+	fmt.Printf("Hash: %s\n", hash)
+	fmt.Printf("Digest: %x\n", digest)
+	fmt.Printf("R: %x\n", signature.GetR())
+	fmt.Printf("S: %x\n", signature.GetS())
+	return nil
+}
+
+func initiateBatchSigning(keyID string, digests [][]byte) error {
+	results, err := globalNode.SignBatch(context.Background(), keyID, digests)
+	var batchErr *BatchSignError
+	if err != nil && !errors.As(err, &batchErr) {
+		return fmt.Errorf("failed to sign batch: %w", err)
+	}
+
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Printf("%x error: %v\n", result.Digest, result.Err)
+			continue
+		}
+		fmt.Printf("%x R: %x S: %x\n", result.Digest, result.Signature.GetR(), result.Signature.GetS())
+	}
+	return err
+}
+
+func signPSBT(keyID, file, output string) error {
+	data, err := cliinput.ReadInput(file)
+	if err != nil {
+		return err
+	}
+	packet, err := bitcoin.ParsePSBT(data)
+	if err != nil {
+		return err
+	}
+
+	inputs, err := globalNode.SignPSBT(context.Background(), keyID, packet)
+	if err != nil {
+		return fmt.Errorf("failed to sign PSBT: %w", err)
+	}
+	for _, input := range inputs {
+		slog.Info("Signed PSBT input", "index", input.Index, "type", input.ScriptType, "sighash", fmt.Sprintf("%x", input.Sighash))
+	}
+
+	encoded, err := packet.B64Encode()
+	if err != nil {
+		return fmt.Errorf("failed to encode PSBT: %w", err)
+	}
+	if output == "" {
+		fmt.Println(encoded)
+		return nil
+	}
+	return os.WriteFile(output, []byte(encoded+"\n"), 0644)
+}
+
+func signSolana(keyID, file string) error {
+	data, err := cliinput.ReadInput(file)
+	if err != nil {
+		return err
+	}
+	msg, err := solana.ParseMessage(data)
+	if err != nil {
+		return err
+	}
+
+	signed, err := globalNode.SignSolanaMessage(context.Background(), keyID, msg)
+	if err != nil {
+		return fmt.Errorf("failed to sign message: %w", err)
+	}
+
+	fmt.Printf("Address: %s\n", signed.Address)
+	fmt.Printf("Signature: %s\n", base58.Encode(signed.Signature))
+	fmt.Printf("Transaction (base58): %s\n", base58.Encode(signed.Transaction))
+	fmt.Printf("Transaction (base64): %s\n", base64.StdEncoding.EncodeToString(signed.Transaction))
+	if missing := msg.NumRequiredSignatures - 1; missing > 0 {
+		fmt.Printf("The transaction needs %d more signatures\n", missing)
+	}
 	return nil
 }
 
-func initiateSigningProcess(partyID string, message string) error {
-	msg := &Message{
-		Type:    MessageTypeSigning,
-		PartyID: partyID,
-		From:    globalNode.host.ID(),
-		Payload: json.RawMessage(fmt.Sprintf(`{"message": "%s"}`, message)),
+func signCosmosDirect(keyID, file, hrp string) error {
+	data, err := cliinput.ReadInput(file)
+	if err != nil {
+		return err
+	}
+	doc, err := cosmos.ParseSignDoc(data)
+	if err != nil {
+		return err
 	}
 
-	if err := globalNode.msgRouter.SendMessage(context.Background(), msg); err != nil {
-		return fmt.Errorf("failed to initiate signing process: %w", err)
+	signature, err := globalNode.SignCosmosDirect(context.Background(), keyID, doc, hrp)
+	if err != nil {
+		return fmt.Errorf("failed to sign sign doc: %w", err)
 	}
 
-	fmt.Printf("Signing process initiated for party: %s\n", partyID)
+	fmt.Printf("Address: %s\n", signature.Address)
+	fmt.Printf("Public key: %s\n", base64.StdEncoding.EncodeToString(signature.PubKey))
+	fmt.Printf("Digest: %x\n", signature.Digest)
+	fmt.Printf("Signature: %s\n", base64.StdEncoding.EncodeToString(signature.Signature))
+	return nil
+}
+
+func verifyEthereumSignature(addressStr, signatureStr, message, typedData string) error {
+	if !ethcommon.IsHexAddress(addressStr) {
+		return fmt.Errorf("invalid address: %s", addressStr)
+	}
+	signature, err := hex.DecodeString(strings.TrimPrefix(signatureStr, "0x"))
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+
+	digest := ethereum.PersonalMessageHash([]byte(message))
+	if typedData != "" {
+		document, err := cliinput.ReadInput(typedData)
+		if err != nil {
+			return err
+		}
+		if digest, err = ethereum.TypedDataHash(document); err != nil {
+			return err
+		}
+	}
+
+	if err := ethereum.VerifyMessageSignature(digest, signature, ethcommon.HexToAddress(addressStr)); err != nil {
+		return fmt.Errorf("signature is invalid: %w", err)
+	}
+
+	fmt.Printf("Digest: %s\n", digest.Hex())
+	fmt.Println("Signature is valid")
+	return nil
+}
+
+func showKey(keyStoreDir, keyID, file string, path DerivationPath, format, network, hrp string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown output format: %q", format)
+	}
+	params, err := bitcoinNetworkParams(network)
+	if err != nil {
+		return err
+	}
+
+	var record *KeyShareRecord
+	if file != "" {
+		data, err := cliinput.ReadInput(file)
+		if err != nil {
+			return err
+		}
+		var share keygen.LocalPartySaveData
+		if err := json.Unmarshal(data, &share); err != nil || share.ECDSAPub == nil {
+			return fmt.Errorf("%s is not an ECDSA key share", file)
+		}
+		record = &KeyShareRecord{Share: &share}
+	} else {
+		record, err = NewKeyStore(keyStoreDir, NewMetrics()).Load(keyID)
+		if err != nil {
+			return fmt.Errorf("failed to load key share: %w", err)
+		}
+	}
+
+	info, err := describeKey(record, path, params, hrp)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		data, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal key: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	jwk, err := json.Marshal(info.PublicKey.JWK)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JWK: %w", err)
+	}
+	if info.KeyID != "" {
+		fmt.Printf("Key ID: %s\n", info.KeyID)
+	}
+	fmt.Printf("Scheme: %s\n", info.Scheme)
+	if info.Threshold > 0 {
+		fmt.Printf("Threshold: %d\n", info.Threshold)
+	}
+	if info.ExtendedPublicKey != "" {
+		fmt.Printf("Derivation path: %s\n", info.DerivationPath)
+		fmt.Printf("Extended public key: %s\n", info.ExtendedPublicKey)
+	}
+	fmt.Printf("Public key (compressed): %s\n", info.PublicKey.Compressed)
+	if info.PublicKey.Uncompressed != "" {
+		fmt.Printf("Public key (uncompressed): %s\n", info.PublicKey.Uncompressed)
+	}
+	fmt.Printf("JWK: %s\n", jwk)
+	for _, address := range []struct{ name, value string }{
+		{"Ethereum", info.Addresses.Ethereum},
+		{"Bitcoin P2PKH", info.Addresses.BitcoinP2PKH},
+		{"Bitcoin P2WPKH", info.Addresses.BitcoinP2WPKH},
+		{"Cosmos", info.Addresses.Cosmos},
+		{"Solana", info.Addresses.Solana},
+	} {
+		if address.value != "" {
+			fmt.Printf("%s address: %s\n", address.name, address.value)
+		}
+	}
+	fmt.Print(info.PublicKey.PEM)
 	return nil
 }
 
 // Helper functions
 
+// annotationOffline marks commands that run without a node.
+const annotationOffline = "offline"
+
+func printEthereumSignature(signature *EthereumSignature) {
+	fmt.Printf("Address: %s\n", signature.Address.Hex())
+	fmt.Printf("Digest: %s\n", signature.Digest.Hex())
+	fmt.Printf("Signature: 0x%x\n", signature.Signature)
+}
+
+// readDigests decodes the hex-encoded digests of args and of the lines of file.
+func readDigests(args []string, file string) ([][]byte, error) {
+	lines := args
+	if file != "" {
+		data, err := cliinput.ReadInput(file)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, strings.Split(string(data), "\n")...)
+	}
+
+	var digests [][]byte
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		digest, err := hex.DecodeString(strings.TrimPrefix(line, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid digest %q: %w", line, err)
+		}
+		digests = append(digests, digest)
+	}
+	if len(digests) == 0 {
+		return nil, errors.New("no digests to sign")
+	}
+	return digests, nil
+}
+
 func loadOrCreatePrivateKey(keyFile string) (crypto.PrivKey, error) {
 	privKey, err := loadPrivateKey(keyFile)
 	if err == nil {
@@ -306,18 +1071,21 @@ func savePrivateKey(privKey crypto.PrivKey, keyFile string) error {
 
 var globalNode *Node // Global node instance for CLI commands
 
-func init() {
-	// Initialize the global node instance
-	ctx := context.Background()
+func initGlobalNode(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
 	privKey, err := loadOrCreatePrivateKey("node_key")
 	if err != nil {
-		log.Fatalf("Failed to initialize node: %v", err)
+		return fmt.Errorf("failed to initialize node: %w", err)
 	}
 
-	node, err := NewNode(ctx, privKey)
+	node, err := NewNode(ctx, privKey, DefaultNodeConfig())
 	if err != nil {
-		log.Fatalf("Failed to create node: %v", err)
+		return fmt.Errorf("failed to create node: %w", err)
 	}
 
 	globalNode = node
+	return nil
 }
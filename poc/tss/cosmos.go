@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/keruch/thesis/poc/chain/cosmos"
+)
+
+// CosmosSignature is a SIGN_MODE_DIRECT signature of a committee key.
+type CosmosSignature struct {
+	Address   string
+	PubKey    []byte
+	Digest    [32]byte
+	Signature []byte
+}
+
+// SignCosmosDirect signs doc in SIGN_MODE_DIRECT with the key keyID. The
+// account address is encoded with the human-readable part hrp.
+func (n *Node) SignCosmosDirect(ctx context.Context, keyID string, doc *cosmos.SignDoc, hrp string) (*CosmosSignature, error) {
+	record, err := n.keyStore.Load(keyID)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := record.ECDSAPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	address, err := cosmos.Address(pub, hrp)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := doc.Hash()
+	data, err := n.Sign(ctx, keyID, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := cosmos.Signature(digest, data, pub)
+	if err != nil {
+		return nil, fmt.Errorf("invalid threshold signature: %w", err)
+	}
+
+	return &CosmosSignature{
+		Address:   address,
+		PubKey:    cosmos.CompressedPubKey(pub),
+		Digest:    digest,
+		Signature: sig,
+	}, nil
+}
+
+// CosmosAddress returns the account address of the key keyID.
+func (n *Node) CosmosAddress(keyID, hrp string) (string, error) {
+	record, err := n.keyStore.Load(keyID)
+	if err != nil {
+		return "", err
+	}
+	pub, err := record.ECDSAPublicKey()
+	if err != nil {
+		return "", err
+	}
+	return cosmos.Address(pub, hrp)
+}
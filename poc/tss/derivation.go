@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/bnb-chain/tss-lib/v2/crypto/ckd"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+const (
+	// ChainCodeLength is the length of the BIP32 chain code of an ECDSA key.
+	ChainCodeLength = 32
+	// MaxDerivationDepth is the longest derivation path BIP32 allows.
+	MaxDerivationDepth = 255
+)
+
+// DerivationPath is a BIP32 derivation path of non-hardened child indexes.
+// Hardened derivation needs the private key, which no node has.
+type DerivationPath []uint32
+
+// ParseDerivationPath parses a path such as "m/0/7". Hardened segments, like
+// "0'" or "0h", are rejected.
+func ParseDerivationPath(s string) (DerivationPath, error) {
+	segments := strings.Split(strings.TrimSpace(s), "/")
+	if segments[0] != "m" {
+		return nil, fmt.Errorf("derivation path %q does not start with m", s)
+	}
+
+	path := make(DerivationPath, 0, len(segments)-1)
+	for _, segment := range segments[1:] {
+		if strings.HasSuffix(segment, "'") || strings.HasSuffix(segment, "h") || strings.HasSuffix(segment, "H") {
+			return nil, fmt.Errorf("hardened segment %s in derivation path %q: only non-hardened derivation is possible with threshold keys", segment, s)
+		}
+		index, err := strconv.ParseUint(segment, 10, 32)
+		if err != nil || index >= ckd.HardenedKeyStart {
+			return nil, fmt.Errorf("invalid segment %q in derivation path %q", segment, s)
+		}
+		path = append(path, uint32(index))
+	}
+	if err := path.Validate(); err != nil {
+		return nil, err
+	}
+	return path, nil
+}
+
+// Validate returns an error if the path cannot be derived.
+func (p DerivationPath) Validate() error {
+	if len(p) > MaxDerivationDepth {
+		return fmt.Errorf("derivation path of depth %d exceeds %d", len(p), MaxDerivationDepth)
+	}
+	for _, index := range p {
+		if index >= ckd.HardenedKeyStart {
+			return fmt.Errorf("hardened index %d in derivation path", index)
+		}
+	}
+	return nil
+}
+
+func (p DerivationPath) String() string {
+	var b strings.Builder
+	b.WriteString("m")
+	for _, index := range p {
+		b.WriteString("/")
+		b.WriteString(strconv.FormatUint(uint64(index), 10))
+	}
+	return b.String()
+}
+
+// ExtendedKey is a BIP32 extended public key.
+type ExtendedKey struct {
+	key *ckd.ExtendedKey
+}
+
+// PublicKey returns the public key of the extended key.
+func (k *ExtendedKey) PublicKey() *ecdsa.PublicKey {
+	return &k.key.PublicKey
+}
+
+// String returns the key serialized as an xpub.
+func (k *ExtendedKey) String() string {
+	return k.key.String()
+}
+
+// DeriveChildKey derives the extended key at path from the master public key
+// pub and its chain code. It also returns the key derivation delta, the sum of
+// the BIP32 tweaks along path: the child key is pub + delta*G, and signers add
+// delta to their shares to sign with it.
+func DeriveChildKey(pub *ecdsa.PublicKey, chainCode []byte, path DerivationPath) (*ExtendedKey, *big.Int, error) {
+	if len(chainCode) != ChainCodeLength {
+		return nil, nil, fmt.Errorf("invalid chain code length: %d", len(chainCode))
+	}
+	if err := path.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	master := &ckd.ExtendedKey{
+		PublicKey: ecdsa.PublicKey{Curve: tss.S256(), X: pub.X, Y: pub.Y},
+		ChainCode: chainCode,
+		ParentFP:  []byte{0, 0, 0, 0},
+		Version:   chaincfg.MainNetParams.HDPublicKeyID[:],
+	}
+	if len(path) == 0 {
+		return &ExtendedKey{key: master}, new(big.Int), nil
+	}
+
+	delta, child, err := ckd.DeriveChildKeyFromHierarchy(path, master, tss.S256().Params().N, tss.S256())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to derive %s: %w", path, err)
+	}
+	return &ExtendedKey{key: child}, delta, nil
+}
+
+// newChainCode returns a random chain code for a new key.
+func newChainCode() ([]byte, error) {
+	chainCode := make([]byte, ChainCodeLength)
+	if _, err := rand.Read(chainCode); err != nil {
+		return nil, fmt.Errorf("failed to generate chain code: %w", err)
+	}
+	return chainCode, nil
+}
+
+// errNoChainCode is returned when deriving from keys generated without a chain code.
+var errNoChainCode = errors.New("key has no chain code: it was generated without BIP32 support")
+
+// DeriveKey derives the child key at path of an ECDSA key, see DeriveChildKey.
+func (r *KeyShareRecord) DeriveKey(path DerivationPath) (*ExtendedKey, *big.Int, error) {
+	pub, err := r.ECDSAPublicKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(r.ChainCode) == 0 {
+		return nil, nil, errNoChainCode
+	}
+	return DeriveChildKey(pub, r.ChainCode, path)
+}
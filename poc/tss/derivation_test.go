@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	tsscrypto "github.com/bnb-chain/tss-lib/v2/crypto"
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+func TestDeriveChildKey(t *testing.T) {
+	// BIP32 test vector 2
+	seed, err := hex.DecodeString("fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542")
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	masterPub, err := master.Neuter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	btcPub, err := masterPub.ECPubKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := btcPub.ToECDSA()
+
+	for _, test := range []struct {
+		path string
+		want string
+	}{
+		{"m", "xpub661MyMwAqRbcFW31YEwpkMuc5THy2PSt5bDMsktWQcFF8syAmRUapSCGu8ED9W6oDMSgv6Zz8idoc4a6mr8BDzTJY47LJhkJ8UB7WEGuduB"},
+		{"m/0", "xpub69H7F5d8KSRgmmdJg2KhpAK8SR3DjMwAdkxj3ZuxV27CprR9LgpeyGmXUbC6wb7ERfvrnKZjXoUmmDznezpbZb7ap6r1D3tgFxHmwMkQTPH"},
+		{"m/0/7", ""},
+		{"m/1/2/3", ""},
+	} {
+		path, err := ParseDerivationPath(test.path)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", test.path, err)
+		}
+		child, delta, err := DeriveChildKey(pub, masterPub.ChainCode(), path)
+		if err != nil {
+			t.Fatalf("failed to derive %s: %v", test.path, err)
+		}
+
+		want := test.want
+		if want == "" {
+			key := masterPub
+			for _, index := range path {
+				if key, err = key.Derive(index); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want = key.String()
+		}
+		if got := child.String(); got != want {
+			t.Fatalf("%s derives %s, want %s", test.path, got, want)
+		}
+
+		// The child key is the master key moved by delta
+		x, y := tss.S256().ScalarBaseMult(delta.Bytes())
+		x, y = tss.S256().Add(pub.X, pub.Y, x, y)
+		if x.Cmp(child.PublicKey().X) != 0 || y.Cmp(child.PublicKey().Y) != 0 {
+			t.Fatalf("child key at %s is not the master key plus delta*G", test.path)
+		}
+	}
+}
+
+func TestParseDerivationPath(t *testing.T) {
+	path, err := ParseDerivationPath("m/0/7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(path) != 2 || path[0] != 0 || path[1] != 7 || path.String() != "m/0/7" {
+		t.Fatalf("m/0/7 parsed as %v", path)
+	}
+
+	for _, s := range []string{"", "0/7", "m/0'/7", "m/0h", "m/0/", "m/-1", "m/2147483648"} {
+		if _, err := ParseDerivationPath(s); err == nil {
+			t.Fatalf("expected an error for derivation path %q", s)
+		}
+	}
+}
+
+func TestDeriveKeyWithoutChainCode(t *testing.T) {
+	priv, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := priv.PubKey()
+	point, err := tsscrypto.NewECPoint(tss.S256(), pub.X(), pub.Y())
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := &KeyShareRecord{KeyID: "key", Share: &keygen.LocalPartySaveData{ECDSAPub: point}}
+	if _, _, err := record.DeriveKey(DerivationPath{0}); !errors.Is(err, errNoChainCode) {
+		t.Fatalf("expected errNoChainCode, got %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/keruch/thesis/poc/chain/ethereum"
+)
+
+// EthereumSignature is a 65-byte Ethereum message signature of a committee key.
+type EthereumSignature struct {
+	Address   ethcommon.Address
+	Digest    ethcommon.Hash
+	Signature []byte
+}
+
+// SignPersonalMessage signs message as EIP-191 personal_sign does with the key keyID.
+func (n *Node) SignPersonalMessage(ctx context.Context, keyID string, message []byte) (*EthereumSignature, error) {
+	return n.signEthereumMessage(ctx, keyID, ethereum.PersonalMessageHash(message))
+}
+
+// SignTypedData signs an EIP-712 typed data JSON document with the key keyID.
+func (n *Node) SignTypedData(ctx context.Context, keyID string, document []byte) (*EthereumSignature, error) {
+	digest, err := ethereum.TypedDataHash(document)
+	if err != nil {
+		return nil, err
+	}
+	return n.signEthereumMessage(ctx, keyID, digest)
+}
+
+func (n *Node) signEthereumMessage(ctx context.Context, keyID string, digest ethcommon.Hash) (*EthereumSignature, error) {
+	record, err := n.keyStore.Load(keyID)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := record.ECDSAPublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := n.Sign(ctx, keyID, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := ethereum.MessageSignature(digest, data, pub)
+	if err != nil {
+		return nil, fmt.Errorf("invalid threshold signature: %w", err)
+	}
+
+	return &EthereumSignature{
+		Address:   ethereum.Address(pub),
+		Digest:    digest,
+		Signature: sig,
+	}, nil
+}
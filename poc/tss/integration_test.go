@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bnb-chain/tss-lib/v2/common"
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+	"github.com/keruch/thesis/poc/chain/solana"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The key shares generated by keygen-simulate carry pre-parameters that are
+// reused so that the tests don't spend minutes generating safe primes.
+const (
+	testPreParamsDir   = "../../data"
+	testPreParamsCount = 4
+)
+
+// testSpans records the spans of every node. The package tracer binds to the
+// first global provider, so it is installed once for all tests.
+var testSpans = tracetest.NewSpanRecorder()
+
+func TestMain(m *testing.M) {
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(testSpans))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	code := m.Run()
+
+	if sharedKey != nil {
+		sharedKey.net.stop()
+	}
+	if sharedKeyDir != "" {
+		os.RemoveAll(sharedKeyDir)
+	}
+	provider.Shutdown(context.Background())
+	os.Exit(code)
+}
+
+type testNetwork struct {
+	mn     mocknet.Mocknet
+	nodes  []*Node
+	cancel context.CancelFunc
+}
+
+// newTestNetwork starts size nodes connected over mocknet, without any real
+// sockets, and stops them when the test ends.
+func newTestNetwork(t *testing.T, size int) *testNetwork {
+	t.Helper()
+
+	net, err := startTestNetwork(size, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(net.stop)
+	return net
+}
+
+// startTestNetwork starts size nodes keeping their key stores below dir.
+// Only the first testPreParamsCount nodes get cached pre-parameters.
+func startTestNetwork(size int, dir string) (*testNetwork, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	net := &testNetwork{mn: mocknet.New(), cancel: cancel}
+
+	for i := 0; i < size; i++ {
+		node, err := net.addNode(ctx, i, dir)
+		if err != nil {
+			net.stop()
+			return nil, err
+		}
+		net.nodes = append(net.nodes, node)
+	}
+
+	if err := net.mn.LinkAll(); err != nil {
+		net.stop()
+		return nil, fmt.Errorf("failed to link peers: %w", err)
+	}
+	if err := net.mn.ConnectAllButSelf(); err != nil {
+		net.stop()
+		return nil, fmt.Errorf("failed to connect peers: %w", err)
+	}
+
+	// Broadcasts are only delivered once the gossipsub mesh has formed
+	meshFormed := eventually(10*time.Second, func() bool {
+		for _, node := range net.nodes {
+			if len(node.msgRouter.topic.ListPeers()) < size-1 {
+				return false
+			}
+		}
+		return true
+	})
+	if !meshFormed {
+		net.stop()
+		return nil, errors.New("gossipsub mesh did not form in time")
+	}
+
+	return net, nil
+}
+
+func (net *testNetwork) addNode(ctx context.Context, i int, dir string) (*Node, error) {
+	privKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key pair: %w", err)
+	}
+	h, err := net.mn.AddPeer(privKey, ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", 4001+i)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to add mocknet peer: %w", err)
+	}
+	// Mocknet hosts don't run the ping service that libp2p.New sets up
+	ping.NewPingService(h)
+
+	cfg := DefaultNodeConfig()
+	cfg.KeyStoreDir = filepath.Join(dir, fmt.Sprintf("node-%d", i))
+	cfg.Discovery.EnableMDNS = false
+	cfg.Discovery.KnownPeersFile = ""
+	cfg.PreParamsPoolSize = 0
+
+	node, err := newNode(ctx, h, privKey, cfg, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create node: %w", err)
+	}
+	if i < testPreParamsCount {
+		preParams, err := loadTestPreParams(i)
+		if err != nil {
+			return nil, err
+		}
+		node.tssHandler.preParams.Put(preParams)
+	}
+
+	if err := node.Start(ctx); err != nil {
+		return nil, fmt.Errorf("failed to start node: %w", err)
+	}
+	return node, nil
+}
+
+func (net *testNetwork) stop() {
+	for _, node := range net.nodes {
+		node.Stop()
+	}
+	net.mn.Close()
+	net.cancel()
+}
+
+func (net *testNetwork) peerIDs() []peer.ID {
+	ids := make([]peer.ID, len(net.nodes))
+	for i, node := range net.nodes {
+		ids[i] = node.host.ID()
+	}
+	return ids
+}
+
+func loadTestPreParams(i int) (*keygen.LocalPreParams, error) {
+	data, err := os.ReadFile(filepath.Join(testPreParamsDir, fmt.Sprintf("key-share-%d.json", i)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cached pre-params: %w", err)
+	}
+
+	var share keygen.LocalPartySaveData
+	if err := json.Unmarshal(data, &share); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cached pre-params: %w", err)
+	}
+	return &share.LocalPreParams, nil
+}
+
+// eventually polls cond until it holds or the timeout passes.
+func eventually(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+
+	if !eventually(timeout, cond) {
+		t.Fatal("condition not met in time")
+	}
+}
+
+// testKey is a key generated by a test network.
+type testKey struct {
+	net    *testNetwork
+	record *KeyShareRecord
+}
+
+// The ECDSA key shared by the signing tests. Keygen takes most of the test
+// run, so it is generated once, on first use, and stopped by TestMain.
+const (
+	sharedKeySize      = 3
+	sharedKeyThreshold = 2
+)
+
+var (
+	sharedKeyOnce sync.Once
+	sharedKey     *testKey
+	sharedKeyErr  error
+	sharedKeyDir  string
+)
+
+// ecdsaTestKey returns the shared ECDSA key, generating it on first use.
+// Its first node is the initiator of the keygen.
+func ecdsaTestKey(t *testing.T) *testKey {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("skipping multi-node keygen in short mode")
+	}
+	sharedKeyOnce.Do(func() {
+		sharedKey, sharedKeyErr = generateSharedKey()
+	})
+	if sharedKeyErr != nil {
+		t.Fatalf("failed to generate the shared key: %v", sharedKeyErr)
+	}
+	return sharedKey
+}
+
+func generateSharedKey() (*testKey, error) {
+	var err error
+	if sharedKeyDir, err = os.MkdirTemp("", "tss-test-"); err != nil {
+		return nil, fmt.Errorf("failed to create key store directory: %w", err)
+	}
+	net, err := startTestNetwork(sharedKeySize, sharedKeyDir)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	record, err := net.nodes[0].GenerateKey(ctx, net.peerIDs(), sharedKeyThreshold, SchemeECDSA)
+	if err != nil {
+		net.stop()
+		return nil, fmt.Errorf("keygen failed: %w", err)
+	}
+
+	// The other members may still be saving their shares when GenerateKey returns
+	saved := eventually(5*time.Second, func() bool {
+		for _, node := range net.nodes[1:] {
+			if _, err := node.GetKeyShare(record.KeyID); err != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if !saved {
+		net.stop()
+		return nil, errors.New("not every member saved its key share in time")
+	}
+
+	return &testKey{net: net, record: record}, nil
+}
+
+func (k *testKey) initiator() *Node {
+	return k.net.nodes[0]
+}
+
+func (k *testKey) publicKey() *ecdsa.PublicKey {
+	pub := k.record.Share.ECDSAPub
+	return &ecdsa.PublicKey{Curve: tss.S256(), X: pub.X(), Y: pub.Y()}
+}
+
+func verifySignature(t *testing.T, pub *ecdsa.PublicKey, digest []byte, signature *common.SignatureData) {
+	t.Helper()
+
+	r := new(big.Int).SetBytes(signature.GetR())
+	s := new(big.Int).SetBytes(signature.GetS())
+	if !ecdsa.Verify(pub, digest, r, s) {
+		t.Fatal("signature does not verify")
+	}
+}
+
+func testContext(t *testing.T, timeout time.Duration) context.Context {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestKeygen(t *testing.T) {
+	key := ecdsaTestKey(t)
+
+	// Every member ends up with a share of the same public key
+	for _, node := range key.net.nodes {
+		share, err := node.GetKeyShare(key.record.KeyID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !share.Share.ECDSAPub.Equals(key.record.Share.ECDSAPub) {
+			t.Fatalf("node %s has a different public key", node.host.ID())
+		}
+		if len(share.ChainCode) != ChainCodeLength || !bytes.Equal(share.ChainCode, key.record.ChainCode) {
+			t.Fatalf("node %s has a different chain code", node.host.ID())
+		}
+	}
+}
+
+func TestSigning(t *testing.T) {
+	key := ecdsaTestKey(t)
+	ctx := testContext(t, time.Minute)
+
+	digest := sha256.Sum256([]byte("integration test"))
+	signature, err := key.initiator().Sign(ctx, key.record.KeyID, digest[:])
+	if err != nil {
+		t.Fatalf("signing failed: %v", err)
+	}
+	verifySignature(t, key.publicKey(), digest[:], signature)
+}
+
+func TestSigningRejectsShortDigest(t *testing.T) {
+	key := ecdsaTestKey(t)
+
+	digest := sha256.Sum256([]byte("integration test"))
+	if _, err := key.initiator().Sign(context.Background(), key.record.KeyID, digest[:16]); err == nil {
+		t.Fatal("expected an ECDSA key not to sign a 16-byte digest")
+	}
+	if _, err := key.initiator().SignBatch(context.Background(), key.record.KeyID, [][]byte{digest[:], digest[:16]}); err == nil {
+		t.Fatal("expected a batch with a 16-byte digest to be refused")
+	}
+}
+
+func TestKeygenMetrics(t *testing.T) {
+	key := ecdsaTestKey(t)
+
+	metrics := key.initiator().metrics
+	if got := testutil.ToFloat64(metrics.sessionsCompleted.WithLabelValues(TSSOperationKeyGen.String())); got != 1 {
+		t.Fatalf("expected 1 completed keygen session, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.messages.WithLabelValues(MessageTypeKeyGeneration.String(), directionIn)); got == 0 {
+		t.Fatal("no received keygen messages recorded")
+	}
+	if got := testutil.CollectAndCount(metrics.roundDuration); got == 0 {
+		t.Fatal("no round durations recorded")
+	}
+
+	// Every member used up its pre-parameters
+	for _, node := range key.net.nodes {
+		if got := node.tssHandler.PreParamsAvailable(); got != 0 {
+			t.Fatalf("node %s has %d pre-parameter sets left after keygen, want 0", node.host.ID(), got)
+		}
+	}
+}
+
+func TestSigningTrace(t *testing.T) {
+	key := ecdsaTestKey(t)
+	ctx := testContext(t, time.Minute)
+
+	recorded := len(testSpans.Ended())
+	digest := sha256.Sum256([]byte("traced"))
+	if _, err := key.initiator().Sign(ctx, key.record.KeyID, digest[:]); err != nil {
+		t.Fatalf("signing failed: %v", err)
+	}
+	spans := func() []sdktrace.ReadOnlySpan {
+		return testSpans.Ended()[recorded:]
+	}
+
+	// The signing sessions of all members join the initiator's trace. The
+	// other members may still be finishing their sessions when Sign returns.
+	var traceID trace.TraceID
+	waitFor(t, 10*time.Second, func() bool {
+		var ok bool
+		if traceID, ok = signingTraceID(spans()); !ok {
+			return false
+		}
+		var ended int
+		for _, span := range spans() {
+			if span.Name() == "tss.signing" && span.SpanContext().TraceID() == traceID {
+				ended++
+			}
+		}
+		return ended == sharedKeySize
+	})
+	var roundSpans int
+	for _, span := range spans() {
+		if span.Name() == "round 1" && span.SpanContext().TraceID() == traceID {
+			roundSpans++
+		}
+	}
+	if roundSpans == 0 {
+		t.Fatal("no round spans recorded")
+	}
+}
+
+func TestBatchSigning(t *testing.T) {
+	key := ecdsaTestKey(t)
+	ctx := testContext(t, time.Minute)
+
+	// A batch is signed by parallel sessions of one signer set
+	digests := make([][]byte, 2)
+	for i := range digests {
+		d := sha256.Sum256([]byte(fmt.Sprintf("batch %d", i)))
+		digests[i] = d[:]
+	}
+	results, err := key.initiator().SignBatch(ctx, key.record.KeyID, digests)
+	if err != nil {
+		t.Fatalf("batch signing failed: %v", err)
+	}
+	for i, result := range results {
+		if !bytes.Equal(result.Digest, digests[i]) {
+			t.Fatalf("result %d is for digest %x, want %x", i, result.Digest, digests[i])
+		}
+		verifySignature(t, key.publicKey(), digests[i], result.Signature)
+	}
+}
+
+func TestDerivedSigning(t *testing.T) {
+	key := ecdsaTestKey(t)
+	ctx := testContext(t, time.Minute)
+
+	// A child key signs with the same shares
+	path, err := ParseDerivationPath("m/0/7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, _, err := key.record.DeriveKey(path)
+	if err != nil {
+		t.Fatalf("failed to derive %s: %v", path, err)
+	}
+	digest := sha256.Sum256([]byte("derived"))
+	signature, err := key.initiator().SignDerived(ctx, key.record.KeyID, path, digest[:])
+	if err != nil {
+		t.Fatalf("signing with %s failed: %v", path, err)
+	}
+	verifySignature(t, child.PublicKey(), digest[:], signature)
+}
+
+func TestEdDSAKeygenAndSolanaSigning(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping multi-node keygen in short mode")
+	}
+
+	const (
+		size      = 3
+		threshold = 2
+	)
+
+	net := newTestNetwork(t, size)
+	initiator := net.nodes[0]
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	record, err := initiator.GenerateKey(ctx, net.peerIDs(), threshold, SchemeEdDSA)
+	if err != nil {
+		t.Fatalf("keygen failed: %v", err)
+	}
+	pub, err := record.Ed25519PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := record.ECDSAPublicKey(); err == nil {
+		t.Fatal("expected an EdDSA key not to have an ECDSA public key")
+	}
+	for _, node := range net.nodes[1:] {
+		waitFor(t, 5*time.Second, func() bool {
+			_, err := node.GetKeyShare(record.KeyID)
+			return err == nil
+		})
+	}
+
+	// A transfer from the committee to another account
+	recipient := make([]byte, 32)
+	recipient[0] = 1
+	raw := []byte{1, 0, 1, 3}
+	raw = append(raw, pub...)
+	raw = append(raw, recipient...)
+	raw = append(raw, make([]byte, 32)...)
+	raw = append(raw, bytes.Repeat([]byte{7}, 32)...)
+	raw = append(raw, 1, 2, 2, 0, 1, 12, 2, 0, 0, 0, 0x40, 0x42, 0x0f, 0, 0, 0, 0, 0)
+	msg, err := solana.DecodeMessage(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := initiator.SignSolanaMessage(ctx, record.KeyID, msg)
+	if err != nil {
+		t.Fatalf("signing failed: %v", err)
+	}
+	if signed.Address != solana.Address(pub) {
+		t.Fatalf("signed by %s, want %s", signed.Address, solana.Address(pub))
+	}
+	if !ed25519.Verify(pub, raw, signed.Signature) {
+		t.Fatal("signature does not verify as Ed25519")
+	}
+	if want := append(append([]byte{1}, signed.Signature...), raw...); !bytes.Equal(signed.Transaction, want) {
+		t.Fatalf("unexpected transaction %x", signed.Transaction)
+	}
+
+	// ECDSA-only chains refuse the key
+	if _, err := initiator.SignPersonalMessage(ctx, record.KeyID, []byte("hello")); err == nil {
+		t.Fatal("expected an EdDSA key not to sign Ethereum messages")
+	}
+}
+
+// signingTraceID returns the trace of the initiator's signing session, the
+// only signing session span without a parent.
+func signingTraceID(spans []sdktrace.ReadOnlySpan) (trace.TraceID, bool) {
+	for _, span := range spans {
+		if span.Name() == "tss.signing" && !span.Parent().IsValid() {
+			return span.SpanContext().TraceID(), true
+		}
+	}
+	return trace.TraceID{}, false
+}
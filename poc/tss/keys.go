@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/bnb-chain/tss-lib/v2/tss"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/keruch/thesis/poc/chain/bitcoin"
+	"github.com/keruch/thesis/poc/chain/cosmos"
+	"github.com/keruch/thesis/poc/chain/ethereum"
+	"github.com/keruch/thesis/poc/chain/solana"
+)
+
+var (
+	oidPublicKeyECDSA      = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidNamedCurveSecp256k1 = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+)
+
+// KeyInfo is the public part of a committee key in the encodings wallets and
+// other tools expect, together with the addresses it controls. For ECDSA keys
+// with a chain code, it describes the child key at DerivationPath and includes
+// its BIP32 extended public key.
+type KeyInfo struct {
+	KeyID             string       `json:"key_id,omitempty"`
+	Scheme            Scheme       `json:"scheme"`
+	Threshold         int          `json:"threshold,omitempty"`
+	DerivationPath    string       `json:"derivation_path,omitempty"`
+	ExtendedPublicKey string       `json:"extended_public_key,omitempty"`
+	PublicKey         PublicKey    `json:"public_key"`
+	Addresses         KeyAddresses `json:"addresses"`
+}
+
+// PublicKey holds the encodings of a public key. Compressed is the 33-byte
+// SEC 1 point of an ECDSA key or the 32-byte point of an EdDSA key; EdDSA keys
+// have no uncompressed form.
+type PublicKey struct {
+	Compressed   string `json:"compressed"`
+	Uncompressed string `json:"uncompressed,omitempty"`
+	PEM          string `json:"pem"`
+	JWK          JWK    `json:"jwk"`
+}
+
+// JWK is a public JSON Web Key (RFC 7517, curves of RFC 8037 and RFC 8812).
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y,omitempty"`
+}
+
+// KeyAddresses are the addresses of a key on the supported chains. ECDSA keys
+// have Ethereum, Bitcoin and Cosmos addresses, EdDSA keys a Solana address.
+type KeyAddresses struct {
+	Ethereum      string `json:"ethereum,omitempty"`
+	BitcoinP2PKH  string `json:"bitcoin_p2pkh,omitempty"`
+	BitcoinP2WPKH string `json:"bitcoin_p2wpkh,omitempty"`
+	Cosmos        string `json:"cosmos,omitempty"`
+	Solana        string `json:"solana,omitempty"`
+}
+
+// describeKey returns the public key and the addresses of record, or of its
+// child key at path if path is not empty. Bitcoin addresses are encoded for
+// the network params, Cosmos addresses with the human-readable part hrp.
+func describeKey(record *KeyShareRecord, path DerivationPath, params *chaincfg.Params, hrp string) (*KeyInfo, error) {
+	info := &KeyInfo{
+		KeyID:     record.KeyID,
+		Scheme:    record.KeyScheme(),
+		Threshold: record.Threshold,
+	}
+
+	if info.Scheme == SchemeEdDSA {
+		if len(path) > 0 {
+			return nil, errors.New("EdDSA keys do not support BIP32 derivation")
+		}
+		pub, err := record.Ed25519PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		spki, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode public key: %w", err)
+		}
+		info.PublicKey = PublicKey{
+			Compressed: hex.EncodeToString(pub),
+			PEM:        encodePublicKeyPEM(spki),
+			JWK:        JWK{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(pub)},
+		}
+		info.Addresses.Solana = solana.Address(pub)
+		return info, nil
+	}
+
+	pub, err := record.ECDSAPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(path) > 0 || len(record.ChainCode) > 0 {
+		child, _, err := record.DeriveKey(path)
+		if err != nil {
+			return nil, err
+		}
+		pub = child.PublicKey()
+		info.DerivationPath = path.String()
+		info.ExtendedPublicKey = child.String()
+	}
+	spki, err := marshalSecp256k1PublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	uncompressed := elliptic.Marshal(tss.S256(), pub.X, pub.Y)
+	info.PublicKey = PublicKey{
+		Compressed:   hex.EncodeToString(cosmos.CompressedPubKey(pub)),
+		Uncompressed: hex.EncodeToString(uncompressed),
+		PEM:          encodePublicKeyPEM(spki),
+		JWK: JWK{
+			Kty: "EC",
+			Crv: "secp256k1",
+			X:   base64.RawURLEncoding.EncodeToString(uncompressed[1:33]),
+			Y:   base64.RawURLEncoding.EncodeToString(uncompressed[33:]),
+		},
+	}
+
+	key, err := bitcoin.NewKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	info.Addresses.BitcoinP2WPKH, info.Addresses.BitcoinP2PKH, err = key.Address(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode bitcoin address: %w", err)
+	}
+	if info.Addresses.Cosmos, err = cosmos.Address(pub, hrp); err != nil {
+		return nil, err
+	}
+	info.Addresses.Ethereum = ethereum.Address(pub).Hex()
+	return info, nil
+}
+
+// marshalSecp256k1PublicKey encodes pub as a SubjectPublicKeyInfo, which
+// crypto/x509 only does for the NIST curves.
+func marshalSecp256k1PublicKey(pub *ecdsa.PublicKey) ([]byte, error) {
+	params, err := asn1.Marshal(oidNamedCurveSecp256k1)
+	if err != nil {
+		return nil, err
+	}
+	point := elliptic.Marshal(tss.S256(), pub.X, pub.Y)
+	return asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPublicKeyECDSA,
+			Parameters: asn1.RawValue{FullBytes: params},
+		},
+		PublicKey: asn1.BitString{Bytes: point, BitLength: 8 * len(point)},
+	})
+}
+
+func encodePublicKeyPEM(spki []byte) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: spki}))
+}
+
+// bitcoinNetworkParams returns the chain parameters of a Bitcoin network.
+func bitcoinNetworkParams(network string) (*chaincfg.Params, error) {
+	switch network {
+	case "mainnet":
+		return &chaincfg.MainNetParams, nil
+	case "testnet", "testnet3":
+		return &chaincfg.TestNet3Params, nil
+	case "signet":
+		return &chaincfg.SigNetParams, nil
+	case "regtest":
+		return &chaincfg.RegressionNetParams, nil
+	default:
+		return nil, fmt.Errorf("unknown bitcoin network: %q", network)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+
+	tsscrypto "github.com/bnb-chain/tss-lib/v2/crypto"
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+	eddsakeygen "github.com/bnb-chain/tss-lib/v2/eddsa/keygen"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/base58"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/decred/dcrd/dcrec/edwards/v2"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestDescribeECDSAKey(t *testing.T) {
+	priv, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := priv.PubKey().ToECDSA()
+	point, err := tsscrypto.NewECPoint(tss.S256(), pub.X, pub.Y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := &KeyShareRecord{KeyID: "key", Threshold: 1, Share: &keygen.LocalPartySaveData{ECDSAPub: point}}
+
+	info, err := describeKey(record, nil, &chaincfg.MainNetParams, "cosmos")
+	if err != nil {
+		t.Fatalf("failed to describe key: %v", err)
+	}
+
+	block, _ := pem.Decode([]byte(info.PublicKey.PEM))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		t.Fatalf("invalid PEM: %q", info.PublicKey.PEM)
+	}
+	var spki struct {
+		Algorithm struct {
+			Algorithm asn1.ObjectIdentifier
+			Curve     asn1.ObjectIdentifier
+		}
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(block.Bytes, &spki); err != nil {
+		t.Fatalf("invalid SubjectPublicKeyInfo: %v", err)
+	}
+	if !spki.Algorithm.Algorithm.Equal(oidPublicKeyECDSA) || !spki.Algorithm.Curve.Equal(oidNamedCurveSecp256k1) {
+		t.Fatalf("unexpected algorithm %v with curve %v", spki.Algorithm.Algorithm, spki.Algorithm.Curve)
+	}
+	if !bytes.Equal(spki.PublicKey.Bytes, priv.PubKey().SerializeUncompressed()) {
+		t.Fatal("SubjectPublicKeyInfo does not hold the uncompressed key")
+	}
+
+	for name, coord := range map[string]struct {
+		encoded string
+		want    *big.Int
+	}{
+		"x": {info.PublicKey.JWK.X, pub.X},
+		"y": {info.PublicKey.JWK.Y, pub.Y},
+	} {
+		raw, err := base64.RawURLEncoding.DecodeString(coord.encoded)
+		if err != nil || len(raw) != 32 || new(big.Int).SetBytes(raw).Cmp(coord.want) != 0 {
+			t.Fatalf("JWK %s is %q, want %x", name, coord.encoded, coord.want)
+		}
+	}
+
+	if want := ethcrypto.PubkeyToAddress(*pub).Hex(); info.Addresses.Ethereum != want {
+		t.Fatalf("ethereum address %s, want %s", info.Addresses.Ethereum, want)
+	}
+	if info.Addresses.BitcoinP2WPKH[:4] != "bc1q" || info.Addresses.BitcoinP2PKH[0] != '1' || info.Addresses.Cosmos[:7] != "cosmos1" {
+		t.Fatalf("unexpected addresses: %+v", info.Addresses)
+	}
+	if info.Addresses.Solana != "" {
+		t.Fatal("ECDSA key has a Solana address")
+	}
+}
+
+func TestDescribeEdDSAKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := edwards.ParsePubKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	point, err := tsscrypto.NewECPoint(tss.Edwards(), parsed.X, parsed.Y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := &KeyShareRecord{KeyID: "key", Scheme: SchemeEdDSA, EdDSAShare: &eddsakeygen.LocalPartySaveData{EDDSAPub: point}}
+
+	info, err := describeKey(record, nil, &chaincfg.MainNetParams, "cosmos")
+	if err != nil {
+		t.Fatalf("failed to describe key: %v", err)
+	}
+
+	block, _ := pem.Decode([]byte(info.PublicKey.PEM))
+	if block == nil {
+		t.Fatalf("invalid PEM: %q", info.PublicKey.PEM)
+	}
+	parsedPEM, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("invalid SubjectPublicKeyInfo: %v", err)
+	}
+	if !pub.Equal(parsedPEM) {
+		t.Fatal("SubjectPublicKeyInfo holds another key")
+	}
+	if x, err := base64.RawURLEncoding.DecodeString(info.PublicKey.JWK.X); err != nil || !bytes.Equal(x, pub) {
+		t.Fatalf("JWK x is %q", info.PublicKey.JWK.X)
+	}
+	if info.PublicKey.Uncompressed != "" || info.PublicKey.JWK.Y != "" {
+		t.Fatal("EdDSA key has an uncompressed form")
+	}
+	if info.Addresses != (KeyAddresses{Solana: base58.Encode(pub)}) {
+		t.Fatalf("unexpected addresses: %+v", info.Addresses)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+	eddsakeygen "github.com/bnb-chain/tss-lib/v2/eddsa/keygen"
+	"github.com/decred/dcrd/dcrec/edwards/v2"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	DefaultKeyStoreDir = "data/keys"
+	keyShareFileSuffix = ".json"
+
+	// DigestLength is the length of the digests ECDSA keys sign.
+	DigestLength = 32
+)
+
+// Scheme is the signature scheme of a committee key.
+type Scheme string
+
+const (
+	// SchemeECDSA is ECDSA over secp256k1, the scheme of keys without one
+	SchemeECDSA Scheme = "ecdsa"
+	// SchemeEdDSA is Ed25519
+	SchemeEdDSA Scheme = "eddsa"
+)
+
+// ParseScheme parses the name of a scheme; no name means ECDSA.
+func ParseScheme(s string) (Scheme, error) {
+	switch scheme := Scheme(s); scheme {
+	case "", SchemeECDSA:
+		return SchemeECDSA, nil
+	case SchemeEdDSA:
+		return scheme, nil
+	default:
+		return "", fmt.Errorf("unknown signature scheme: %q", s)
+	}
+}
+
+// KeyShareRecord is this node's share of a committee key together with the
+// metadata needed to run signing sessions with the other holders. Share is
+// set for ECDSA keys, EdDSAShare for EdDSA keys. ChainCode is the BIP32 chain
+// code of ECDSA keys, the same on all holders.
+type KeyShareRecord struct {
+	KeyID      string                          `json:"key_id"`
+	Scheme     Scheme                          `json:"scheme,omitempty"`
+	Threshold  int                             `json:"threshold"`
+	Holders    []peer.ID                       `json:"holders"`
+	ChainCode  []byte                          `json:"chain_code,omitempty"`
+	Share      *keygen.LocalPartySaveData      `json:"share,omitempty"`
+	EdDSAShare *eddsakeygen.LocalPartySaveData `json:"eddsa_share,omitempty"`
+}
+
+// KeyScheme returns the signature scheme of the key.
+func (r *KeyShareRecord) KeyScheme() Scheme {
+	if r.Scheme == "" {
+		return SchemeECDSA
+	}
+	return r.Scheme
+}
+
+// ECDSAPublicKey returns the public key of an ECDSA key.
+func (r *KeyShareRecord) ECDSAPublicKey() (*ecdsa.PublicKey, error) {
+	if r.KeyScheme() != SchemeECDSA || r.Share == nil {
+		return nil, fmt.Errorf("key %s is not an ECDSA key", r.KeyID)
+	}
+	return r.Share.ECDSAPub.ToECDSAPubKey(), nil
+}
+
+// Ed25519PublicKey returns the public key of an EdDSA key.
+func (r *KeyShareRecord) Ed25519PublicKey() (ed25519.PublicKey, error) {
+	if r.KeyScheme() != SchemeEdDSA || r.EdDSAShare == nil {
+		return nil, fmt.Errorf("key %s is not an EdDSA key", r.KeyID)
+	}
+	pub := r.EdDSAShare.EDDSAPub
+	return edwards.NewPublicKey(pub.X(), pub.Y()).Serialize(), nil
+}
+
+// CheckMessage returns an error if msg cannot be signed with the key. ECDSA
+// keys sign DigestLength-byte digests, EdDSA keys whole messages.
+func (r *KeyShareRecord) CheckMessage(msg []byte) error {
+	if len(msg) == 0 {
+		return fmt.Errorf("empty message for key %s", r.KeyID)
+	}
+	if r.KeyScheme() == SchemeECDSA && len(msg) != DigestLength {
+		return fmt.Errorf("ECDSA key %s signs %d-byte digests, got %d bytes", r.KeyID, DigestLength, len(msg))
+	}
+	return nil
+}
+
+func (r *KeyShareRecord) IsHolder(peerID peer.ID) bool {
+	for _, holder := range r.Holders {
+		if holder == peerID {
+			return true
+		}
+	}
+	return false
+}
+
+type KeyStore struct {
+	dir     string
+	metrics *Metrics
+	mu      sync.RWMutex
+}
+
+func NewKeyStore(dir string, metrics *Metrics) *KeyStore {
+	return &KeyStore{
+		dir:     dir,
+		metrics: metrics,
+	}
+}
+
+func (ks *KeyStore) path(keyID string) (string, error) {
+	if keyID == "" || strings.ContainsAny(keyID, `/\`) || keyID == "." || keyID == ".." {
+		return "", fmt.Errorf("invalid key ID: %q", keyID)
+	}
+	return filepath.Join(ks.dir, keyID+keyShareFileSuffix), nil
+}
+
+func (ks *KeyStore) Save(record *KeyShareRecord) (err error) {
+	defer func() { ks.metrics.KeyStoreOp("save", err) }()
+
+	path, err := ks.path(record.KeyID)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if err := os.MkdirAll(ks.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create key store directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal key share: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated share behind
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write key share: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to store key share: %w", err)
+	}
+
+	return nil
+}
+
+func (ks *KeyStore) Load(keyID string) (_ *KeyShareRecord, err error) {
+	defer func() { ks.metrics.KeyStoreOp("load", err) }()
+
+	path, err := ks.path(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key share %s: %w", keyID, err)
+	}
+
+	var record KeyShareRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal key share %s: %w", keyID, err)
+	}
+
+	return &record, nil
+}
+
+func (ks *KeyStore) List() (_ []string, err error) {
+	defer func() { ks.metrics.KeyStoreOp("list", err) }()
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	entries, err := os.ReadDir(ks.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key store directory: %w", err)
+	}
+
+	keyIDs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, keyShareFileSuffix) {
+			continue
+		}
+		keyIDs = append(keyIDs, strings.TrimSuffix(name, keyShareFileSuffix))
+	}
+	sort.Strings(keyIDs)
+
+	return keyIDs, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"strings"
+
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+	eddsakeygen "github.com/bnb-chain/tss-lib/v2/eddsa/keygen"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Log attribute keys shared by all components.
+const (
+	LogKeyNode      = "node"
+	LogKeyPeer      = "peer"
+	LogKeyPartyID   = "party_id"
+	LogKeySessionID = "session_id"
+	LogKeyRound     = "round"
+	LogKeyMsgType   = "msg_type"
+	LogKeyError     = "err"
+
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+
+	redacted = "[REDACTED]"
+)
+
+type LogConfig struct {
+	// Level is one of "debug", "info", "warn" or "error"
+	Level string
+	// Format is "text" or "json"
+	Format string
+}
+
+func DefaultLogConfig() LogConfig {
+	return LogConfig{
+		Level:  "info",
+		Format: LogFormatText,
+	}
+}
+
+// setupLogging installs the default logger used by all components.
+func setupLogging(w io.Writer, cfg LogConfig) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return fmt.Errorf("invalid log level %q: %w", cfg.Level, err)
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case LogFormatText:
+		handler = slog.NewTextHandler(w, opts)
+	case LogFormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format: %s", cfg.Format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// nodeLogger returns the logger of a component of the node self.
+func nodeLogger(self peer.ID) *slog.Logger {
+	return slog.Default().With(LogKeyNode, self.String())
+}
+
+// Attribute keys whose values are never logged, whatever their type.
+var redactedKeys = map[string]bool{
+	"payload":     true,
+	"wire_bytes":  true,
+	"digests":     true,
+	"message":     true,
+	"plaintext":   true,
+	"share":       true,
+	"key_share":   true,
+	"private_key": true,
+	"pre_params":  true,
+	"secret":      true,
+}
+
+// redactAttr keeps key material and message contents out of the logs. Values
+// are redacted by key, and by type for raw bytes and secret types, so a
+// careless log call can't leak them under another name.
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if redactedKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+	if a.Value.Kind() != slog.KindAny {
+		return a
+	}
+
+	switch v := a.Value.Any().(type) {
+	case []byte:
+		return slog.String(a.Key, fmt.Sprintf("[%d bytes redacted]", len(v)))
+	case json.RawMessage:
+		return slog.String(a.Key, fmt.Sprintf("[%d bytes redacted]", len(v)))
+	case *big.Int,
+		crypto.PrivKey,
+		keygen.LocalPartySaveData, *keygen.LocalPartySaveData,
+		keygen.LocalPreParams, *keygen.LocalPreParams,
+		eddsakeygen.LocalPartySaveData, *eddsakeygen.LocalPartySaveData:
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
+// LogValue describes the message without its payload.
+func (m *Message) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String(LogKeyMsgType, m.Type.String()),
+		slog.String(LogKeyPartyID, m.PartyID),
+		slog.String("from", m.From.String()),
+		slog.Int("size", len(m.Payload)),
+	}
+	if m.To != "" {
+		attrs = append(attrs, slog.String("to", m.To.String()))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// LogValue describes the key share record without the share itself.
+func (r *KeyShareRecord) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("key_id", r.KeyID),
+		slog.Int("threshold", r.Threshold),
+		slog.Int("holders", len(r.Holders)),
+	)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+	eddsakeygen "github.com/bnb-chain/tss-lib/v2/eddsa/keygen"
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+func TestLogRedaction(t *testing.T) {
+	privKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	if err != nil {
+		t.Fatalf("failed to generate key pair: %v", err)
+	}
+
+	const secret = "c0ffee-secret-material"
+	share := &keygen.LocalPartySaveData{}
+	share.Xi = new(big.Int).SetBytes([]byte(secret))
+	eddsaShare := &eddsakeygen.LocalPartySaveData{}
+	eddsaShare.Xi = new(big.Int).SetBytes([]byte(secret))
+
+	for _, format := range []string{LogFormatText, LogFormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			var out bytes.Buffer
+			prev := slog.Default()
+			defer slog.SetDefault(prev)
+			if err := setupLogging(&out, LogConfig{Level: "debug", Format: format}); err != nil {
+				t.Fatalf("failed to set up logging: %v", err)
+			}
+
+			msg := &Message{
+				Type:    MessageTypeSigning,
+				PartyID: "party",
+				Payload: json.RawMessage(`"` + secret + `"`),
+			}
+			slog.Info("test",
+				"envelope", msg,
+				"payload", secret,
+				"wire_bytes", []byte(secret),
+				"under_another_name", []byte(secret),
+				"share", share,
+				"eddsa_share", eddsaShare,
+				"eddsa_share_value", *eddsaShare,
+				"xi", share.Xi,
+				"key", privKey,
+				slog.Group("nested", "secret", secret),
+			)
+
+			logged := out.String()
+			// Shares would be printed with their big integers in decimal
+			if strings.Contains(logged, secret) || strings.Contains(logged, share.Xi.String()) {
+				t.Fatalf("secret leaked into the log: %s", logged)
+			}
+			if !strings.Contains(logged, "party") {
+				t.Fatalf("message summary missing from the log: %s", logged)
+			}
+		})
+	}
+}
+
+func TestSetupLoggingRejectsInvalidConfig(t *testing.T) {
+	prev := slog.Default()
+	defer slog.SetDefault(prev)
+
+	if err := setupLogging(&bytes.Buffer{}, LogConfig{Level: "loud", Format: LogFormatText}); err == nil {
+		t.Fatal("expected an error for an invalid level")
+	}
+	if err := setupLogging(&bytes.Buffer{}, LogConfig{Level: "info", Format: "xml"}); err == nil {
+		t.Fatal("expected an error for an invalid format")
+	}
+}
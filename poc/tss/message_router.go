@@ -3,16 +3,37 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"sync"
+	"time"
 
+	"github.com/keruch/thesis/poc/session"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 )
 
 const (
 	TSSTopicName = "tss-messages"
+
+	// DirectProtocolID is used for messages addressed to a single peer. Streams
+	// are encrypted and authenticated by the libp2p security transport, so
+	// private protocol messages never reach other subscribers of the topic.
+	DirectProtocolID = protocol.ID("/tss/direct/1.0.0")
+
+	// Keygen messages carry Paillier proofs and easily exceed the pubsub default of 1 MiB
+	MaxMessageSize = 8 << 20
+
+	DirectMessageTimeout = 30 * time.Second
+
+	// MessageWorkers bounds the number of messages handled at a time. Messages
+	// of one party are handled in order, those of different parties in parallel.
+	MessageWorkers = 16
 )
 
 type MessageType int
@@ -21,41 +42,101 @@ const (
 	MessageTypePartyFormation MessageType = iota
 	MessageTypeKeyGeneration
 	MessageTypeSigning
+	MessageTypePartyBatch
 )
 
+func (t MessageType) String() string {
+	switch t {
+	case MessageTypePartyFormation:
+		return "party_formation"
+	case MessageTypeKeyGeneration:
+		return "keygen"
+	case MessageTypeSigning:
+		return "signing"
+	case MessageTypePartyBatch:
+		return "party_batch"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(t))
+	}
+}
+
 type Message struct {
 	Type    MessageType     `json:"type"`
 	PartyID string          `json:"party_id"`
 	From    peer.ID         `json:"from"`
-	To      peer.ID         `json:"to"`
+	To      peer.ID         `json:"to,omitempty"`
 	Payload json.RawMessage `json:"payload"`
+	// Trace carries the sender's trace context so that the spans of all members join one trace
+	Trace map[string]string `json:"trace,omitempty"`
 }
 
+// MessageHandler handles a message. Errors wrapping an InvalidMessageError
+// count against the sender's reputation, other errors are only logged.
 type MessageHandler func(msg *Message) error
 
+// InvalidMessageError reports a message that violates the protocol, as
+// opposed to one this node cannot handle because of its own state, such as a
+// party it has not joined or a key it does not hold.
+type InvalidMessageError struct {
+	Err error
+}
+
+func (e *InvalidMessageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *InvalidMessageError) Unwrap() error {
+	return e.Err
+}
+
+// invalidMessage returns an InvalidMessageError with a formatted message.
+func invalidMessage(format string, args ...any) error {
+	return &InvalidMessageError{Err: fmt.Errorf(format, args...)}
+}
+
 type MessageRouter struct {
 	host         host.Host
 	pubsub       *pubsub.PubSub
 	topic        *pubsub.Topic
 	subscription *pubsub.Subscription
 	handlers     map[MessageType]MessageHandler
+	reputation   *ReputationStore
+	registry     *CommitteeRegistry
+	metrics      *Metrics
+	logger       *slog.Logger
+	mux          *session.Mux
 	mu           sync.RWMutex
 }
 
-func NewMessageRouter(h host.Host) *MessageRouter {
+func NewMessageRouter(h host.Host, reputation *ReputationStore, registry *CommitteeRegistry, metrics *Metrics) *MessageRouter {
 	return &MessageRouter{
-		host:     h,
-		handlers: make(map[MessageType]MessageHandler),
+		host:       h,
+		handlers:   make(map[MessageType]MessageHandler),
+		reputation: reputation,
+		registry:   registry,
+		metrics:    metrics,
+		logger:     nodeLogger(h.ID()),
+		mux:        session.NewMux(MessageWorkers),
 	}
 }
 
 func (mr *MessageRouter) Start(ctx context.Context) error {
-	ps, err := pubsub.NewGossipSub(ctx, mr.host)
+	ps, err := pubsub.NewGossipSub(ctx, mr.host,
+		pubsub.WithPeerScore(mr.reputation.PeerScoreParams()),
+		pubsub.WithMaxMessageSize(MaxMessageSize),
+	)
 	if err != nil {
 		return fmt.Errorf("failed to create pubsub: %w", err)
 	}
 	mr.pubsub = ps
 
+	// Messages authored by peers outside the committee are rejected before
+	// they are forwarded, even if an authorized peer relays them
+	err = mr.pubsub.RegisterTopicValidator(TSSTopicName, mr.validate)
+	if err != nil {
+		return fmt.Errorf("failed to register topic validator: %w", err)
+	}
+
 	topic, err := mr.pubsub.Join(TSSTopicName)
 	if err != nil {
 		return fmt.Errorf("failed to join topic: %w", err)
@@ -68,12 +149,24 @@ func (mr *MessageRouter) Start(ctx context.Context) error {
 	}
 	mr.subscription = subscription
 
+	mr.host.SetStreamHandler(DirectProtocolID, mr.handleStream)
+
 	go mr.handleMessages(ctx)
 
 	return nil
 }
 
+// validate is the topic validator of the TSS topic.
+func (mr *MessageRouter) validate(_ context.Context, _ peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+	if !mr.registry.Allows(msg.GetFrom()) {
+		mr.metrics.ValidationReject("unregistered")
+		return pubsub.ValidationReject
+	}
+	return pubsub.ValidationAccept
+}
+
 func (mr *MessageRouter) Stop() error {
+	mr.host.RemoveStreamHandler(DirectProtocolID)
 	if mr.subscription != nil {
 		mr.subscription.Cancel()
 	}
@@ -89,20 +182,75 @@ func (mr *MessageRouter) RegisterHandler(msgType MessageType, handler MessageHan
 	mr.handlers[msgType] = handler
 }
 
+// SendMessage broadcasts the message to every subscriber of the TSS topic.
 func (mr *MessageRouter) SendMessage(ctx context.Context, msg *Message) error {
-	data, err := json.Marshal(msg)
+	data, err := mr.marshal(ctx, msg)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
-	return mr.topic.Publish(ctx, data)
+	if err := mr.topic.Publish(ctx, data); err != nil {
+		return err
+	}
+	mr.metrics.Message(msg.Type, directionOut, len(data))
+	return nil
+}
+
+// SendDirect delivers the message to msg.To over a dedicated stream and
+// returns once the recipient has processed it, so consecutive direct messages
+// to the same peer are handled in order.
+func (mr *MessageRouter) SendDirect(ctx context.Context, msg *Message) error {
+	data, err := mr.marshal(ctx, msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, DirectMessageTimeout)
+	defer cancel()
+
+	s, err := mr.host.NewStream(ctx, msg.To, DirectProtocolID)
+	if err != nil {
+		return fmt.Errorf("failed to open stream to %s: %w", msg.To, err)
+	}
+	defer s.Close()
+
+	deadline, _ := ctx.Deadline()
+	_ = s.SetDeadline(deadline)
+
+	if _, err := s.Write(data); err != nil {
+		s.Reset()
+		return fmt.Errorf("failed to write message to %s: %w", msg.To, err)
+	}
+	if err := s.CloseWrite(); err != nil {
+		s.Reset()
+		return fmt.Errorf("failed to close stream to %s: %w", msg.To, err)
+	}
+
+	ack := make([]byte, 1)
+	if _, err := io.ReadFull(s, ack); err != nil {
+		s.Reset()
+		return fmt.Errorf("no acknowledgement from %s: %w", msg.To, err)
+	}
+
+	mr.metrics.Message(msg.Type, directionOut, len(data))
+	return nil
+}
+
+// marshal encodes the message together with the trace context of ctx.
+func (mr *MessageRouter) marshal(ctx context.Context, msg *Message) ([]byte, error) {
+	traced := *msg
+	injectTrace(ctx, &traced)
+	return json.Marshal(&traced)
 }
 
 func (mr *MessageRouter) handleMessages(ctx context.Context) {
 	for {
 		msg, err := mr.subscription.Next(ctx)
 		if err != nil {
-			fmt.Printf("Error receiving message: %v\n", err)
+			if ctx.Err() != nil || errors.Is(err, pubsub.ErrSubscriptionCancelled) {
+				return
+			}
+			mr.logger.Warn("Error receiving message", LogKeyError, err)
 			continue
 		}
 
@@ -111,23 +259,98 @@ func (mr *MessageRouter) handleMessages(ctx context.Context) {
 			continue
 		}
 
-		var message Message
-		if err := json.Unmarshal(msg.Data, &message); err != nil {
-			fmt.Printf("Error unmarshaling message: %v\n", err)
-			continue
-		}
+		mr.dispatch(msg.Data, msg.GetFrom())
+	}
+}
 
-		mr.mu.RLock()
-		handler, exists := mr.handlers[message.Type]
-		mr.mu.RUnlock()
+func (mr *MessageRouter) handleStream(s network.Stream) {
+	defer s.Close()
 
-		if !exists {
-			fmt.Printf("No handler registered for message type: %d\n", message.Type)
-			continue
-		}
+	data, err := io.ReadAll(io.LimitReader(s, MaxMessageSize))
+	if err != nil {
+		mr.logger.Warn("Error reading direct message", LogKeyPeer, s.Conn().RemotePeer(), LogKeyError, err)
+		s.Reset()
+		return
+	}
+
+	// Acknowledge once the message was handled, so that a sender's direct
+	// messages are handled in order and a busy party slows down its senders
+	select {
+	case <-mr.dispatch(data, s.Conn().RemotePeer()):
+		_, _ = s.Write([]byte{0})
+	case <-time.After(DirectMessageTimeout):
+		s.Reset()
+	}
+}
+
+// dispatch queues a message authored by sender for the registered handler,
+// behind the earlier messages of its party. The returned channel is closed
+// once the message has been handled or dropped.
+func (mr *MessageRouter) dispatch(data []byte, sender peer.ID) <-chan struct{} {
+	done := make(chan struct{})
+
+	message, ok := mr.decode(data, sender)
+	if !ok {
+		close(done)
+		return done
+	}
+
+	err := mr.mux.Submit(message.PartyID, func() {
+		defer close(done)
+		mr.handle(message)
+	})
+	if err != nil {
+		mr.logger.Warn("Dropping message", LogKeyPeer, sender, LogKeyPartyID, message.PartyID, LogKeyError, err)
+		close(done)
+	}
+	return done
+}
+
+// decode decodes a message and checks that sender may send it.
+func (mr *MessageRouter) decode(data []byte, sender peer.ID) (*Message, bool) {
+	if !mr.registry.Allows(sender) {
+		mr.logger.Warn("Dropping message from unregistered peer", LogKeyPeer, sender)
+		return nil, false
+	}
+
+	var message Message
+	if err := json.Unmarshal(data, &message); err != nil {
+		mr.logger.Warn("Error unmarshaling message", LogKeyPeer, sender, LogKeyError, err)
+		mr.reputation.RecordInvalidMessage(sender)
+		return nil, false
+	}
+	mr.metrics.Message(message.Type, directionIn, len(data))
+	mr.logger.Debug("Received message", "envelope", &message)
+
+	// The sender is authenticated by pubsub message signing or by the
+	// stream's secure channel; a mismatching From field is a spoofing attempt.
+	if message.From != sender {
+		mr.logger.Warn("Dropping message with spoofed sender", LogKeyPeer, sender, "claimed", message.From)
+		mr.reputation.RecordInvalidMessage(sender)
+		return nil, false
+	}
+	mr.reputation.RecordSeen(sender)
+
+	return &message, true
+}
+
+func (mr *MessageRouter) handle(message *Message) {
+	sender := message.From
+
+	mr.mu.RLock()
+	handler, exists := mr.handlers[message.Type]
+	mr.mu.RUnlock()
+
+	if !exists {
+		mr.logger.Warn("No handler registered for message type", LogKeyPeer, sender, LogKeyMsgType, message.Type.String())
+		return
+	}
 
-		if err := handler(&message); err != nil {
-			fmt.Printf("Error handling message: %v\n", err)
+	if err := handler(message); err != nil {
+		mr.logger.Warn("Error handling message", LogKeyPeer, sender, LogKeyMsgType, message.Type.String(), LogKeyPartyID, message.PartyID, LogKeyError, err)
+		var invalidErr *InvalidMessageError
+		if errors.As(err, &invalidErr) {
+			mr.reputation.RecordInvalidMessage(sender)
 		}
 	}
 }
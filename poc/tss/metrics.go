@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	metricsNamespace = "tssd"
+
+	MetricsPath = "/metrics"
+
+	directionIn  = "in"
+	directionOut = "out"
+)
+
+// Metrics holds the Prometheus collectors of a node. Every node has its own
+// registry so that several nodes can run in one process.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	sessionsStarted   *prometheus.CounterVec
+	sessionsCompleted *prometheus.CounterVec
+	sessionsFailed    *prometheus.CounterVec
+	roundDuration     *prometheus.HistogramVec
+	messages          *prometheus.CounterVec
+	messageBytes      *prometheus.CounterVec
+	validationRejects *prometheus.CounterVec
+	keyStoreOps       *prometheus.CounterVec
+
+	server *http.Server
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		sessionsStarted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "sessions_started_total",
+			Help:      "Keygen and signing sessions started by this node.",
+		}, []string{"operation"}),
+		sessionsCompleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "sessions_completed_total",
+			Help:      "Keygen and signing sessions completed successfully.",
+		}, []string{"operation"}),
+		sessionsFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "sessions_failed_total",
+			Help:      "Keygen and signing sessions that failed or timed out.",
+		}, []string{"operation"}),
+		roundDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "session_round_duration_seconds",
+			Help:      "Time this node spent in each protocol round of completed sessions.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16),
+		}, []string{"operation", "round"}),
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "messages_total",
+			Help:      "Messages sent and received by message type and direction.",
+		}, []string{"type", "direction"}),
+		messageBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "message_bytes_total",
+			Help:      "Bytes of messages sent and received by message type and direction.",
+		}, []string{"type", "direction"}),
+		validationRejects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "pubsub_validation_rejects_total",
+			Help:      "Pubsub messages rejected by the topic validator.",
+		}, []string{"reason"}),
+		keyStoreOps: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "keystore_operations_total",
+			Help:      "Key store operations by operation and result.",
+		}, []string{"operation", "result"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.sessionsStarted,
+		m.sessionsCompleted,
+		m.sessionsFailed,
+		m.roundDuration,
+		m.messages,
+		m.messageBytes,
+		m.validationRejects,
+		m.keyStoreOps,
+	)
+	return m
+}
+
+// registerNode adds the gauges read from the node's state on every scrape.
+func (m *Metrics) registerNode(h host.Host, th *TSSHandler) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "connected_peers",
+			Help:      "Peers this node is connected to.",
+		}, func() float64 {
+			return float64(len(h.Network().Peers()))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "preparams_pool_depth",
+			Help:      "Pre-parameters ready for the next keygen; without them keygen generates new ones first.",
+		}, func() float64 {
+			return float64(th.PreParamsAvailable())
+		}),
+	)
+}
+
+// Serve exposes the metrics over HTTP on addr until Shutdown is called.
+func (m *Metrics) Serve(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+
+	m.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := m.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Error serving metrics", LogKeyError, err)
+		}
+	}()
+	return nil
+}
+
+func (m *Metrics) Shutdown(ctx context.Context) error {
+	if m.server == nil {
+		return nil
+	}
+	return m.server.Shutdown(ctx)
+}
+
+func (m *Metrics) SessionStarted(op TSSOperation) {
+	m.sessionsStarted.WithLabelValues(op.String()).Inc()
+}
+
+// SessionFinished records the outcome of a session and, for completed
+// sessions, the time spent in each round.
+func (m *Metrics) SessionFinished(op TSSOperation, rounds []time.Duration, err error) {
+	if err != nil {
+		m.sessionsFailed.WithLabelValues(op.String()).Inc()
+		return
+	}
+
+	m.sessionsCompleted.WithLabelValues(op.String()).Inc()
+	for i, d := range rounds {
+		m.roundDuration.WithLabelValues(op.String(), strconv.Itoa(i+1)).Observe(d.Seconds())
+	}
+}
+
+func (m *Metrics) Message(msgType MessageType, direction string, size int) {
+	m.messages.WithLabelValues(msgType.String(), direction).Inc()
+	m.messageBytes.WithLabelValues(msgType.String(), direction).Add(float64(size))
+}
+
+func (m *Metrics) ValidationReject(reason string) {
+	m.validationRejects.WithLabelValues(reason).Inc()
+}
+
+func (m *Metrics) KeyStoreOp(operation string, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.keyStoreOps.WithLabelValues(operation, result).Inc()
+}
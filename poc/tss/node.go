@@ -5,7 +5,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"time"
 
+	"github.com/keruch/thesis/poc/session"
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
@@ -13,46 +16,122 @@ import (
 )
 
 type Node struct {
-	host       host.Host
-	discovery  *NodeDiscovery
-	partyMgr   *PartyManager
-	msgRouter  *MessageRouter
-	secLayer   *SecurityLayer
-	tssHandler *TSSHandler
+	ctx         context.Context
+	host        host.Host
+	discovery   *NodeDiscovery
+	partyMgr    *PartyManager
+	msgRouter   *MessageRouter
+	secLayer    *SecurityLayer
+	tssHandler  *TSSHandler
+	reputation  *ReputationStore
+	keyStore    *KeyStore
+	registry    *CommitteeRegistry
+	metrics     *Metrics
+	metricsAddr string
+	logger      *slog.Logger
 }
 
-func NewNode(ctx context.Context, privKey crypto.PrivKey) (*Node, error) {
+type NodeConfig struct {
+	ListenAddrs []string
+	KeyStoreDir string
+
+	// RegistryFile is the committee registry of operators allowed to connect.
+	// When empty, any peer may connect.
+	RegistryFile string
+
+	Discovery DiscoveryConfig
+
+	Tracing TracingConfig
+
+	Timeouts SessionTimeouts
+
+	// Workers bounds the number of protocol updates computed at a time across
+	// all sessions; 0 uses one worker per CPU
+	Workers int
+
+	// PreParamsPoolSize is the number of keygen pre-parameter sets generated
+	// ahead in the background; 0 disables background generation
+	PreParamsPoolSize int
+
+	// MetricsAddr is the address serving Prometheus metrics; empty disables the endpoint
+	MetricsAddr string
+
+	// Faults injects network faults into the node's sessions; for tests and experiments only
+	Faults *session.FaultConfig
+}
+
+func DefaultNodeConfig() NodeConfig {
+	return NodeConfig{
+		ListenAddrs: []string{"/ip4/0.0.0.0/tcp/0"},
+		KeyStoreDir: DefaultKeyStoreDir,
+		Discovery:   DefaultDiscoveryConfig(),
+		Tracing:     DefaultTracingConfig(),
+		Timeouts:    DefaultSessionTimeouts(),
+
+		PreParamsPoolSize: DefaultPreParamsPoolSize,
+	}
+}
+
+func NewNode(ctx context.Context, privKey crypto.PrivKey, cfg NodeConfig) (*Node, error) {
+	var registry *CommitteeRegistry
+	if cfg.RegistryFile != "" {
+		r, err := LoadCommitteeRegistry(cfg.RegistryFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load committee registry: %w", err)
+		}
+		registry = r
+	}
+
 	h, err := libp2p.New(
 		libp2p.Identity(privKey),
-		libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/0"),
-		libp2p.Ping(false),
+		libp2p.ListenAddrStrings(cfg.ListenAddrs...),
+		libp2p.ConnectionGater(NewRegistryGater(registry)),
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	discovery, err := NewNodeDiscovery(ctx, h)
+	return newNode(ctx, h, privKey, cfg, registry)
+}
+
+// newNode sets up the node's services on an existing host.
+func newNode(ctx context.Context, h host.Host, privKey crypto.PrivKey, cfg NodeConfig, registry *CommitteeRegistry) (*Node, error) {
+	discovery, err := NewNodeDiscovery(ctx, h, cfg.Discovery, registry)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create node discovery: %w", err)
 	}
 
-	msgRouter := NewMessageRouter(h)
-	partyMgr := NewPartyManager(msgRouter)
+	metrics := NewMetrics()
+	reputation := NewReputationStore()
+	msgRouter := NewMessageRouter(h, reputation, registry, metrics)
+	partyMgr := NewPartyManager(msgRouter, reputation)
 	secLayer := NewSecurityLayer(privKey)
-	tssHandler := NewTSSHandler(partyMgr)
+	keyStore := NewKeyStore(cfg.KeyStoreDir, metrics)
 
 	node := &Node{
-		host:       h,
-		discovery:  discovery,
-		partyMgr:   partyMgr,
-		msgRouter:  msgRouter,
-		secLayer:   secLayer,
-		tssHandler: tssHandler,
+		ctx:         ctx,
+		host:        h,
+		discovery:   discovery,
+		partyMgr:    partyMgr,
+		msgRouter:   msgRouter,
+		secLayer:    secLayer,
+		reputation:  reputation,
+		keyStore:    keyStore,
+		registry:    registry,
+		metrics:     metrics,
+		metricsAddr: cfg.MetricsAddr,
+		logger:      nodeLogger(h.ID()),
+	}
+	node.tssHandler = NewTSSHandler(h.ID(), partyMgr, keyStore, reputation, node.sendSessionMessage, metrics, cfg.Timeouts, cfg.Workers, cfg.PreParamsPoolSize)
+	metrics.registerNode(h, node.tssHandler)
+	if cfg.Faults != nil {
+		node.tssHandler.InjectFaults(*cfg.Faults)
 	}
 
 	msgRouter.RegisterHandler(MessageTypePartyFormation, node.handlePartyFormation)
 	msgRouter.RegisterHandler(MessageTypeKeyGeneration, node.handleKeyGeneration)
 	msgRouter.RegisterHandler(MessageTypeSigning, node.handleSigning)
+	msgRouter.RegisterHandler(MessageTypePartyBatch, node.handlePartyBatch)
 
 	return node, nil
 }
@@ -66,7 +145,17 @@ func (n *Node) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to start message router: %w", err)
 	}
 
+	if n.metricsAddr != "" {
+		if err := n.metrics.Serve(n.metricsAddr); err != nil {
+			return fmt.Errorf("failed to serve metrics: %w", err)
+		}
+		n.logger.Info("Serving metrics", "url", "http://"+n.metricsAddr+MetricsPath)
+	}
+
 	go n.handleDiscoveredPeers(ctx)
+	go n.reputation.monitorLiveness(ctx, n.host)
+	go n.registry.watch(ctx, n.host)
+	go n.tssHandler.preParams.run(ctx)
 
 	return nil
 }
@@ -80,23 +169,61 @@ func (n *Node) Stop() error {
 		return fmt.Errorf("failed to stop message router: %w", err)
 	}
 
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := n.metrics.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to stop metrics server: %w", err)
+	}
+
 	return nil
 }
 
 func (n *Node) handleDiscoveredPeers(ctx context.Context) {
 	for {
 		select {
-		case peer := <-n.discovery.PeerChan():
-			fmt.Printf("Discovered new peer: %s\n", peer.ID)
-			// TODO: Implement logic to decide whether to form a party with the new peer
+		case peer, ok := <-n.discovery.PeerChan():
+			if !ok {
+				return
+			}
+			n.logger.Debug("Discovered new peer", LogKeyPeer, peer.ID)
+			// Measure the peer right away so that it can be considered by
+			// SelectPartyMembers without waiting for the next liveness round
+			go n.reputation.Ping(ctx, n.host, peer.ID)
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (n *Node) CreateParty(ctx context.Context, members []peer.ID, threshold int, operation TSSOperation) (*Party, error) {
-	return n.partyMgr.CreateParty(ctx, n.host.ID(), members, threshold, operation)
+func (n *Node) CreateParty(ctx context.Context, members []peer.ID, threshold int, operation TSSOperation, scheme Scheme) (*Party, error) {
+	return n.partyMgr.CreateParty(ctx, n.host.ID(), members, threshold, operation, scheme)
+}
+
+// SelectPartyMembers picks this node and the size-1 healthiest known peers.
+func (n *Node) SelectPartyMembers(size int) ([]peer.ID, error) {
+	members := []peer.ID{n.host.ID()}
+	for _, p := range n.reputation.Healthy() {
+		if len(members) == size {
+			break
+		}
+		if p == n.host.ID() {
+			continue
+		}
+		members = append(members, p)
+	}
+
+	if len(members) < size {
+		return nil, fmt.Errorf("not enough healthy peers: have %d, need %d", len(members)-1, size-1)
+	}
+	return members, nil
+}
+
+func (n *Node) PeerReputation(peerID peer.ID) (PeerReputation, bool) {
+	return n.reputation.Get(peerID)
+}
+
+func (n *Node) PeerReputations() []PeerReputation {
+	return n.reputation.All()
 }
 
 func (n *Node) GetParty(partyID string) (*Party, error) {
@@ -172,107 +299,96 @@ func (n *Node) handleSecureMessage(msg *Message) (*Message, error) {
 }
 
 func (n *Node) handlePartyFormation(msg *Message) error {
-	secureMsg, err := n.handleSecureMessage(msg)
-	if err != nil {
-		return fmt.Errorf("failed to handle secure message: %w", err)
+	var party Party
+	if err := json.Unmarshal(msg.Payload, &party); err != nil {
+		return invalidMessage("failed to unmarshal party announcement: %w", err)
+	}
+	if party.ID != msg.PartyID || party.Initiator != msg.From {
+		return invalidMessage("party announcement %s does not match its envelope", party.ID)
 	}
-	// TODO: Implement party formation logic
-	fmt.Printf("Received party formation message: %+v\n", secureMsg)
+
+	if err := n.partyMgr.JoinParty(n.host.ID(), &party); err != nil {
+		return fmt.Errorf("failed to join party: %w", err)
+	}
+	n.logger.Info("Joined party", LogKeyPartyID, party.ID, "operation", party.Operation.String(), "initiator", party.Initiator)
+
+	n.tssHandler.FlushPending(n.ctx, party.ID)
 	return nil
 }
 
 func (n *Node) handleKeyGeneration(msg *Message) error {
-	secureMsg, err := n.handleSecureMessage(msg)
-	if err != nil {
-		return fmt.Errorf("failed to handle secure message: %w", err)
-	}
+	return n.tssHandler.HandleMessage(n.ctx, msg)
+}
 
-	var keyGenRequest struct {
-		PartyID string `json:"party_id"`
-	}
-	if err := json.Unmarshal(secureMsg.Payload, &keyGenRequest); err != nil {
-		return fmt.Errorf("failed to unmarshal key generation request: %w", err)
-	}
+func (n *Node) handleSigning(msg *Message) error {
+	return n.tssHandler.HandleMessage(n.ctx, msg)
+}
 
-	keyShares, err := n.tssHandler.GenerateKeyShares(keyGenRequest.PartyID)
-	if err != nil {
-		return fmt.Errorf("failed to generate key shares: %w", err)
+// sendSessionMessage sends direct messages over a stream and broadcasts over pubsub.
+func (n *Node) sendSessionMessage(ctx context.Context, msg *Message) error {
+	if msg.To == "" {
+		return n.msgRouter.SendMessage(ctx, msg)
 	}
+	return n.msgRouter.SendDirect(ctx, msg)
+}
 
-	party, err := n.partyMgr.GetParty(keyGenRequest.PartyID)
+// startSession starts the protocol locally and asks the other members to follow.
+func (n *Node) startSession(ctx context.Context, party *Party) (*Session, error) {
+	session, err := n.tssHandler.StartSession(n.ctx, party)
 	if err != nil {
-		return fmt.Errorf("failed to get party: %w", err)
+		return nil, fmt.Errorf("failed to start session: %w", err)
 	}
 
-	for _, member := range party.Members {
-		if member == n.host.ID() {
-			continue
-		}
-
-		keyShareMsg := &Message{
-			Type:    MessageTypeKeyGeneration,
-			PartyID: keyGenRequest.PartyID,
-			From:    n.host.ID(),
-			To:      member,
-			Payload: json.RawMessage(fmt.Sprintf(`{"key_share": %v}`, keyShares[0])),
-		}
-
-		if err := n.SendSecureMessage(context.Background(), keyShareMsg, n.host.Peerstore().PubKey(member)); err != nil {
-			return fmt.Errorf("failed to send key share to %s: %w", member, err)
-		}
+	// Members that miss the start message show up as missing in the round 1 report
+	if err := n.tssHandler.SendControl(session.TraceContext(ctx), party, SessionActionStart); err != nil {
+		n.logger.Warn("Error starting session on all members", LogKeyPartyID, party.ID, LogKeyError, err)
 	}
 
-	return nil
+	return session, nil
 }
 
-func (n *Node) handleSigning(msg *Message) error {
-	secureMsg, err := n.handleSecureMessage(msg)
+// StartKeyGeneration starts key generation for a party formed by this node.
+func (n *Node) StartKeyGeneration(ctx context.Context, partyID string) (*Session, error) {
+	party, err := n.partyMgr.GetParty(partyID)
 	if err != nil {
-		return fmt.Errorf("failed to handle secure message: %w", err)
+		return nil, err
 	}
-
-	var signRequest struct {
-		PartyID string `json:"party_id"`
-		Message []byte `json:"message"`
+	if party.Operation != TSSOperationKeyGen {
+		return nil, fmt.Errorf("party %s is not a key generation party", partyID)
 	}
-	if err := json.Unmarshal(secureMsg.Payload, &signRequest); err != nil {
-		return fmt.Errorf("failed to unmarshal signing request: %w", err)
+	if party.Initiator != n.host.ID() {
+		return nil, fmt.Errorf("only the initiator can start key generation for party %s", partyID)
 	}
 
-	keyShares, err := n.tssHandler.GenerateKeyShares(signRequest.PartyID)
-	if err != nil {
-		return fmt.Errorf("failed to generate key shares: %w", err)
-	}
+	return n.startSession(ctx, party)
+}
 
-	signatureShares, err := n.tssHandler.SignMessage(signRequest.PartyID, signRequest.Message, keyShares)
+// GenerateKey runs key generation of a key of the given scheme with the given
+// members and returns this node's share. The key ID is the ID of the keygen
+// party.
+func (n *Node) GenerateKey(ctx context.Context, members []peer.ID, threshold int, scheme Scheme) (*KeyShareRecord, error) {
+	party, err := n.CreateParty(ctx, members, threshold, TSSOperationKeyGen, scheme)
 	if err != nil {
-		return fmt.Errorf("failed to generate signature shares: %w", err)
+		return nil, err
 	}
 
-	party, err := n.partyMgr.GetParty(signRequest.PartyID)
+	session, err := n.startSession(ctx, party)
 	if err != nil {
-		return fmt.Errorf("failed to get party: %w", err)
+		return nil, err
+	}
+	if err := session.Wait(ctx); err != nil {
+		return nil, err
 	}
 
-	for _, member := range party.Members {
-		if member == n.host.ID() {
-			continue
-		}
-
-		signShareMsg := &Message{
-			Type:    MessageTypeSigning,
-			PartyID: signRequest.PartyID,
-			From:    n.host.ID(),
-			To:      member,
-			Payload: json.RawMessage(fmt.Sprintf(`{"signature_share": %v}`, signatureShares[0])),
-		}
+	return n.keyStore.Load(party.ID)
+}
 
-		if err := n.SendSecureMessage(context.Background(), signShareMsg, n.host.Peerstore().PubKey(member)); err != nil {
-			return fmt.Errorf("failed to send signature share to %s: %w", member, err)
-		}
-	}
+func (n *Node) GetKeyShare(keyID string) (*KeyShareRecord, error) {
+	return n.keyStore.Load(keyID)
+}
 
-	return nil
+func (n *Node) ListKeys() ([]string, error) {
+	return n.keyStore.List()
 }
 
 // Add this method to the Node struct
@@ -2,72 +2,152 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	dht "github.com/libp2p/go-libp2p-kad-dht"
-	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
 )
 
 const (
 	DiscoveryInterval = 1 * time.Minute
 	DiscoveryTimeout  = 10 * time.Second
+
+	DefaultDiscoveryNamespace = "tss-network"
+	// A private prefix keeps the TSS DHT separate from the public IPFS DHT
+	DefaultDHTProtocolPrefix = "/tss"
+	DefaultKnownPeersFile    = "data/peers.json"
 )
 
+type DiscoveryConfig struct {
+	// BootstrapPeers are full multiaddrs including the /p2p/ component
+	BootstrapPeers []string
+	// DHTProtocolPrefix is prepended to the DHT protocol IDs
+	DHTProtocolPrefix string
+	// Namespace is the rendezvous string advertised in the DHT and used as mDNS service name
+	Namespace string
+	// KnownPeersFile persists connected peers so the node can reconnect after a restart.
+	// When empty, known peers are not persisted.
+	KnownPeersFile string
+	// EnableMDNS turns on local network discovery. Production deployments
+	// usually rely on bootstrap peers and the DHT only.
+	EnableMDNS bool
+}
+
+func DefaultDiscoveryConfig() DiscoveryConfig {
+	return DiscoveryConfig{
+		DHTProtocolPrefix: DefaultDHTProtocolPrefix,
+		Namespace:         DefaultDiscoveryNamespace,
+		KnownPeersFile:    DefaultKnownPeersFile,
+		EnableMDNS:        true,
+	}
+}
+
 type NodeDiscovery struct {
-	host       host.Host
-	dht        *dht.IpfsDHT
-	pubsub     *pubsub.PubSub
-	peerChan   chan peer.AddrInfo
-	ctx        context.Context
-	cancelFunc context.CancelFunc
-	wg         sync.WaitGroup
+	host           host.Host
+	dht            *dht.IpfsDHT
+	routing        *drouting.RoutingDiscovery
+	mdns           mdns.Service
+	registry       *CommitteeRegistry
+	cfg            DiscoveryConfig
+	bootstrapPeers []peer.AddrInfo
+	peerChan       chan peer.AddrInfo
+	ctx            context.Context
+	cancelFunc     context.CancelFunc
+	wg             sync.WaitGroup
+
+	// Guards peerChan against sends after Stop closed it
+	peerChanMu     sync.RWMutex
+	peerChanClosed bool
+
+	logger *slog.Logger
 }
 
-func NewNodeDiscovery(ctx context.Context, h host.Host) (*NodeDiscovery, error) {
+func NewNodeDiscovery(ctx context.Context, h host.Host, cfg DiscoveryConfig, registry *CommitteeRegistry) (*NodeDiscovery, error) {
+	bootstrapPeers, err := parseBootstrapPeers(cfg.BootstrapPeers)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 
 	// Initialize DHT
-	dht, err := dht.New(ctx, h)
+	dht, err := dht.New(ctx, h,
+		dht.ProtocolPrefix(protocol.ID(cfg.DHTProtocolPrefix)),
+		dht.BootstrapPeers(bootstrapPeers...),
+		dht.Mode(dht.ModeAutoServer),
+	)
 	if err != nil {
 		cancel()
 		return nil, fmt.Errorf("failed to create DHT: %w", err)
 	}
 
-	// Initialize PubSub
-	ps, err := pubsub.NewGossipSub(ctx, h)
-	if err != nil {
-		cancel()
-		return nil, fmt.Errorf("failed to create PubSub: %w", err)
-	}
-
 	nd := &NodeDiscovery{
-		host:       h,
-		dht:        dht,
-		pubsub:     ps,
-		peerChan:   make(chan peer.AddrInfo),
-		ctx:        ctx,
-		cancelFunc: cancel,
+		host:           h,
+		dht:            dht,
+		routing:        drouting.NewRoutingDiscovery(dht),
+		registry:       registry,
+		cfg:            cfg,
+		bootstrapPeers: bootstrapPeers,
+		logger:         nodeLogger(h.ID()),
+		peerChan:       make(chan peer.AddrInfo),
+		ctx:            ctx,
+		cancelFunc:     cancel,
 	}
 
 	return nd, nil
 }
 
+func parseBootstrapPeers(addrs []string) ([]peer.AddrInfo, error) {
+	peers := make([]peer.AddrInfo, 0, len(addrs))
+	for _, addr := range addrs {
+		info, err := peer.AddrInfoFromString(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap peer %s: %w", addr, err)
+		}
+		peers = append(peers, *info)
+	}
+	return peers, nil
+}
+
 func (nd *NodeDiscovery) Start() error {
+	// Connect to bootstrap and previously known peers first so that the DHT
+	// has a routing table to bootstrap from
+	nd.connectPeers(nd.bootstrapPeers)
+
+	knownPeers, err := nd.loadKnownPeers()
+	if err != nil {
+		nd.logger.Warn("Error loading known peers", LogKeyError, err)
+	}
+	nd.connectPeers(knownPeers)
+
 	// Start DHT
 	if err := nd.dht.Bootstrap(nd.ctx); err != nil {
 		return fmt.Errorf("failed to bootstrap DHT: %w", err)
 	}
 
 	// Start mDNS discovery
-	if err := nd.setupMDNS(); err != nil {
-		return fmt.Errorf("failed to setup mDNS: %w", err)
+	if nd.cfg.EnableMDNS {
+		if err := nd.setupMDNS(); err != nil {
+			return fmt.Errorf("failed to setup mDNS: %w", err)
+		}
 	}
 
+	// Advertise the rendezvous namespace; this keeps re-advertising until stopped
+	dutil.Advertise(nd.ctx, nd.routing, nd.cfg.Namespace)
+
 	// Start continuous peer discovery
 	nd.wg.Add(1)
 	go nd.discoverPeers()
@@ -76,22 +156,98 @@ func (nd *NodeDiscovery) Start() error {
 }
 
 func (nd *NodeDiscovery) Stop() error {
+	if err := nd.saveKnownPeers(); err != nil {
+		nd.logger.Warn("Error saving known peers", LogKeyError, err)
+	}
+
+	if nd.mdns != nil {
+		if err := nd.mdns.Close(); err != nil {
+			nd.logger.Warn("Error stopping mDNS service", LogKeyError, err)
+		}
+	}
+
 	nd.cancelFunc()
 	nd.wg.Wait()
+
+	nd.peerChanMu.Lock()
+	nd.peerChanClosed = true
 	close(nd.peerChan)
-	return nil
+	nd.peerChanMu.Unlock()
+
+	return nd.dht.Close()
 }
 
 func (nd *NodeDiscovery) setupMDNS() error {
-	mdnsService := mdns.NewMdnsService(nd.host, "tss-network", nil)
+	mdnsService := mdns.NewMdnsService(nd.host, nd.cfg.Namespace, &mdnsNotifee{nd: nd})
 	if err := mdnsService.Start(); err != nil {
 		return fmt.Errorf("failed to start mDNS service: %w", err)
 	}
-	// TODO ???
-	//nd.host.Network().Notify(mdnsService.EventHandler())
+	nd.mdns = mdnsService
 	return nil
 }
 
+// mdnsNotifee connects to peers found on the local network and reports them.
+type mdnsNotifee struct {
+	nd *NodeDiscovery
+}
+
+func (n *mdnsNotifee) HandlePeerFound(p peer.AddrInfo) {
+	ctx, cancel := context.WithTimeout(n.nd.ctx, DiscoveryTimeout)
+	defer cancel()
+
+	n.nd.reportPeer(ctx, p)
+}
+
+// reportPeer connects to a discovered peer that is allowed by the committee
+// registry and pushes it onto the peer channel.
+func (nd *NodeDiscovery) reportPeer(ctx context.Context, p peer.AddrInfo) {
+	if p.ID == nd.host.ID() || len(p.Addrs) == 0 {
+		return // Skip self and peers we can't dial
+	}
+	if !nd.registry.Allows(p.ID) {
+		return
+	}
+
+	if nd.host.Network().Connectedness(p.ID) != network.Connected {
+		if err := nd.host.Connect(ctx, p); err != nil {
+			nd.logger.Debug("Error connecting to peer", LogKeyPeer, p.ID, LogKeyError, err)
+			return
+		}
+	}
+
+	nd.peerChanMu.RLock()
+	defer nd.peerChanMu.RUnlock()
+	if nd.peerChanClosed {
+		return
+	}
+
+	select {
+	case nd.peerChan <- p:
+	case <-nd.ctx.Done():
+	}
+}
+
+func (nd *NodeDiscovery) connectPeers(peers []peer.AddrInfo) {
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		if p.ID == nd.host.ID() {
+			continue
+		}
+		wg.Add(1)
+		go func(p peer.AddrInfo) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(nd.ctx, DiscoveryTimeout)
+			defer cancel()
+
+			if err := nd.host.Connect(ctx, p); err != nil {
+				nd.logger.Debug("Error connecting to bootstrap peer", LogKeyPeer, p.ID, LogKeyError, err)
+			}
+		}(p)
+	}
+	wg.Wait()
+}
+
 func (nd *NodeDiscovery) discoverPeers() {
 	defer nd.wg.Done()
 
@@ -99,31 +255,91 @@ func (nd *NodeDiscovery) discoverPeers() {
 	defer ticker.Stop()
 
 	for {
+		nd.findPeers()
+
+		if err := nd.saveKnownPeers(); err != nil {
+			nd.logger.Warn("Error saving known peers", LogKeyError, err)
+		}
+
 		select {
 		case <-nd.ctx.Done():
 			return
 		case <-ticker.C:
-			ctx, cancel := context.WithTimeout(nd.ctx, DiscoveryTimeout)
-			peers, err := nd.dht.GetClosestPeers(ctx, "tss-network")
-			cancel()
+		}
+	}
+}
 
-			if err != nil {
-				fmt.Printf("Error finding peers: %v\n", err)
-				continue
-			}
+// findPeers looks up the peers advertising the namespace, connects to them
+// and reports them on the peer channel.
+func (nd *NodeDiscovery) findPeers() {
+	ctx, cancel := context.WithTimeout(nd.ctx, DiscoveryTimeout)
+	defer cancel()
 
-			for _, p := range peers {
-				if p == nd.host.ID() {
-					continue // Skip self
-				}
-				select {
-				case nd.peerChan <- peer.AddrInfo{ID: p}:
-				case <-nd.ctx.Done():
-					return
-				}
-			}
+	peers, err := dutil.FindPeers(ctx, nd.routing, nd.cfg.Namespace)
+	if err != nil {
+		nd.logger.Warn("Error finding peers", LogKeyError, err)
+		return
+	}
+
+	for _, p := range peers {
+		nd.reportPeer(ctx, p)
+	}
+}
+
+func (nd *NodeDiscovery) loadKnownPeers() ([]peer.AddrInfo, error) {
+	if nd.cfg.KnownPeersFile == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(nd.cfg.KnownPeersFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known peers: %w", err)
+	}
+
+	var peers []peer.AddrInfo
+	if err := json.Unmarshal(data, &peers); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal known peers: %w", err)
+	}
+
+	for _, p := range peers {
+		nd.host.Peerstore().AddAddrs(p.ID, p.Addrs, peerstore.AddressTTL)
+	}
+
+	return peers, nil
+}
+
+// saveKnownPeers persists the currently connected peers and their addresses.
+func (nd *NodeDiscovery) saveKnownPeers() error {
+	if nd.cfg.KnownPeersFile == "" {
+		return nil
+	}
+
+	var peers []peer.AddrInfo
+	for _, p := range nd.host.Network().Peers() {
+		info := nd.host.Peerstore().PeerInfo(p)
+		if len(info.Addrs) == 0 {
+			continue
 		}
+		peers = append(peers, info)
+	}
+	if len(peers) == 0 {
+		// Keep the previous list rather than forgetting everyone after a network outage
+		return nil
 	}
+
+	data, err := json.MarshalIndent(peers, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal known peers: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(nd.cfg.KnownPeersFile), 0700); err != nil {
+		return fmt.Errorf("failed to create known peers directory: %w", err)
+	}
+
+	return os.WriteFile(nd.cfg.KnownPeersFile, data, 0600)
 }
 
 func (nd *NodeDiscovery) PeerChan() <-chan peer.AddrInfo {
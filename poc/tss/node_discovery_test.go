@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func newTestHost(t *testing.T) host.Host {
+	t.Helper()
+
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatalf("failed to create host: %v", err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func newTestDiscovery(t *testing.T, h host.Host, namespace string, enableMDNS bool, registry *CommitteeRegistry) *NodeDiscovery {
+	t.Helper()
+
+	cfg := DefaultDiscoveryConfig()
+	cfg.Namespace = namespace
+	cfg.KnownPeersFile = ""
+	cfg.EnableMDNS = enableMDNS
+
+	nd, err := NewNodeDiscovery(context.Background(), h, cfg, registry)
+	if err != nil {
+		t.Fatalf("failed to create node discovery: %v", err)
+	}
+	return nd
+}
+
+func waitForPeer(t *testing.T, nd *NodeDiscovery, want peer.ID, timeout time.Duration) bool {
+	t.Helper()
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case p := <-nd.PeerChan():
+			if p.ID == want {
+				return true
+			}
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+func TestMDNSDiscovery(t *testing.T) {
+	namespace := fmt.Sprintf("tss-test-%d", time.Now().UnixNano())
+
+	h1, h2 := newTestHost(t), newTestHost(t)
+	nd1 := newTestDiscovery(t, h1, namespace, true, nil)
+	nd2 := newTestDiscovery(t, h2, namespace, true, nil)
+
+	for _, nd := range []*NodeDiscovery{nd1, nd2} {
+		if err := nd.Start(); err != nil {
+			t.Fatalf("failed to start node discovery: %v", err)
+		}
+		defer nd.Stop()
+	}
+
+	found := make(chan bool, 2)
+	go func() { found <- waitForPeer(t, nd1, h2.ID(), 20*time.Second) }()
+	go func() { found <- waitForPeer(t, nd2, h1.ID(), 20*time.Second) }()
+
+	for i := 0; i < 2; i++ {
+		if !<-found {
+			t.Fatal("nodes did not discover each other over mDNS")
+		}
+	}
+
+	if h1.Network().Connectedness(h2.ID()) != network.Connected {
+		t.Fatal("discovered peers are not connected")
+	}
+}
+
+func TestMDNSNotifeeConnectsAndReports(t *testing.T) {
+	h1, h2 := newTestHost(t), newTestHost(t)
+	nd := newTestDiscovery(t, h1, "tss-test", false, nil)
+	defer nd.Stop()
+
+	notifee := &mdnsNotifee{nd: nd}
+	go notifee.HandlePeerFound(peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()})
+
+	if !waitForPeer(t, nd, h2.ID(), 5*time.Second) {
+		t.Fatal("found peer was not reported on the peer channel")
+	}
+	if h1.Network().Connectedness(h2.ID()) != network.Connected {
+		t.Fatal("found peer was not connected")
+	}
+
+	// Self is never reported
+	go notifee.HandlePeerFound(peer.AddrInfo{ID: h1.ID(), Addrs: h1.Addrs()})
+	if waitForPeer(t, nd, h1.ID(), 500*time.Millisecond) {
+		t.Fatal("self was reported on the peer channel")
+	}
+}
+
+func TestMDNSNotifeeFiltersUnregisteredPeers(t *testing.T) {
+	h1, h2 := newTestHost(t), newTestHost(t)
+
+	registry, _ := newTestRegistry(t, h1.ID())
+	nd := newTestDiscovery(t, h1, "tss-test", false, registry)
+	defer nd.Stop()
+
+	notifee := &mdnsNotifee{nd: nd}
+	go notifee.HandlePeerFound(peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()})
+
+	if waitForPeer(t, nd, h2.ID(), time.Second) {
+		t.Fatal("unregistered peer was reported on the peer channel")
+	}
+	if h1.Network().Connectedness(h2.ID()) == network.Connected {
+		t.Fatal("unregistered peer was connected")
+	}
+}
+
+func newKnownPeersDiscovery(t *testing.T, h host.Host, path string) *NodeDiscovery {
+	t.Helper()
+
+	cfg := DefaultDiscoveryConfig()
+	cfg.Namespace = "tss-test"
+	cfg.KnownPeersFile = path
+	cfg.EnableMDNS = false
+
+	nd, err := NewNodeDiscovery(context.Background(), h, cfg, nil)
+	if err != nil {
+		t.Fatalf("failed to create node discovery: %v", err)
+	}
+	return nd
+}
+
+func TestKnownPeersRoundTrip(t *testing.T) {
+	h1, h2 := newTestHost(t), newTestHost(t)
+	path := filepath.Join(t.TempDir(), "data", "peers.json")
+
+	nd1 := newKnownPeersDiscovery(t, h1, path)
+	defer nd1.Stop()
+
+	// Nothing is saved while no peer is connected
+	if err := nd1.saveKnownPeers(); err != nil {
+		t.Fatalf("failed to save known peers: %v", err)
+	}
+	if peers, err := nd1.loadKnownPeers(); err != nil || len(peers) != 0 {
+		t.Fatalf("loaded %v, %v from a missing known peers file, want none", peers, err)
+	}
+
+	if err := h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	if err := nd1.saveKnownPeers(); err != nil {
+		t.Fatalf("failed to save known peers: %v", err)
+	}
+
+	// A restarted node learns the addresses of its known peers and reconnects
+	h3 := newTestHost(t)
+	nd3 := newKnownPeersDiscovery(t, h3, path)
+	peers, err := nd3.loadKnownPeers()
+	if err != nil {
+		t.Fatalf("failed to load known peers: %v", err)
+	}
+	if len(peers) != 1 || peers[0].ID != h2.ID() || len(peers[0].Addrs) == 0 {
+		t.Fatalf("loaded known peers %v, want %s with its addresses", peers, h2.ID())
+	}
+	if addrs := h3.Peerstore().Addrs(h2.ID()); len(addrs) == 0 {
+		t.Fatal("known peer addresses were not added to the peerstore")
+	}
+
+	if err := nd3.Start(); err != nil {
+		t.Fatalf("failed to start node discovery: %v", err)
+	}
+	defer nd3.Stop()
+	if h3.Network().Connectedness(h2.ID()) != network.Connected {
+		t.Fatal("restarted node did not reconnect to its known peer")
+	}
+}
+
+func TestLoadKnownPeersRejectsCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	if err := os.WriteFile(path, []byte("not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	nd := newKnownPeersDiscovery(t, newTestHost(t), path)
+	defer nd.Stop()
+	if _, err := nd.loadKnownPeers(); err == nil {
+		t.Fatal("expected an error loading a corrupt known peers file")
+	}
+}
+
+func TestParseBootstrapPeers(t *testing.T) {
+	h := newTestHost(t)
+	valid := fmt.Sprintf("%s/p2p/%s", h.Addrs()[0], h.ID())
+
+	peers, err := parseBootstrapPeers([]string{valid})
+	if err != nil {
+		t.Fatalf("failed to parse bootstrap peer: %v", err)
+	}
+	if len(peers) != 1 || peers[0].ID != h.ID() || len(peers[0].Addrs) != 1 || !peers[0].Addrs[0].Equal(h.Addrs()[0]) {
+		t.Fatalf("parsed bootstrap peers %v, want %s", peers, valid)
+	}
+
+	for _, addr := range []string{
+		h.Addrs()[0].String(),
+		"/ip4/127.0.0.1/tcp/4001/p2p/not-a-peer",
+		"not a multiaddr",
+	} {
+		if _, err := parseBootstrapPeers([]string{valid, addr}); err == nil {
+			t.Errorf("expected an error parsing bootstrap peer %q", addr)
+		}
+	}
+
+	cfg := DefaultDiscoveryConfig()
+	cfg.BootstrapPeers = []string{"not a multiaddr"}
+	if _, err := NewNodeDiscovery(context.Background(), h, cfg, nil); err == nil {
+		t.Fatal("expected node discovery to reject an invalid bootstrap peer")
+	}
+}
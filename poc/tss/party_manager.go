@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
@@ -25,12 +27,60 @@ const (
 	PartyStatusFailed
 )
 
+func (s PartyStatus) String() string {
+	switch s {
+	case PartyStatusForming:
+		return "forming"
+	case PartyStatusReady:
+		return "ready"
+	case PartyStatusActive:
+		return "active"
+	case PartyStatusCompleted:
+		return "completed"
+	case PartyStatusFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// UnreachableMembersError lists the members a party announcement could not be delivered to.
+type UnreachableMembersError struct {
+	Members []peer.ID
+}
+
+func (e *UnreachableMembersError) Error() string {
+	return fmt.Sprintf("unreachable party members: %v", e.Members)
+}
+
 type Party struct {
-	ID        string
-	Members   []peer.ID
-	Threshold int
-	Status    PartyStatus
-	Operation TSSOperation
+	ID        string       `json:"id"`
+	Initiator peer.ID      `json:"initiator"`
+	Members   []peer.ID    `json:"members"`
+	Threshold int          `json:"threshold"`
+	Status    PartyStatus  `json:"status"`
+	Operation TSSOperation `json:"operation"`
+
+	// Key generation parties only: the signature scheme of the key, ECDSA if
+	// empty, and the BIP32 chain code of ECDSA keys
+	Scheme    Scheme `json:"scheme,omitempty"`
+	ChainCode []byte `json:"chain_code,omitempty"`
+	// Signing parties only: the key to sign with, the derivation path of the
+	// child key to sign with if any, and the digest to sign
+	KeyID          string         `json:"key_id,omitempty"`
+	DerivationPath DerivationPath `json:"derivation_path,omitempty"`
+	Message        []byte         `json:"message,omitempty"`
+	// BatchID is set on the signing parties of a batch, see PartyBatch
+	BatchID string `json:"batch_id,omitempty"`
+}
+
+func (p *Party) IsMember(peerID peer.ID) bool {
+	for _, member := range p.Members {
+		if member == peerID {
+			return true
+		}
+	}
+	return false
 }
 
 type TSSOperation int
@@ -40,76 +90,251 @@ const (
 	TSSOperationSigning
 )
 
+func (op TSSOperation) String() string {
+	switch op {
+	case TSSOperationKeyGen:
+		return "keygen"
+	case TSSOperationSigning:
+		return "signing"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(op))
+	}
+}
+
+// MessageType returns the router message type carrying this operation's protocol messages.
+func (op TSSOperation) MessageType() MessageType {
+	if op == TSSOperationSigning {
+		return MessageTypeSigning
+	}
+	return MessageTypeKeyGeneration
+}
+
 type PartyManager struct {
 	parties     map[string]*Party
 	peerParties map[peer.ID]map[string]struct{}
 	mu          sync.RWMutex
 	msgRouter   *MessageRouter
+	reputation  *ReputationStore
+	logger      *slog.Logger
 }
 
-func NewPartyManager(msgRouter *MessageRouter) *PartyManager {
+func NewPartyManager(msgRouter *MessageRouter, reputation *ReputationStore) *PartyManager {
 	return &PartyManager{
 		parties:     make(map[string]*Party),
 		peerParties: make(map[peer.ID]map[string]struct{}),
 		msgRouter:   msgRouter,
+		reputation:  reputation,
+		logger:      msgRouter.logger,
 	}
 }
 
-func (pm *PartyManager) CreateParty(ctx context.Context, initiator peer.ID, members []peer.ID, threshold int, operation TSSOperation) (*Party, error) {
-	if len(members) < MinPartySize || len(members) > MaxPartySize {
-		return nil, fmt.Errorf("invalid party size: %d (min: %d, max: %d)", len(members), MinPartySize, MaxPartySize)
-	}
-
-	if threshold < 2 || threshold > len(members) {
-		return nil, fmt.Errorf("invalid threshold: %d (must be between 2 and party size)", threshold)
-	}
-
-	partyID := generatePartyID()
+func (pm *PartyManager) CreateParty(ctx context.Context, initiator peer.ID, members []peer.ID, threshold int, operation TSSOperation, scheme Scheme) (*Party, error) {
 	party := &Party{
-		ID:        partyID,
+		Initiator: initiator,
 		Members:   members,
 		Threshold: threshold,
-		Status:    PartyStatusForming,
 		Operation: operation,
+		Scheme:    scheme,
 	}
-
-	pm.mu.Lock()
-	pm.parties[partyID] = party
-	for _, member := range members {
-		if pm.peerParties[member] == nil {
-			pm.peerParties[member] = make(map[string]struct{})
+	// The initiator picks the chain code all holders store with their shares
+	if operation == TSSOperationKeyGen && scheme != SchemeEdDSA {
+		chainCode, err := newChainCode()
+		if err != nil {
+			return nil, err
 		}
-		pm.peerParties[member][partyID] = struct{}{}
+		party.ChainCode = chainCode
+	}
+	return pm.createParty(ctx, party)
+}
+
+// CreateSigningParty forms a party of signers that will sign digest with the
+// key keyID, or with its child key at path if path is not empty.
+func (pm *PartyManager) CreateSigningParty(ctx context.Context, initiator peer.ID, signers []peer.ID, threshold int, keyID string, path DerivationPath, digest []byte) (*Party, error) {
+	return pm.createParty(ctx, &Party{
+		Initiator:      initiator,
+		Members:        signers,
+		Threshold:      threshold,
+		Operation:      TSSOperationSigning,
+		KeyID:          keyID,
+		DerivationPath: path,
+		Message:        digest,
+	})
+}
+
+func (pm *PartyManager) createParty(ctx context.Context, party *Party) (*Party, error) {
+	if err := validateParty(party); err != nil {
+		return nil, err
 	}
-	pm.mu.Unlock()
 
-	go pm.formParty(ctx, party)
+	party.ID = generatePartyID()
+	party.Status = PartyStatusForming
+	pm.registerParty(party)
+
+	if err := pm.formParty(ctx, party); err != nil {
+		return nil, err
+	}
 
 	return party, nil
 }
 
-func (pm *PartyManager) formParty(ctx context.Context, party *Party) {
+// CreateSigningBatch forms one signing party per digest, all with the same
+// signers. The parties are announced to each member in a single message.
+func (pm *PartyManager) CreateSigningBatch(ctx context.Context, initiator peer.ID, signers []peer.ID, threshold int, keyID string, digests [][]byte) ([]*Party, error) {
+	batchID := generatePartyID()
+	parties := make([]*Party, len(digests))
+	for i, digest := range digests {
+		party := &Party{
+			ID:        fmt.Sprintf("%s-%d", batchID, i),
+			Initiator: initiator,
+			Members:   signers,
+			Threshold: threshold,
+			Status:    PartyStatusForming,
+			Operation: TSSOperationSigning,
+			KeyID:     keyID,
+			Message:   digest,
+			BatchID:   batchID,
+		}
+		if err := validateParty(party); err != nil {
+			return nil, err
+		}
+		parties[i] = party
+	}
+	for _, party := range parties {
+		pm.registerParty(party)
+	}
+
 	formationCtx, cancel := context.WithTimeout(ctx, PartyFormationTimeout)
 	defer cancel()
 
-	// Simulate party formation process
-	time.Sleep(2 * time.Second)
+	batch := &PartyBatch{ID: batchID, Action: BatchActionAnnounce, Parties: parties}
+	if err := pm.sendBatch(formationCtx, initiator, signers, batch); err != nil {
+		pm.mu.Lock()
+		for _, party := range parties {
+			if party.Status == PartyStatusForming {
+				party.Status = PartyStatusFailed
+				pm.cleanupParty(party.ID)
+			}
+		}
+		pm.mu.Unlock()
+		return nil, fmt.Errorf("failed to form batch %s: %w", batchID, err)
+	}
+
+	pm.mu.Lock()
+	for _, party := range parties {
+		party.Status = PartyStatusReady
+	}
+	pm.mu.Unlock()
 
-	select {
-	case <-formationCtx.Done():
+	return parties, nil
+}
+
+// StartBatch asks the other members to start the sessions of a batch.
+func (pm *PartyManager) StartBatch(ctx context.Context, initiator peer.ID, signers []peer.ID, batchID string) error {
+	return pm.sendBatch(ctx, initiator, signers, &PartyBatch{ID: batchID, Action: BatchActionStart})
+}
+
+// ReadyBatchParties returns the parties of the batch batchID whose sessions
+// have not been started yet.
+func (pm *PartyManager) ReadyBatchParties(batchID string) []*Party {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	var parties []*Party
+	for _, party := range pm.parties {
+		if party.BatchID == batchID && party.Status == PartyStatusReady {
+			parties = append(parties, party)
+		}
+	}
+	return parties
+}
+
+// JoinParty registers a party announced by another member.
+func (pm *PartyManager) JoinParty(self peer.ID, party *Party) error {
+	if err := validateParty(party); err != nil {
+		return invalidMessage("invalid party %s: %w", party.ID, err)
+	}
+	if !party.IsMember(self) {
+		return fmt.Errorf("not a member of party %s", party.ID)
+	}
+
+	pm.mu.RLock()
+	_, exists := pm.parties[party.ID]
+	pm.mu.RUnlock()
+	if exists {
+		return fmt.Errorf("party already exists: %s", party.ID)
+	}
+
+	party.Status = PartyStatusReady
+	pm.registerParty(party)
+
+	return nil
+}
+
+func validateParty(party *Party) error {
+	if len(party.Members) < MinPartySize || len(party.Members) > MaxPartySize {
+		return fmt.Errorf("invalid party size: %d (min: %d, max: %d)", len(party.Members), MinPartySize, MaxPartySize)
+	}
+
+	// tss-lib requires threshold+1 parties to sign, so the threshold must stay below the party size
+	if party.Threshold < 2 || party.Threshold >= len(party.Members) {
+		return fmt.Errorf("invalid threshold: %d (must be between 2 and party size - 1)", party.Threshold)
+	}
+
+	seen := make(map[peer.ID]struct{}, len(party.Members))
+	for _, member := range party.Members {
+		if _, dup := seen[member]; dup {
+			return fmt.Errorf("duplicate party member: %s", member)
+		}
+		seen[member] = struct{}{}
+	}
+	if !party.IsMember(party.Initiator) {
+		return fmt.Errorf("initiator %s is not a party member", party.Initiator)
+	}
+	if _, err := ParseScheme(string(party.Scheme)); err != nil {
+		return err
+	}
+	if len(party.ChainCode) != 0 && len(party.ChainCode) != ChainCodeLength {
+		return fmt.Errorf("invalid chain code length: %d", len(party.ChainCode))
+	}
+	if err := party.DerivationPath.Validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (pm *PartyManager) registerParty(party *Party) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.parties[party.ID] = party
+	for _, member := range party.Members {
+		if pm.peerParties[member] == nil {
+			pm.peerParties[member] = make(map[string]struct{})
+		}
+		pm.peerParties[member][party.ID] = struct{}{}
+	}
+}
+
+func (pm *PartyManager) formParty(ctx context.Context, party *Party) error {
+	formationCtx, cancel := context.WithTimeout(ctx, PartyFormationTimeout)
+	defer cancel()
+
+	if err := pm.notifyPartyMembers(formationCtx, party); err != nil {
 		pm.mu.Lock()
 		if party.Status == PartyStatusForming {
 			party.Status = PartyStatusFailed
 			pm.cleanupParty(party.ID)
 		}
 		pm.mu.Unlock()
-	default:
-		pm.mu.Lock()
-		party.Status = PartyStatusReady
-		pm.mu.Unlock()
-
-		pm.notifyPartyMembers(party)
+		return fmt.Errorf("failed to form party %s: %w", party.ID, err)
 	}
+
+	pm.mu.Lock()
+	party.Status = PartyStatusReady
+	pm.mu.Unlock()
+
+	return nil
 }
 
 func (pm *PartyManager) GetParty(partyID string) (*Party, error) {
@@ -145,8 +370,20 @@ func (pm *PartyManager) UpdatePartyStatus(partyID string, status PartyStatus) er
 		return fmt.Errorf("party not found: %s", partyID)
 	}
 
+	// Only parties whose session started count towards the completion rate
+	if status == PartyStatusActive && party.Status != PartyStatusActive {
+		for _, member := range party.Members {
+			pm.reputation.RecordSessionStarted(member)
+		}
+	}
 	party.Status = status
 
+	if status == PartyStatusCompleted {
+		for _, member := range party.Members {
+			pm.reputation.RecordSessionCompleted(member)
+		}
+	}
+
 	if status == PartyStatusCompleted || status == PartyStatusFailed {
 		pm.cleanupParty(partyID)
 	}
@@ -170,11 +407,52 @@ func (pm *PartyManager) cleanupParty(partyID string) {
 	delete(pm.parties, partyID)
 }
 
-func (pm *PartyManager) notifyPartyMembers(party *Party) {
-	// TODO: Implement actual notification logic using MessageRouter
-	for _, member := range party.Members {
-		fmt.Printf("Notifying peer %s about party %s formation\n", member, party.ID)
+// notifyPartyMembers announces the party to every other member. Direct
+// messages are acknowledged, so once this returns all members know the party.
+func (pm *PartyManager) notifyPartyMembers(ctx context.Context, party *Party) error {
+	payload, err := json.Marshal(party)
+	if err != nil {
+		return fmt.Errorf("failed to marshal party: %w", err)
 	}
+
+	return pm.sendToMembers(ctx, party.Initiator, party.Members, MessageTypePartyFormation, party.ID, payload)
+}
+
+func (pm *PartyManager) sendBatch(ctx context.Context, initiator peer.ID, members []peer.ID, batch *PartyBatch) error {
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal batch: %w", err)
+	}
+
+	return pm.sendToMembers(ctx, initiator, members, MessageTypePartyBatch, batch.ID, payload)
+}
+
+// sendToMembers sends a direct message to every member but the initiator,
+// returning an UnreachableMembersError for the members it could not reach.
+func (pm *PartyManager) sendToMembers(ctx context.Context, initiator peer.ID, members []peer.ID, msgType MessageType, partyID string, payload []byte) error {
+	var unreachable []peer.ID
+	for _, member := range members {
+		if member == initiator {
+			continue
+		}
+
+		msg := &Message{
+			Type:    msgType,
+			PartyID: partyID,
+			From:    initiator,
+			To:      member,
+			Payload: payload,
+		}
+		if err := pm.msgRouter.SendDirect(ctx, msg); err != nil {
+			pm.logger.Warn("Failed to notify member about party", LogKeyPeer, member, LogKeyPartyID, partyID, LogKeyMsgType, msgType.String(), LogKeyError, err)
+			unreachable = append(unreachable, member)
+		}
+	}
+
+	if len(unreachable) > 0 {
+		return &UnreachableMembersError{Members: unreachable}
+	}
+	return nil
 }
 
 func generatePartyID() string {
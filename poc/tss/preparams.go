@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+)
+
+const (
+	DefaultPreParamsPoolSize = 1
+
+	// Wait before generating again after a failed attempt
+	PreParamsRetryInterval = 10 * time.Second
+)
+
+// PreParamsPool keeps the pre-parameters of upcoming ECDSA keygens. They
+// take a while to generate, so the pool generates them ahead in the
+// background. Each set is handed out once, as the Paillier keys and safe
+// primes must not be shared between keys.
+type PreParamsPool struct {
+	size   int
+	sets   []*keygen.LocalPreParams
+	mu     sync.Mutex
+	refill chan struct{}
+	logger *slog.Logger
+
+	// generate and retryInterval are replaced in tests; generating real
+	// pre-parameters takes minutes
+	generate      func(ctx context.Context) (*keygen.LocalPreParams, error)
+	retryInterval time.Duration
+}
+
+// NewPreParamsPool returns a pool that keeps size sets ready. With size 0
+// only the sets that are put into the pool are handed out.
+func NewPreParamsPool(size int, logger *slog.Logger) *PreParamsPool {
+	return &PreParamsPool{
+		size:   size,
+		refill: make(chan struct{}, 1),
+		logger: logger,
+		generate: func(ctx context.Context) (*keygen.LocalPreParams, error) {
+			return keygen.GeneratePreParamsWithContext(ctx)
+		},
+		retryInterval: PreParamsRetryInterval,
+	}
+}
+
+// Put adds a set of pre-parameters to the pool.
+func (p *PreParamsPool) Put(preParams *keygen.LocalPreParams) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sets = append(p.sets, preParams)
+}
+
+// Take removes a set of pre-parameters from the pool. It returns nil if the
+// pool is empty; keygen then generates its own first.
+func (p *PreParamsPool) Take() *keygen.LocalPreParams {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+
+	if len(p.sets) == 0 {
+		return nil
+	}
+	preParams := p.sets[0]
+	p.sets = p.sets[1:]
+	return preParams
+}
+
+// Available returns the number of sets ready for keygen.
+func (p *PreParamsPool) Available() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.sets)
+}
+
+// run fills the pool up to its size until ctx is done.
+func (p *PreParamsPool) run(ctx context.Context) {
+	if p.size <= 0 {
+		return
+	}
+
+	for {
+		if p.Available() >= p.size {
+			select {
+			case <-ctx.Done():
+				return
+			case <-p.refill:
+				continue
+			}
+		}
+
+		start := time.Now()
+		preParams, err := p.generate(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			p.logger.Warn("Error generating pre-parameters", LogKeyError, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(p.retryInterval):
+			}
+			continue
+		}
+
+		p.Put(preParams)
+		p.logger.Info("Pre-parameters generated", "duration", time.Since(start), "available", p.Available())
+	}
+}
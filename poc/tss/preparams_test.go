@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+)
+
+func TestPreParamsPoolHandsOutEachSetOnce(t *testing.T) {
+	pool := NewPreParamsPool(0, slog.Default())
+	first, second := &keygen.LocalPreParams{}, &keygen.LocalPreParams{}
+	pool.Put(first)
+	pool.Put(second)
+
+	for i, want := range []*keygen.LocalPreParams{first, second, nil} {
+		if got := pool.Take(); got != want {
+			t.Fatalf("take %d returned %p, want %p", i, got, want)
+		}
+	}
+	if got := pool.Available(); got != 0 {
+		t.Fatalf("emptied pool has %d sets available", got)
+	}
+}
+
+func TestPreParamsPoolRefills(t *testing.T) {
+	var generated atomic.Int32
+	pool := NewPreParamsPool(2, slog.Default())
+	pool.generate = func(context.Context) (*keygen.LocalPreParams, error) {
+		// The first attempt fails and is retried
+		if generated.Add(1) == 1 {
+			return nil, errors.New("timed out")
+		}
+		return &keygen.LocalPreParams{}, nil
+	}
+	pool.retryInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pool.run(ctx)
+	}()
+
+	waitFor(t, 5*time.Second, func() bool { return pool.Available() == 2 })
+	taken := pool.Take()
+	waitFor(t, 5*time.Second, func() bool { return pool.Available() == 2 })
+	if got := generated.Load(); got != 4 {
+		t.Fatalf("generated %d sets, want 4", got)
+	}
+	for range 2 {
+		if pool.Take() == taken {
+			t.Fatal("a set was handed out twice")
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pool did not stop after its context was done")
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	RegistryReloadInterval = 10 * time.Second
+)
+
+// RegistryOperator is an entry of the committee registry file.
+type RegistryOperator struct {
+	PeerID  peer.ID `json:"peer_id"`
+	Moniker string  `json:"moniker,omitempty"`
+}
+
+type registryFile struct {
+	Operators []RegistryOperator `json:"operators"`
+}
+
+// CommitteeRegistry is the allow-list of operators that may connect to this
+// node and take part in its sessions. The registry is read from a JSON file
+// and reloaded when the file changes. A nil registry allows every peer.
+type CommitteeRegistry struct {
+	path      string
+	operators map[peer.ID]RegistryOperator
+	modTime   time.Time
+	mu        sync.RWMutex
+}
+
+func LoadCommitteeRegistry(path string) (*CommitteeRegistry, error) {
+	r := &CommitteeRegistry{
+		path: path,
+	}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the registry file if it changed since the last load and
+// reports whether the allow-list was replaced. On error the previous
+// allow-list stays in effect.
+func (r *CommitteeRegistry) Reload() (bool, error) {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat registry file: %w", err)
+	}
+
+	r.mu.RLock()
+	unchanged := info.ModTime().Equal(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read registry file: %w", err)
+	}
+
+	var file registryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return false, fmt.Errorf("failed to unmarshal registry file: %w", err)
+	}
+
+	operators := make(map[peer.ID]RegistryOperator, len(file.Operators))
+	for _, op := range file.Operators {
+		if err := op.PeerID.Validate(); err != nil {
+			return false, fmt.Errorf("invalid operator peer ID %q: %w", op.PeerID, err)
+		}
+		operators[op.PeerID] = op
+	}
+
+	r.mu.Lock()
+	r.operators = operators
+	r.modTime = info.ModTime()
+	r.mu.Unlock()
+
+	return true, nil
+}
+
+func (r *CommitteeRegistry) Allows(peerID peer.ID) bool {
+	if r == nil {
+		return true
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.operators[peerID]
+	return ok
+}
+
+func (r *CommitteeRegistry) Operators() []RegistryOperator {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	operators := make([]RegistryOperator, 0, len(r.operators))
+	for _, op := range r.operators {
+		operators = append(operators, op)
+	}
+	return operators
+}
+
+// watch reloads the registry periodically and disconnects peers that were
+// removed from it.
+func (r *CommitteeRegistry) watch(ctx context.Context, h host.Host) {
+	if r == nil {
+		return
+	}
+
+	ticker := time.NewTicker(RegistryReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.refresh(h)
+		}
+	}
+}
+
+// refresh reloads the registry and, if it changed, closes the connections
+// of h to peers that are no longer registered.
+func (r *CommitteeRegistry) refresh(h host.Host) {
+	logger := nodeLogger(h.ID())
+
+	changed, err := r.Reload()
+	if err != nil {
+		logger.Warn("Error reloading committee registry", LogKeyError, err)
+		return
+	}
+	if !changed {
+		return
+	}
+
+	logger.Info("Committee registry reloaded", "operators", len(r.Operators()))
+	for _, p := range h.Network().Peers() {
+		if !r.Allows(p) {
+			logger.Info("Disconnecting peer removed from the committee registry", LogKeyPeer, p)
+			_ = h.Network().ClosePeer(p)
+		}
+	}
+}
+
+// RegistryGater is a libp2p connection gater that only lets registered
+// operators connect.
+type RegistryGater struct {
+	registry *CommitteeRegistry
+}
+
+func NewRegistryGater(registry *CommitteeRegistry) *RegistryGater {
+	return &RegistryGater{
+		registry: registry,
+	}
+}
+
+func (g *RegistryGater) InterceptPeerDial(p peer.ID) bool {
+	return g.registry.Allows(p)
+}
+
+func (g *RegistryGater) InterceptAddrDial(p peer.ID, _ ma.Multiaddr) bool {
+	return g.registry.Allows(p)
+}
+
+// InterceptAccept lets every inbound connection through; the remote peer is
+// only known once the security handshake is done.
+func (g *RegistryGater) InterceptAccept(network.ConnMultiaddrs) bool {
+	return true
+}
+
+func (g *RegistryGater) InterceptSecured(_ network.Direction, p peer.ID, _ network.ConnMultiaddrs) bool {
+	return g.registry.Allows(p)
+}
+
+func (g *RegistryGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func registryData(t *testing.T, ids ...peer.ID) []byte {
+	t.Helper()
+
+	var file registryFile
+	for _, id := range ids {
+		file.Operators = append(file.Operators, RegistryOperator{PeerID: id})
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// writeRegistry writes the registry file and moves its modification time past
+// the previous one, so that a reload notices the change.
+func writeRegistry(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	modTime := time.Now()
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime().Add(time.Second)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestRegistry(t *testing.T, ids ...peer.ID) (*CommitteeRegistry, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "registry.json")
+	writeRegistry(t, path, registryData(t, ids...))
+	registry, err := LoadCommitteeRegistry(path)
+	if err != nil {
+		t.Fatalf("failed to load registry: %v", err)
+	}
+	return registry, path
+}
+
+func TestCommitteeRegistryReload(t *testing.T) {
+	ids := randPeerIDs(t, 2)
+	registry, path := newTestRegistry(t, ids[0])
+
+	if !registry.Allows(ids[0]) || registry.Allows(ids[1]) {
+		t.Fatal("registry does not match its file")
+	}
+	if changed, err := registry.Reload(); err != nil || changed {
+		t.Fatalf("reload of an unchanged file reported changed=%t, err=%v", changed, err)
+	}
+
+	// A broken file is reported and the previous allow-list stays in effect
+	for name, data := range map[string][]byte{
+		"invalid json":    []byte("{"),
+		"invalid peer id": []byte(`{"operators":[{"peer_id":"not-a-peer"}]}`),
+	} {
+		writeRegistry(t, path, data)
+		if _, err := registry.Reload(); err == nil {
+			t.Fatalf("reload of a file with %s succeeded", name)
+		}
+		if !registry.Allows(ids[0]) || registry.Allows(ids[1]) {
+			t.Fatalf("reload of a file with %s replaced the allow-list", name)
+		}
+	}
+
+	writeRegistry(t, path, registryData(t, ids[1]))
+	if changed, err := registry.Reload(); err != nil || !changed {
+		t.Fatalf("reload of a changed file reported changed=%t, err=%v", changed, err)
+	}
+	if registry.Allows(ids[0]) || !registry.Allows(ids[1]) {
+		t.Fatal("reload did not replace the allow-list")
+	}
+	if ops := registry.Operators(); len(ops) != 1 || ops[0].PeerID != ids[1] {
+		t.Fatalf("registry lists operators %v, want %s", ops, ids[1])
+	}
+}
+
+func TestNilCommitteeRegistryAllowsEveryone(t *testing.T) {
+	var registry *CommitteeRegistry
+	if !registry.Allows(randPeerIDs(t, 1)[0]) {
+		t.Fatal("nil registry rejected a peer")
+	}
+}
+
+func TestRegistryGater(t *testing.T) {
+	ids := randPeerIDs(t, 2)
+	registry, _ := newTestRegistry(t, ids[0])
+	gater := NewRegistryGater(registry)
+	addr := ma.StringCast("/ip4/127.0.0.1/tcp/4001")
+
+	for _, tc := range []struct {
+		peerID peer.ID
+		want   bool
+	}{
+		{ids[0], true},
+		{ids[1], false},
+	} {
+		if got := gater.InterceptPeerDial(tc.peerID); got != tc.want {
+			t.Errorf("InterceptPeerDial(%s) = %t, want %t", tc.peerID, got, tc.want)
+		}
+		if got := gater.InterceptAddrDial(tc.peerID, addr); got != tc.want {
+			t.Errorf("InterceptAddrDial(%s) = %t, want %t", tc.peerID, got, tc.want)
+		}
+		for _, dir := range []network.Direction{network.DirInbound, network.DirOutbound} {
+			if got := gater.InterceptSecured(dir, tc.peerID, nil); got != tc.want {
+				t.Errorf("InterceptSecured(%s, %s) = %t, want %t", dir, tc.peerID, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestMessageRouterRejectsUnregisteredSenders(t *testing.T) {
+	h := newTestHost(t)
+	registered, unregistered := h.ID(), randPeerIDs(t, 1)[0]
+	registry, _ := newTestRegistry(t, registered)
+	mr := NewMessageRouter(h, NewReputationStore(), registry, NewMetrics())
+
+	for _, tc := range []struct {
+		from peer.ID
+		want pubsub.ValidationResult
+	}{
+		{registered, pubsub.ValidationAccept},
+		{unregistered, pubsub.ValidationReject},
+	} {
+		msg := &pubsub.Message{Message: &pb.Message{From: []byte(tc.from)}}
+		if got := mr.validate(context.Background(), registered, msg); got != tc.want {
+			t.Fatalf("topic validator returned %v for a message from %s, want %v", got, tc.from, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		from peer.ID
+		want bool
+	}{
+		{registered, true},
+		{unregistered, false},
+	} {
+		data, err := json.Marshal(&Message{Type: MessageTypeKeyGeneration, From: tc.from})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := mr.decode(data, tc.from); ok != tc.want {
+			t.Fatalf("message from %s dispatched=%t, want %t", tc.from, ok, tc.want)
+		}
+	}
+}
+
+func TestCommitteeRegistryRefreshDisconnectsRemovedPeers(t *testing.T) {
+	mn, err := mocknet.FullMeshConnected(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mn.Close() })
+	hosts := mn.Hosts()
+	self, kept, removed := hosts[0], hosts[1], hosts[2]
+
+	registry, path := newTestRegistry(t, self.ID(), kept.ID(), removed.ID())
+
+	// Nothing is closed while the file is unchanged
+	registry.refresh(self)
+	if self.Network().Connectedness(removed.ID()) != network.Connected {
+		t.Fatal("peer disconnected without a registry change")
+	}
+
+	writeRegistry(t, path, registryData(t, self.ID(), kept.ID()))
+	registry.refresh(self)
+
+	if self.Network().Connectedness(removed.ID()) == network.Connected {
+		t.Fatal("peer removed from the registry is still connected")
+	}
+	if self.Network().Connectedness(kept.ID()) != network.Connected {
+		t.Fatal("registered peer was disconnected")
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+const (
+	LivenessInterval = 30 * time.Second
+	LivenessTimeout  = 5 * time.Second
+
+	// A peer is considered offline after this many consecutive failed pings
+	MaxPingFailures = 3
+	// Peers that have not been seen for this long are not considered for new parties
+	LivenessTTL = 5 * time.Minute
+
+	// Weight of the newest sample in the latency moving average
+	latencySmoothing = 0.2
+)
+
+type PeerReputation struct {
+	PeerID            peer.ID       `json:"peer_id"`
+	LastSeen          time.Time     `json:"last_seen"`
+	PingFailures      int           `json:"ping_failures"`
+	Latency           time.Duration `json:"latency"`
+	SessionsStarted   int           `json:"sessions_started"`
+	SessionsCompleted int           `json:"sessions_completed"`
+	BlameCount        int           `json:"blame_count"`
+	InvalidMessages   int           `json:"invalid_messages"`
+}
+
+// CompletionRate is the share of started sessions that the peer completed.
+// Peers without history get the benefit of the doubt.
+func (r PeerReputation) CompletionRate() float64 {
+	if r.SessionsStarted == 0 {
+		return 1
+	}
+	return float64(r.SessionsCompleted) / float64(r.SessionsStarted)
+}
+
+// Alive reports whether the peer answered recently and is not failing pings.
+func (r PeerReputation) Alive() bool {
+	return r.PingFailures < MaxPingFailures && time.Since(r.LastSeen) < LivenessTTL
+}
+
+// Healthy reports whether the peer is fit to be selected into a new party.
+func (r PeerReputation) Healthy() bool {
+	return r.Alive() && r.Score() >= 0
+}
+
+// Score combines the collected statistics into a single value. Completed
+// sessions raise the score; blames and invalid messages lower it much faster
+// than good behaviour can restore it. Latency is only used as a tie-breaker.
+func (r PeerReputation) Score() float64 {
+	score := 10 * r.CompletionRate()
+	score -= 5 * float64(r.BlameCount)
+	score -= 1 * float64(r.InvalidMessages)
+	score -= float64(r.PingFailures)
+	score -= r.Latency.Seconds()
+	return score
+}
+
+type ReputationStore struct {
+	peers map[peer.ID]*PeerReputation
+	mu    sync.RWMutex
+}
+
+func NewReputationStore() *ReputationStore {
+	return &ReputationStore{
+		peers: make(map[peer.ID]*PeerReputation),
+	}
+}
+
+// get returns the record for the peer, creating it if necessary. Must be
+// called with the lock held.
+func (rs *ReputationStore) get(peerID peer.ID) *PeerReputation {
+	rep, exists := rs.peers[peerID]
+	if !exists {
+		rep = &PeerReputation{PeerID: peerID}
+		rs.peers[peerID] = rep
+	}
+	return rep
+}
+
+func (rs *ReputationStore) update(peerID peer.ID, fn func(rep *PeerReputation)) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	fn(rs.get(peerID))
+}
+
+func (rs *ReputationStore) RecordSeen(peerID peer.ID) {
+	rs.update(peerID, func(rep *PeerReputation) {
+		rep.LastSeen = time.Now()
+	})
+}
+
+func (rs *ReputationStore) RecordPing(peerID peer.ID, rtt time.Duration) {
+	rs.update(peerID, func(rep *PeerReputation) {
+		rep.LastSeen = time.Now()
+		rep.PingFailures = 0
+		if rep.Latency == 0 {
+			rep.Latency = rtt
+			return
+		}
+		rep.Latency = time.Duration(latencySmoothing*float64(rtt) + (1-latencySmoothing)*float64(rep.Latency))
+	})
+}
+
+func (rs *ReputationStore) RecordPingFailure(peerID peer.ID) {
+	rs.update(peerID, func(rep *PeerReputation) {
+		rep.PingFailures++
+	})
+}
+
+func (rs *ReputationStore) RecordSessionStarted(peerID peer.ID) {
+	rs.update(peerID, func(rep *PeerReputation) {
+		rep.SessionsStarted++
+	})
+}
+
+func (rs *ReputationStore) RecordSessionCompleted(peerID peer.ID) {
+	rs.update(peerID, func(rep *PeerReputation) {
+		rep.SessionsCompleted++
+	})
+}
+
+func (rs *ReputationStore) RecordBlame(peerID peer.ID) {
+	rs.update(peerID, func(rep *PeerReputation) {
+		rep.BlameCount++
+	})
+}
+
+func (rs *ReputationStore) RecordInvalidMessage(peerID peer.ID) {
+	rs.update(peerID, func(rep *PeerReputation) {
+		rep.InvalidMessages++
+	})
+}
+
+func (rs *ReputationStore) Get(peerID peer.ID) (PeerReputation, bool) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	rep, exists := rs.peers[peerID]
+	if !exists {
+		return PeerReputation{PeerID: peerID}, false
+	}
+	return *rep, true
+}
+
+func (rs *ReputationStore) All() []PeerReputation {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	reps := make([]PeerReputation, 0, len(rs.peers))
+	for _, rep := range rs.peers {
+		reps = append(reps, *rep)
+	}
+	sort.Slice(reps, func(i, j int) bool {
+		return reps[i].Score() > reps[j].Score()
+	})
+	return reps
+}
+
+// Healthy returns the known healthy peers ordered from best to worst.
+func (rs *ReputationStore) Healthy() []peer.ID {
+	var peers []peer.ID
+	for _, rep := range rs.All() {
+		if rep.Healthy() {
+			peers = append(peers, rep.PeerID)
+		}
+	}
+	return peers
+}
+
+// AppSpecificScore feeds the reputation into the gossipsub peer score so that
+// misbehaving peers are pruned from the mesh and eventually graylisted.
+func (rs *ReputationStore) AppSpecificScore(peerID peer.ID) float64 {
+	rep, exists := rs.Get(peerID)
+	if !exists {
+		return 0
+	}
+	// Only misbehaviour is propagated. Gossipsub prunes peers with a negative
+	// score from the mesh, so penalizing latency or failed sessions would hold
+	// back every broadcast to a slow but honest peer until the next heartbeat.
+	return -5*float64(rep.BlameCount) - float64(rep.InvalidMessages)
+}
+
+// PeerScoreParams returns gossipsub peer scoring parameters backed by the store.
+func (rs *ReputationStore) PeerScoreParams() (*pubsub.PeerScoreParams, *pubsub.PeerScoreThresholds) {
+	params := &pubsub.PeerScoreParams{
+		Topics:            make(map[string]*pubsub.TopicScoreParams),
+		AppSpecificScore:  rs.AppSpecificScore,
+		AppSpecificWeight: 10,
+		DecayInterval:     time.Second,
+		DecayToZero:       0.01,
+		RetainScore:       time.Hour,
+	}
+	thresholds := &pubsub.PeerScoreThresholds{
+		GossipThreshold:   -100,
+		PublishThreshold:  -200,
+		GraylistThreshold: -300,
+	}
+	return params, thresholds
+}
+
+// monitorLiveness periodically pings every connected peer and records the
+// round-trip time or the failure.
+func (rs *ReputationStore) monitorLiveness(ctx context.Context, h host.Host) {
+	ticker := time.NewTicker(LivenessInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, p := range h.Network().Peers() {
+				go rs.Ping(ctx, h, p)
+			}
+		}
+	}
+}
+
+// Ping measures the round-trip time to the peer and records the result.
+func (rs *ReputationStore) Ping(ctx context.Context, h host.Host, peerID peer.ID) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, LivenessTimeout)
+	defer cancel()
+
+	select {
+	case res, ok := <-ping.Ping(ctx, h, peerID):
+		if !ok {
+			rs.RecordPingFailure(peerID)
+			return 0, ctx.Err()
+		}
+		if res.Error != nil {
+			rs.RecordPingFailure(peerID)
+			return 0, res.Error
+		}
+		rs.RecordPing(peerID, res.RTT)
+		return res.RTT, nil
+	case <-ctx.Done():
+		rs.RecordPingFailure(peerID)
+		return 0, ctx.Err()
+	}
+}
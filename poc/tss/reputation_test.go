@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+)
+
+func randPeerIDs(t *testing.T, n int) []peer.ID {
+	t.Helper()
+
+	ids := make([]peer.ID, n)
+	for i := range ids {
+		ids[i] = test.RandPeerIDFatal(t)
+	}
+	return ids
+}
+
+func TestReputationScore(t *testing.T) {
+	fresh := PeerReputation{LastSeen: time.Now()}
+	if got := fresh.CompletionRate(); got != 1 {
+		t.Fatalf("peer without sessions has completion rate %v, want 1", got)
+	}
+	if got := fresh.Score(); got != 10 {
+		t.Fatalf("fresh peer has score %v, want 10", got)
+	}
+	if !fresh.Healthy() {
+		t.Fatal("fresh peer is not healthy")
+	}
+
+	halfDone := PeerReputation{LastSeen: time.Now(), SessionsStarted: 4, SessionsCompleted: 2}
+	if got := halfDone.Score(); got != 5 {
+		t.Fatalf("peer completing half its sessions has score %v, want 5", got)
+	}
+
+	blamed := PeerReputation{LastSeen: time.Now(), BlameCount: 3}
+	if blamed.Healthy() {
+		t.Fatalf("peer blamed 3 times is healthy with score %v", blamed.Score())
+	}
+	invalid := PeerReputation{LastSeen: time.Now(), InvalidMessages: 11}
+	if invalid.Healthy() {
+		t.Fatalf("peer sending 11 invalid messages is healthy with score %v", invalid.Score())
+	}
+
+	offline := PeerReputation{LastSeen: time.Now(), PingFailures: MaxPingFailures}
+	if offline.Alive() || offline.Healthy() {
+		t.Fatal("peer failing pings is alive")
+	}
+	stale := PeerReputation{LastSeen: time.Now().Add(-LivenessTTL)}
+	if stale.Alive() {
+		t.Fatal("peer not seen for the liveness TTL is alive")
+	}
+}
+
+func TestReputationStore(t *testing.T) {
+	ids := randPeerIDs(t, 4)
+	good, slow, blamed, offline := ids[0], ids[1], ids[2], ids[3]
+
+	rs := NewReputationStore()
+	rs.RecordPing(good, 10*time.Millisecond)
+	rs.RecordPing(slow, 2*time.Second)
+	rs.RecordPing(blamed, 10*time.Millisecond)
+	rs.RecordBlame(blamed)
+	rs.RecordBlame(blamed)
+	rs.RecordBlame(blamed)
+	rs.RecordPing(offline, 10*time.Millisecond)
+	for range MaxPingFailures {
+		rs.RecordPingFailure(offline)
+	}
+
+	healthy := rs.Healthy()
+	if len(healthy) != 2 || healthy[0] != good || healthy[1] != slow {
+		t.Fatalf("healthy peers are %v, want [%s %s]", healthy, good, slow)
+	}
+
+	// The latency is a moving average, one slow ping barely moves it
+	rs.RecordPing(good, 510*time.Millisecond)
+	if rep, _ := rs.Get(good); rep.Latency != 110*time.Millisecond {
+		t.Fatalf("latency is %v after pings of 10ms and 510ms, want 110ms", rep.Latency)
+	}
+	// A successful ping resets the failures
+	rs.RecordPing(offline, 10*time.Millisecond)
+	if rep, _ := rs.Get(offline); !rep.Alive() {
+		t.Fatal("peer answering a ping again is not alive")
+	}
+
+	// Gossipsub only hears about misbehaviour, not latency or liveness
+	for _, p := range []peer.ID{good, slow, offline} {
+		if got := rs.AppSpecificScore(p); got != 0 {
+			t.Fatalf("well-behaved peer has app-specific score %v, want 0", got)
+		}
+	}
+	if got := rs.AppSpecificScore(blamed); got != -15 {
+		t.Fatalf("peer blamed 3 times has app-specific score %v, want -15", got)
+	}
+	if _, exists := rs.Get(test.RandPeerIDFatal(t)); exists {
+		t.Fatal("unknown peer has a reputation")
+	}
+}
+
+func TestHandlerErrorsPenalizeOnlyInvalidMessages(t *testing.T) {
+	rs := NewReputationStore()
+	mr := NewMessageRouter(newTestHost(t), rs, nil, NewMetrics())
+	mr.RegisterHandler(MessageTypeKeyGeneration, func(*Message) error {
+		return errors.New("party not found")
+	})
+	mr.RegisterHandler(MessageTypeSigning, func(msg *Message) error {
+		return invalidMessage("message from non-member %s", msg.From)
+	})
+
+	sender := test.RandPeerIDFatal(t)
+	mr.handle(&Message{Type: MessageTypeKeyGeneration, From: sender})
+	if rep, _ := rs.Get(sender); rep.InvalidMessages != 0 {
+		t.Fatalf("local handler error recorded %d invalid messages", rep.InvalidMessages)
+	}
+	mr.handle(&Message{Type: MessageTypeSigning, From: sender})
+	if rep, _ := rs.Get(sender); rep.InvalidMessages != 1 {
+		t.Fatalf("protocol violation recorded %d invalid messages, want 1", rep.InvalidMessages)
+	}
+}
+
+func TestSessionStartRecordedWhenActive(t *testing.T) {
+	rs := NewReputationStore()
+	pm := NewPartyManager(NewMessageRouter(newTestHost(t), rs, nil, NewMetrics()), rs)
+
+	members := randPeerIDs(t, 3)
+	party := &Party{ID: "party", Initiator: members[0], Members: members, Threshold: 2, Operation: TSSOperationSigning}
+	if err := pm.JoinParty(members[1], party); err != nil {
+		t.Fatalf("failed to join party: %v", err)
+	}
+	if rep, _ := rs.Get(members[0]); rep.SessionsStarted != 0 {
+		t.Fatalf("joining a party recorded %d started sessions", rep.SessionsStarted)
+	}
+
+	for _, status := range []PartyStatus{PartyStatusActive, PartyStatusActive, PartyStatusCompleted} {
+		if err := pm.UpdatePartyStatus(party.ID, status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, member := range members {
+		rep, _ := rs.Get(member)
+		if rep.SessionsStarted != 1 || rep.SessionsCompleted != 1 {
+			t.Fatalf("member has %d started and %d completed sessions, want 1 and 1", rep.SessionsStarted, rep.SessionsCompleted)
+		}
+	}
+
+	// A party that never starts leaves the completion rate alone
+	other := &Party{ID: "other", Initiator: members[0], Members: members, Threshold: 2, Operation: TSSOperationSigning}
+	if err := pm.JoinParty(members[1], other); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.UpdatePartyStatus(other.ID, PartyStatusFailed); err != nil {
+		t.Fatal(err)
+	}
+	if rep, _ := rs.Get(members[0]); rep.CompletionRate() != 1 {
+		t.Fatalf("unstarted party changed the completion rate to %v", rep.CompletionRate())
+	}
+
+	invalid := &Party{ID: "invalid", Initiator: members[0], Members: members, Threshold: 3, Operation: TSSOperationSigning}
+	var invalidErr *InvalidMessageError
+	if err := pm.JoinParty(members[1], invalid); !errors.As(err, &invalidErr) {
+		t.Fatalf("expected an InvalidMessageError joining a party with threshold 3 of 3, got %v", err)
+	}
+}
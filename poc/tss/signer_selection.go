@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bnb-chain/tss-lib/v2/common"
+	"github.com/keruch/thesis/poc/session"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// Signers that have not delivered their round 1 messages by then are
+	// replaced by other holders
+	SigningRound1Timeout = 20 * time.Second
+	MaxSigningAttempts   = 3
+)
+
+type signerCandidate struct {
+	peerID peer.ID
+	rtt    time.Duration
+	score  float64
+}
+
+// Sign signs digest with the key keyID. Only threshold+1 holders take part:
+// this node and the other healthy holders with the best reputation. Holders
+// that cannot be reached or stall the first round are excluded and signing is
+// retried with a different subset.
+//
+// ECDSA keys sign 32-byte digests. EdDSA keys sign digest as a whole
+// message, as Ed25519 does, so it may be of any length.
+func (n *Node) Sign(ctx context.Context, keyID string, digest []byte) (*common.SignatureData, error) {
+	return n.SignDerived(ctx, keyID, nil, digest)
+}
+
+// SignDerived signs digest like Sign, with the child key at path of the ECDSA
+// key keyID. The signers derive the child key's shares themselves, the
+// signature verifies against the public key returned by DeriveKey.
+func (n *Node) SignDerived(ctx context.Context, keyID string, path DerivationPath, digest []byte) (*common.SignatureData, error) {
+	record, err := n.keyStore.Load(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if !record.IsHolder(n.host.ID()) {
+		return nil, fmt.Errorf("node does not hold a share of key %s", keyID)
+	}
+	if err := record.CheckMessage(digest); err != nil {
+		return nil, err
+	}
+	if len(path) > 0 {
+		if _, _, err := record.DeriveKey(path); err != nil {
+			return nil, err
+		}
+	}
+
+	excluded := make(map[peer.ID]struct{})
+	var lastErr error
+	for attempt := 1; attempt <= MaxSigningAttempts; attempt++ {
+		signers, err := n.selectSigners(ctx, record, excluded)
+		if err != nil {
+			if lastErr != nil {
+				return nil, fmt.Errorf("%w (previous attempt: %v)", err, lastErr)
+			}
+			return nil, err
+		}
+
+		signature, err := n.signWith(ctx, record, signers, path, digest)
+		if err == nil {
+			return signature, nil
+		}
+
+		var unreachableErr *UnreachableMembersError
+		var timeoutErr *session.RoundTimeoutError
+		switch {
+		case errors.As(err, &unreachableErr):
+			for _, p := range unreachableErr.Members {
+				excluded[p] = struct{}{}
+			}
+		case errors.As(err, &timeoutErr) && timeoutErr.Round <= 1:
+			for _, id := range timeoutErr.Missing {
+				if p, err := peer.Decode(id); err == nil {
+					excluded[p] = struct{}{}
+				}
+			}
+		default:
+			return nil, err
+		}
+
+		n.logger.Warn("Signing attempt failed, retrying without unresponsive holders", "attempt", attempt, "key_id", keyID, "excluded", len(excluded), LogKeyError, err)
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("signing failed after %d attempts: %w", MaxSigningAttempts, lastErr)
+}
+
+func (n *Node) signWith(ctx context.Context, record *KeyShareRecord, signers []peer.ID, path DerivationPath, digest []byte) (*common.SignatureData, error) {
+	party, err := n.partyMgr.CreateSigningParty(ctx, n.host.ID(), signers, record.Threshold, record.KeyID, path, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := n.startSession(ctx, party)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-s.FirstRoundDone():
+	case <-time.After(SigningRound1Timeout):
+		s.Cancel()
+		// Let the responsive signers stop right away instead of waiting for their own timeout
+		go func() {
+			if err := n.tssHandler.SendControl(n.ctx, party, SessionActionAbort); err != nil {
+				n.logger.Warn("Error aborting party", LogKeyPartyID, party.ID, LogKeyError, err)
+			}
+		}()
+	case <-ctx.Done():
+		s.Cancel()
+		return nil, ctx.Err()
+	}
+
+	if err := s.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.Signature(), nil
+}
+
+// selectSigners returns this node followed by the threshold healthy holders of
+// the key with the best reputation, skipping excluded holders.
+func (n *Node) selectSigners(ctx context.Context, record *KeyShareRecord, excluded map[peer.ID]struct{}) ([]peer.ID, error) {
+	var (
+		candidates []signerCandidate
+		mu         sync.Mutex
+		wg         sync.WaitGroup
+	)
+
+	for _, holder := range record.Holders {
+		if holder == n.host.ID() {
+			continue
+		}
+		if _, skip := excluded[holder]; skip {
+			continue
+		}
+
+		wg.Add(1)
+		go func(holder peer.ID) {
+			defer wg.Done()
+
+			rtt, err := n.reputation.Ping(ctx, n.host, holder)
+			if err != nil {
+				return
+			}
+			rep, _ := n.reputation.Get(holder)
+			if !rep.Healthy() {
+				return
+			}
+
+			mu.Lock()
+			candidates = append(candidates, signerCandidate{peerID: holder, rtt: rtt, score: rep.Score()})
+			mu.Unlock()
+		}(holder)
+	}
+	wg.Wait()
+
+	if len(candidates) < record.Threshold {
+		return nil, fmt.Errorf("not enough healthy signers for key %s: have %d, need %d", record.KeyID, len(candidates), record.Threshold)
+	}
+
+	rankSigners(candidates)
+
+	signers := []peer.ID{n.host.ID()}
+	for _, c := range candidates[:record.Threshold] {
+		signers = append(signers, c.peerID)
+	}
+
+	return signers, nil
+}
+
+// rankSigners orders the candidates by their reputation score, best first. A
+// fast holder that failed or misbehaved in earlier sessions is only picked
+// after the clean ones; the round trip decides between equal scores.
+func rankSigners(candidates []signerCandidate) {
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].rtt < candidates[j].rtt
+	})
+}
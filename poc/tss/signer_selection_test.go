@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+// keyWithoutShares stores a record of a key held by every node of net with
+// the first node. The record carries no share, so signing with it fails when
+// the initiator starts its session, after the signers have been selected and
+// the party has been formed.
+func keyWithoutShares(t *testing.T, net *testNetwork, threshold int) *KeyShareRecord {
+	t.Helper()
+
+	record := &KeyShareRecord{KeyID: "key-without-shares", Threshold: threshold, Holders: net.peerIDs()}
+	if err := net.nodes[0].keyStore.Save(record); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+// setLatency delays the traffic between two nodes in both directions.
+func setLatency(net *testNetwork, a, b *Node, latency time.Duration) {
+	links := append(net.mn.LinksBetweenPeers(a.host.ID(), b.host.ID()), net.mn.LinksBetweenPeers(b.host.ID(), a.host.ID())...)
+	for _, link := range links {
+		link.SetOptions(mocknet.LinkOptions{Latency: latency})
+	}
+}
+
+// refuseParties makes the node refuse every direct message, including party
+// announcements, while it keeps answering pings.
+func refuseParties(node *Node) {
+	node.host.RemoveStreamHandler(DirectProtocolID)
+}
+
+// interceptMessages routes the messages of msgType received by node through
+// intercept until the test ends. Intercept may pass them on to handle.
+func interceptMessages(t *testing.T, node *Node, msgType MessageType, intercept func(msg *Message, handle MessageHandler) error) {
+	t.Helper()
+
+	mr := node.msgRouter
+	mr.mu.Lock()
+	handle := mr.handlers[msgType]
+	mr.mu.Unlock()
+
+	mr.RegisterHandler(msgType, func(msg *Message) error {
+		return intercept(msg, handle)
+	})
+	t.Cleanup(func() { mr.RegisterHandler(msgType, handle) })
+}
+
+// setSigningRoundTimeout shortens the round timeout of the node's signing
+// sessions until the test ends.
+func setSigningRoundTimeout(t *testing.T, node *Node, timeout time.Duration) {
+	t.Helper()
+
+	th := node.tssHandler
+	th.mu.Lock()
+	prev := th.timeouts.Signing.Round
+	th.timeouts.Signing.Round = timeout
+	th.mu.Unlock()
+
+	t.Cleanup(func() {
+		th.mu.Lock()
+		th.timeouts.Signing.Round = prev
+		th.mu.Unlock()
+	})
+}
+
+func TestRankSigners(t *testing.T) {
+	ids := randPeerIDs(t, 4)
+	candidates := []signerCandidate{
+		{peerID: ids[0], rtt: 30 * time.Millisecond, score: 10},
+		{peerID: ids[1], rtt: 10 * time.Millisecond, score: 2},
+		{peerID: ids[2], rtt: 20 * time.Millisecond, score: 5},
+		{peerID: ids[3], rtt: 20 * time.Millisecond, score: 10},
+	}
+
+	// The fastest holder has the worst score and comes last
+	rankSigners(candidates)
+	for i, want := range []peer.ID{ids[3], ids[0], ids[2], ids[1]} {
+		if candidates[i].peerID != want {
+			t.Fatalf("candidate %d is %s, want %s", i, candidates[i].peerID, want)
+		}
+	}
+}
+
+func TestSelectSigners(t *testing.T) {
+	net := newTestNetwork(t, 5)
+	self, slow, fast, blamed, unreachable := net.nodes[0], net.nodes[1], net.nodes[2], net.nodes[3], net.nodes[4]
+
+	setLatency(net, self, slow, 30*time.Millisecond)
+	setLatency(net, self, fast, 5*time.Millisecond)
+	for range 3 {
+		self.reputation.RecordBlame(blamed.host.ID())
+	}
+	if err := net.mn.UnlinkPeers(self.host.ID(), unreachable.host.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if err := net.mn.DisconnectPeers(self.host.ID(), unreachable.host.ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	record := &KeyShareRecord{KeyID: "key", Threshold: 2, Holders: net.peerIDs()}
+	ctx := testContext(t, 30*time.Second)
+
+	// Unhealthy and unreachable holders are skipped. The others have the same
+	// history, so their round trip decides
+	signers, err := self.selectSigners(ctx, record, map[peer.ID]struct{}{})
+	if err != nil {
+		t.Fatalf("failed to select signers: %v", err)
+	}
+	want := []peer.ID{self.host.ID(), fast.host.ID(), slow.host.ID()}
+	if fmt.Sprint(signers) != fmt.Sprint(want) {
+		t.Fatalf("selected signers %v, want %v", signers, want)
+	}
+
+	excluded := map[peer.ID]struct{}{fast.host.ID(): {}}
+	if _, err := self.selectSigners(ctx, record, excluded); err == nil || !strings.Contains(err.Error(), "have 1, need 2") {
+		t.Fatalf("expected too few signers without the excluded holder, got %v", err)
+	}
+}
+
+func TestSelectSignersPrefersReputationOverLatency(t *testing.T) {
+	net := newTestNetwork(t, 3)
+	self, fast, clean := net.nodes[0], net.nodes[1], net.nodes[2]
+
+	// The fast holder was blamed once, which leaves it healthy but behind the clean one
+	setLatency(net, self, clean, 30*time.Millisecond)
+	self.reputation.RecordBlame(fast.host.ID())
+
+	record := &KeyShareRecord{KeyID: "key", Threshold: 1, Holders: net.peerIDs()}
+	signers, err := self.selectSigners(testContext(t, 30*time.Second), record, map[peer.ID]struct{}{})
+	if err != nil {
+		t.Fatalf("failed to select signers: %v", err)
+	}
+	want := []peer.ID{self.host.ID(), clean.host.ID()}
+	if fmt.Sprint(signers) != fmt.Sprint(want) {
+		t.Fatalf("selected signers %v, want %v", signers, want)
+	}
+}
+
+func TestSignRetriesWithoutUnreachableHolders(t *testing.T) {
+	net := newTestNetwork(t, 5)
+	initiator := net.nodes[0]
+	record := keyWithoutShares(t, net, 2)
+
+	// The fastest holders are picked first but refuse the party
+	for _, node := range net.nodes[1:3] {
+		refuseParties(node)
+	}
+	for _, node := range net.nodes[3:] {
+		setLatency(net, initiator, node, 20*time.Millisecond)
+	}
+
+	digest := sha256.Sum256([]byte("retry"))
+	_, err := initiator.Sign(testContext(t, 30*time.Second), record.KeyID, digest[:])
+	var unreachableErr *UnreachableMembersError
+	if err == nil || errors.As(err, &unreachableErr) {
+		t.Fatalf("expected the second attempt to reach its signers, got %v", err)
+	}
+
+	// The second attempt formed its party with the slower holders
+	want := fmt.Sprint([]peer.ID{initiator.host.ID(), net.nodes[3].host.ID(), net.nodes[4].host.ID()})
+	for _, node := range net.nodes[3:] {
+		parties := node.partyMgr.GetAllParties()
+		if len(parties) != 1 || fmt.Sprint(parties[0].Members) != want {
+			t.Fatalf("replacement signer %s joined %d parties, want one with members %s", node.host.ID(), len(parties), want)
+		}
+	}
+}
+
+func TestSignGivesUpAfterMaxSigningAttempts(t *testing.T) {
+	net := newTestNetwork(t, 2*MaxSigningAttempts+1)
+	initiator := net.nodes[0]
+	record := keyWithoutShares(t, net, 2)
+
+	// Every attempt selects two new holders, none of which can be reached
+	for _, node := range net.nodes[1:] {
+		refuseParties(node)
+	}
+
+	digest := sha256.Sum256([]byte("give up"))
+	_, err := initiator.Sign(testContext(t, 30*time.Second), record.KeyID, digest[:])
+	var unreachableErr *UnreachableMembersError
+	if !errors.As(err, &unreachableErr) {
+		t.Fatalf("expected the last attempt to fail on unreachable holders, got %v", err)
+	}
+	if want := fmt.Sprintf("signing failed after %d attempts", MaxSigningAttempts); !strings.HasPrefix(err.Error(), want) {
+		t.Fatalf("expected %q, got %v", want, err)
+	}
+}
+
+func TestSignExcludesSignersStallingFirstRound(t *testing.T) {
+	key := ecdsaTestKey(t)
+	initiator, stalled := key.initiator(), key.net.nodes[2]
+
+	// The stalled holder ignores the start message and never sends its round 1 messages
+	setSigningRoundTimeout(t, initiator, 2*time.Second)
+	interceptMessages(t, stalled, MessageTypeSigning, func(*Message, MessageHandler) error {
+		return nil
+	})
+
+	digest := sha256.Sum256([]byte("stalled"))
+	_, err := initiator.Sign(testContext(t, time.Minute), key.record.KeyID, digest[:])
+
+	// Without the stalled holder there are not enough signers left for another attempt
+	if err == nil || !strings.Contains(err.Error(), "have 1, need 2") {
+		t.Fatalf("expected too few signers after excluding the stalled holder, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "timed out in round 1") || !strings.Contains(err.Error(), stalled.host.ID().String()) {
+		t.Fatalf("expected the round 1 timeout of %s as the previous attempt, got %v", stalled.host.ID(), err)
+	}
+}
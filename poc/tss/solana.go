@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/keruch/thesis/poc/chain/solana"
+)
+
+// SolanaSignature is the signature of an EdDSA committee key on a Solana
+// message, together with the transaction carrying it.
+type SolanaSignature struct {
+	Address     string
+	Signature   []byte
+	Transaction []byte
+}
+
+// SignSolanaMessage signs msg with the EdDSA key keyID, which must be one of
+// the message's required signers.
+func (n *Node) SignSolanaMessage(ctx context.Context, keyID string, msg *solana.Message) (*SolanaSignature, error) {
+	record, err := n.keyStore.Load(keyID)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := record.Ed25519PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := msg.SignerIndex(pub); err != nil {
+		return nil, err
+	}
+
+	data, err := n.Sign(ctx, keyID, msg.Raw)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := solana.Signature(msg.Raw, data, pub)
+	if err != nil {
+		return nil, fmt.Errorf("invalid threshold signature: %w", err)
+	}
+	tx, err := solana.SignedTransaction(msg, pub, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SolanaSignature{
+		Address:     solana.Address(pub),
+		Signature:   sig,
+		Transaction: tx,
+	}, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/keruch/thesis/poc/session"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TraceExporterNone   = "none"
+	TraceExporterStdout = "stdout"
+	TraceExporterOTLP   = "otlp"
+
+	DefaultOTLPEndpoint = "localhost:4318"
+
+	tracerName = "github.com/keruch/thesis/poc/tss"
+)
+
+var tracer = otel.Tracer(tracerName)
+
+type TracingConfig struct {
+	// Exporter is one of "none", "stdout" or "otlp"
+	Exporter string
+	// OTLPEndpoint is the host:port of the collector's OTLP/HTTP receiver
+	OTLPEndpoint string
+}
+
+func DefaultTracingConfig() TracingConfig {
+	return TracingConfig{
+		Exporter:     TraceExporterNone,
+		OTLPEndpoint: DefaultOTLPEndpoint,
+	}
+}
+
+// setupTracing installs the global tracer provider and the W3C trace context
+// propagator. The returned function flushes and stops the exporter.
+func setupTracing(ctx context.Context, cfg TracingConfig, nodeID string) (func(context.Context) error, error) {
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+	switch cfg.Exporter {
+	case TraceExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case TraceExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case TraceExporterOTLP:
+		// A local collector is expected, so the connection is not encrypted
+		exporter, err = otlptracehttp.New(ctx,
+			otlptracehttp.WithEndpoint(cfg.OTLPEndpoint),
+			otlptracehttp.WithInsecure(),
+		)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter: %s", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", cfg.Exporter, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "tssd"),
+			attribute.String("service.instance.id", nodeID),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
+
+// startMessageSpan starts the span of sending or receiving a session message.
+func startMessageSpan(ctx context.Context, name string, msg *session.Message, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs,
+		attribute.String("tss.session_id", msg.SessionID),
+		attribute.String("tss.from", msg.From),
+		attribute.Bool("tss.broadcast", msg.Broadcast),
+		attribute.Int("tss.size", len(msg.WireBytes)),
+	)
+	if msg.IsEcho() {
+		attrs = append(attrs, attribute.String("tss.echo_type", msg.EchoType))
+	}
+	return tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// endSpan ends the span, marking it failed if err is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// injectTrace stores the trace context of ctx in the message envelope.
+func injectTrace(ctx context.Context, msg *Message) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) > 0 {
+		msg.Trace = carrier
+	}
+}
+
+// extractTrace returns ctx carrying the sender's trace context from the message envelope.
+func extractTrace(ctx context.Context, msg *Message) context.Context {
+	if len(msg.Trace) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(msg.Trace))
+}
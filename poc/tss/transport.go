@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/keruch/thesis/poc/session"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Libp2pTransport carries session messages between nodes: messages to a
+// single party go over direct streams and broadcasts over the gossipsub topic.
+// tss-lib party IDs are the string form of the members' peer IDs.
+type Libp2pTransport struct {
+	*session.Mailboxes
+
+	self     peer.ID
+	partyMgr *PartyManager
+	send     SessionSender
+}
+
+func NewLibp2pTransport(self peer.ID, partyMgr *PartyManager, send SessionSender) *Libp2pTransport {
+	return &Libp2pTransport{
+		Mailboxes: session.NewMailboxes(),
+		self:      self,
+		partyMgr:  partyMgr,
+		send:      send,
+	}
+}
+
+func (t *Libp2pTransport) Send(ctx context.Context, to string, msg *session.Message) (err error) {
+	ctx, span := startMessageSpan(ctx, "send", msg, trace.SpanKindProducer, attribute.String("tss.to", to))
+	defer func() { endSpan(span, err) }()
+
+	peerID, err := peer.Decode(to)
+	if err != nil {
+		return fmt.Errorf("invalid recipient %s: %w", to, err)
+	}
+
+	out, err := t.message(msg)
+	if err != nil {
+		return err
+	}
+	out.To = peerID
+
+	return t.send(ctx, out)
+}
+
+func (t *Libp2pTransport) Broadcast(ctx context.Context, msg *session.Message) (err error) {
+	ctx, span := startMessageSpan(ctx, "broadcast", msg, trace.SpanKindProducer)
+	defer func() { endSpan(span, err) }()
+
+	out, err := t.message(msg)
+	if err != nil {
+		return err
+	}
+
+	return t.send(ctx, out)
+}
+
+// message wraps a session message into a message of the party's operation type.
+func (t *Libp2pTransport) message(msg *session.Message) (*Message, error) {
+	party, err := t.partyMgr.GetParty(msg.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := SessionPayload{
+		Action:    SessionActionUpdate,
+		WireBytes: msg.WireBytes,
+		Broadcast: msg.Broadcast,
+	}
+	if msg.IsEcho() {
+		payload = SessionPayload{
+			Action:   SessionActionEcho,
+			EchoType: msg.EchoType,
+			Digests:  msg.Digests,
+		}
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	return &Message{
+		Type:    party.Operation.MessageType(),
+		PartyID: party.ID,
+		From:    t.self,
+		Payload: data,
+	}, nil
+}
+
+// deliver passes a received update or echo to the session it belongs to.
+func (t *Libp2pTransport) deliver(ctx context.Context, msg *Message, payload *SessionPayload) bool {
+	in := &session.Message{
+		SessionID: msg.PartyID,
+		From:      msg.From.String(),
+		WireBytes: payload.WireBytes,
+		Broadcast: payload.Broadcast,
+		EchoType:  payload.EchoType,
+		Digests:   payload.Digests,
+	}
+
+	// The receive span continues the trace of the sender's send span
+	ctx, span := startMessageSpan(extractTrace(ctx, msg), "receive", in, trace.SpanKindConsumer)
+	defer span.End()
+
+	return t.Deliver(ctx, in)
+}
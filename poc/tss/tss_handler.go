@@ -1,106 +1,559 @@
 package main
 
 import (
-	"crypto/ecdsa"
+	"context"
 	"crypto/elliptic"
-	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bnb-chain/tss-lib/v2/common"
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+	"github.com/keruch/thesis/poc/session"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type TSSHandler struct {
-	partyMgr *PartyManager
+const (
+	KeyGenTimeout  = 5 * time.Minute
+	SigningTimeout = 2 * time.Minute
+	// Keygen rounds exchange Paillier proofs that take a while to verify
+	KeyGenRoundTimeout  = 2 * time.Minute
+	SigningRoundTimeout = 30 * time.Second
+
+	PreParamsFile = "pre-params.json"
+
+	// Messages for sessions this node has not joined yet are kept for a while,
+	// since the party announcement and the first protocol messages travel over
+	// different streams and may arrive out of order.
+	PendingMessageTTL  = 30 * time.Second
+	MaxPendingParties  = 64
+	MaxPendingMessages = 256
+)
+
+type SessionAction string
+
+const (
+	SessionActionStart  SessionAction = "start"
+	SessionActionUpdate SessionAction = "update"
+	SessionActionAbort  SessionAction = "abort"
+	SessionActionEcho   SessionAction = "echo"
+)
+
+// SessionPayload is the payload of key generation and signing messages.
+type SessionPayload struct {
+	Action    SessionAction `json:"action"`
+	WireBytes []byte        `json:"wire_bytes,omitempty"`
+	Broadcast bool          `json:"broadcast,omitempty"`
+
+	// Echo messages only: the digests of the broadcasts of EchoType received from each member
+	EchoType string            `json:"echo_type,omitempty"`
+	Digests  map[string][]byte `json:"digests,omitempty"`
+}
+
+// SessionTimeouts configures the timeouts of the node's sessions by operation.
+type SessionTimeouts struct {
+	KeyGen  session.Timeouts
+	Signing session.Timeouts
+}
+
+func DefaultSessionTimeouts() SessionTimeouts {
+	return SessionTimeouts{
+		KeyGen:  session.Timeouts{Round: KeyGenRoundTimeout, Overall: KeyGenTimeout},
+		Signing: session.Timeouts{Round: SigningRoundTimeout, Overall: SigningTimeout},
+	}
+}
+
+func (t SessionTimeouts) For(op TSSOperation) session.Timeouts {
+	if op == TSSOperationSigning {
+		return t.Signing
+	}
+	return t.KeyGen
 }
 
-type KeyShare struct {
-	Index     int
-	Value     *big.Int
-	PublicKey *ecdsa.PublicKey
+// SessionSender delivers a session message to msg.To, or to all party members
+// when msg.To is empty.
+type SessionSender func(ctx context.Context, msg *Message) error
+
+var errSessionAborted = errors.New("session aborted by initiator")
+
+type pendingMessage struct {
+	msg      *Message
+	received time.Time
 }
 
-type SignatureShare struct {
-	Index int
-	Value *big.Int
+type TSSHandler struct {
+	self       peer.ID
+	partyMgr   *PartyManager
+	keyStore   *KeyStore
+	reputation *ReputationStore
+	preParams  *PreParamsPool
+	send       SessionSender
+	metrics    *Metrics
+	logger     *slog.Logger
+	timeouts   SessionTimeouts
+	mux        *session.Mux
+	sessions   map[string]*Session
+	pending    map[string][]pendingMessage
+	mu         sync.Mutex
+
+	transport *Libp2pTransport
+	// Transport the sessions run over; the libp2p transport unless faults are injected
+	sessionTransport session.Transport
 }
 
-func NewTSSHandler(partyMgr *PartyManager) *TSSHandler {
+func NewTSSHandler(self peer.ID, partyMgr *PartyManager, keyStore *KeyStore, reputation *ReputationStore, send SessionSender, metrics *Metrics, timeouts SessionTimeouts, workers, preParamsPoolSize int) *TSSHandler {
+	logger := nodeLogger(self)
+
+	preParams := NewPreParamsPool(preParamsPoolSize, logger)
+	if loaded, err := readPreParams(PreParamsFile); err != nil {
+		// Pre-parameters will be generated in the background or during keygen
+		logger.Warn("Pre-parameters not loaded", LogKeyError, err)
+	} else {
+		preParams.Put(loaded)
+	}
+
+	transport := NewLibp2pTransport(self, partyMgr, send)
+
 	return &TSSHandler{
-		partyMgr: partyMgr,
+		self:       self,
+		partyMgr:   partyMgr,
+		keyStore:   keyStore,
+		reputation: reputation,
+		preParams:  preParams,
+		send:       send,
+		metrics:    metrics,
+		logger:     logger,
+		timeouts:   timeouts,
+		mux:        session.NewMux(workers),
+		sessions:   make(map[string]*Session),
+		pending:    make(map[string][]pendingMessage),
+
+		transport:        transport,
+		sessionTransport: transport,
 	}
 }
 
-func (th *TSSHandler) GenerateKeyShares(partyID string) ([]KeyShare, error) {
-	party, err := th.partyMgr.GetParty(partyID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get party: %w", err)
+// InjectFaults makes the sessions of this node receive their messages
+// through a faulty network. Meant for tests and experiments only.
+func (th *TSSHandler) InjectFaults(cfg session.FaultConfig) {
+	th.sessionTransport = session.NewFaultyTransport(th.transport, th.self.String(), cfg)
+}
+
+// PreParamsAvailable returns the number of pre-parameter sets ready for keygen.
+func (th *TSSHandler) PreParamsAvailable() int {
+	return th.preParams.Available()
+}
+
+// Session is a single keygen or signing protocol run of this node.
+type Session struct {
+	*session.Session
+	Party *Party
+
+	span   trace.Span
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+	err    error
+}
+
+// TraceContext returns ctx carrying the session's span, so that messages
+// sent with it join the session's trace.
+func (s *Session) TraceContext(ctx context.Context) context.Context {
+	return trace.ContextWithSpan(ctx, s.span)
+}
+
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Cancel aborts the session; Wait then returns a RoundTimeoutError for the
+// round the session was stuck in.
+func (s *Session) Cancel() {
+	s.cancel(context.DeadlineExceeded)
+}
+
+func (s *Session) Wait(ctx context.Context) error {
+	select {
+	case <-s.done:
+		return s.err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	shares := make([]KeyShare, len(party.Members))
-	curve := elliptic.P256()
+func (s *Session) SaveData() *keygen.LocalPartySaveData {
+	<-s.done
+	return s.Session.SaveData()
+}
 
-	// Generate a random private key
-	privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+func (s *Session) Signature() *common.SignatureData {
+	<-s.done
+	return s.Session.Signature()
+}
+
+// StartSession creates the local protocol party for an announced party and
+// starts the first round.
+func (th *TSSHandler) StartSession(ctx context.Context, party *Party) (*Session, error) {
+	th.mu.Lock()
+	if _, exists := th.sessions[party.ID]; exists {
+		th.mu.Unlock()
+		return nil, fmt.Errorf("session already started for party: %s", party.ID)
+	}
+
+	s, err := th.newSession(party)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate private key: %w", err)
+		th.mu.Unlock()
+		return nil, err
 	}
+	s.Timeouts = th.timeouts.For(party.Operation)
+	s.Mux = th.mux
+
+	// The party is activated before the session is registered, so a party
+	// that can't be activated leaves neither a session nor lost messages behind
+	if err := th.partyMgr.UpdatePartyStatus(party.ID, PartyStatusActive); err != nil {
+		th.mu.Unlock()
+		return nil, fmt.Errorf("failed to activate party: %w", err)
+	}
+	th.sessions[party.ID] = s
+	pending := th.pending[party.ID]
+	delete(th.pending, party.ID)
+	th.mu.Unlock()
+
+	// Members continue the trace of the initiator's start message
+	ctx, s.span = tracer.Start(ctx, "tss."+party.Operation.String(), trace.WithAttributes(
+		attribute.String("tss.party_id", party.ID),
+		attribute.String("tss.initiator", party.Initiator.String()),
+		attribute.Int("tss.members", len(party.Members)),
+		attribute.Int("tss.threshold", party.Threshold),
+	))
+
+	sessionCtx, cancel := context.WithCancelCause(ctx)
+	s.cancel = cancel
+	th.metrics.SessionStarted(party.Operation)
+	th.logger.Info("Session started", LogKeyPartyID, party.ID, LogKeySessionID, party.ID, "operation", party.Operation.String())
 
-	// Generate key shares (this is a simplified version, not a real TSS implementation)
-	for i := range shares {
-		shares[i] = KeyShare{
-			Index:     i + 1,
-			Value:     new(big.Int).SetBytes(privateKey.D.Bytes()),
-			PublicKey: &privateKey.PublicKey,
+	go func() {
+		defer cancel(nil)
+		th.runSession(sessionCtx, s)
+	}()
+
+	for _, p := range pending {
+		if err := th.HandleMessage(ctx, p.msg); err != nil {
+			th.logger.Warn("Error replaying message", LogKeyPartyID, party.ID, LogKeyError, err)
 		}
 	}
 
-	return shares, nil
+	return s, nil
 }
 
-func (th *TSSHandler) CombinePublicKeys(shares []KeyShare) (*ecdsa.PublicKey, error) {
-	if len(shares) == 0 {
-		return nil, fmt.Errorf("no shares provided")
+// newSession builds the tss-lib party for this node. Must be called with the lock held.
+func (th *TSSHandler) newSession(party *Party) (*Session, error) {
+	ids := make(tss.UnSortedPartyIDs, 0, len(party.Members))
+	byPeer := make(map[peer.ID]*tss.PartyID, len(party.Members))
+	for _, member := range party.Members {
+		id := partyIDFromPeer(member)
+		ids = append(ids, id)
+		byPeer[member] = id
+	}
+	sortedIDs := tss.SortPartyIDs(ids)
+
+	selfID, isMember := byPeer[th.self]
+	if !isMember {
+		return nil, fmt.Errorf("node is not a member of party %s", party.ID)
+	}
+
+	s := &Session{
+		Party: party,
+		done:  make(chan struct{}),
 	}
 
-	// In a real TSS implementation, this would combine the public keys
-	// For simplicity, we'll just return the first public key
-	return shares[0].PublicKey, nil
+	switch party.Operation {
+	case TSSOperationKeyGen:
+		scheme, err := ParseScheme(string(party.Scheme))
+		if err != nil {
+			return nil, err
+		}
+		params := tss.NewParameters(schemeCurve(scheme), tss.NewPeerContext(sortedIDs), selfID, len(sortedIDs), party.Threshold)
+		if scheme == SchemeEdDSA {
+			s.Session = session.NewEdDSAKeygen(party.ID, params, th.sessionTransport)
+		} else {
+			preParams := th.preParams.Take()
+			if preParams == nil {
+				th.logger.Info("No pre-parameters available, keygen generates them first", LogKeyPartyID, party.ID)
+			}
+			s.Session = session.NewKeygen(party.ID, params, preParams, th.sessionTransport)
+		}
+
+	case TSSOperationSigning:
+		record, err := th.keyStore.Load(party.KeyID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key share: %w", err)
+		}
+		if party.Threshold != record.Threshold {
+			return nil, fmt.Errorf("signing threshold %d does not match key threshold %d", party.Threshold, record.Threshold)
+		}
+		for _, member := range party.Members {
+			if !record.IsHolder(member) {
+				return nil, fmt.Errorf("signer %s does not hold a share of key %s", member, party.KeyID)
+			}
+		}
+		if err := record.CheckMessage(party.Message); err != nil {
+			return nil, err
+		}
+		params := tss.NewParameters(schemeCurve(record.KeyScheme()), tss.NewPeerContext(sortedIDs), selfID, len(sortedIDs), party.Threshold)
+		switch {
+		case record.KeyScheme() == SchemeEdDSA && record.EdDSAShare != nil:
+			s.Session = session.NewEdDSASigning(party.ID, params, party.Message, *record.EdDSAShare, th.sessionTransport)
+		case record.KeyScheme() == SchemeECDSA && record.Share != nil && len(party.DerivationPath) > 0:
+			_, delta, err := record.DeriveKey(party.DerivationPath)
+			if err != nil {
+				return nil, err
+			}
+			msg := new(big.Int).SetBytes(party.Message)
+			if s.Session, err = session.NewDerivedSigning(party.ID, params, msg, *record.Share, delta, th.sessionTransport); err != nil {
+				return nil, err
+			}
+		case record.KeyScheme() == SchemeECDSA && record.Share != nil:
+			msg := new(big.Int).SetBytes(party.Message)
+			s.Session = session.NewSigning(party.ID, params, msg, *record.Share, th.sessionTransport)
+		default:
+			return nil, fmt.Errorf("key %s has no %s share", record.KeyID, record.KeyScheme())
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown operation: %d", party.Operation)
+	}
+
+	return s, nil
 }
 
-func (th *TSSHandler) SignMessage(partyID string, message []byte, keyShares []KeyShare) ([]SignatureShare, error) {
-	party, err := th.partyMgr.GetParty(partyID)
+func (th *TSSHandler) runSession(ctx context.Context, s *Session) {
+	err := s.Run(ctx)
+
+	var tssErr *tss.Error
+	if errors.As(err, &tssErr) {
+		for _, culprit := range tssErr.Culprits() {
+			if peerID, decodeErr := peer.Decode(culprit.Id); decodeErr == nil {
+				th.reputation.RecordBlame(peerID)
+			}
+		}
+		err = fmt.Errorf("party %s failed: %w", s.Party.ID, err)
+	}
+
+	if err == nil && s.Party.Operation == TSSOperationKeyGen {
+		record := &KeyShareRecord{
+			KeyID:     s.Party.ID,
+			Threshold: s.Party.Threshold,
+			Holders:   s.Party.Members,
+		}
+		if s.Party.Scheme == SchemeEdDSA {
+			record.Scheme = SchemeEdDSA
+			record.EdDSAShare = s.Session.EdDSASaveData()
+		} else {
+			record.Share = s.Session.SaveData()
+			record.ChainCode = s.Party.ChainCode
+		}
+		err = th.keyStore.Save(record)
+	}
+
+	th.mu.Lock()
+	delete(th.sessions, s.Party.ID)
+	th.mu.Unlock()
+
+	th.metrics.SessionFinished(s.Party.Operation, s.RoundDurations(), err)
+	if err != nil {
+		th.logger.Warn("Session failed", LogKeyPartyID, s.Party.ID, LogKeySessionID, s.ID, LogKeyRound, s.Round(), LogKeyError, err)
+	} else {
+		th.logger.Info("Session completed", LogKeyPartyID, s.Party.ID, LogKeySessionID, s.ID)
+	}
+
+	status := PartyStatusCompleted
 	if err != nil {
-		return nil, fmt.Errorf("failed to get party: %w", err)
+		status = PartyStatusFailed
+	}
+	if statusErr := th.partyMgr.UpdatePartyStatus(s.Party.ID, status); statusErr != nil {
+		th.logger.Warn("Error updating party status", LogKeyPartyID, s.Party.ID, LogKeyError, statusErr)
 	}
 
-	signatureShares := make([]SignatureShare, len(party.Members))
+	endSpan(s.span, err)
+
+	s.err = err
+	close(s.done)
+}
 
-	// Generate signature shares (this is a simplified version, not a real TSS implementation)
-	for i, share := range keyShares {
-		privateKey := &ecdsa.PrivateKey{
-			PublicKey: *share.PublicKey,
-			D:         share.Value,
+// SendControl sends a session control action to every other member of the
+// party, trying all members even if some of them cannot be reached.
+func (th *TSSHandler) SendControl(ctx context.Context, party *Party, action SessionAction) error {
+	payload, err := json.Marshal(SessionPayload{Action: action})
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	var errs []error
+	for _, member := range party.Members {
+		if member == th.self {
+			continue
+		}
+		msg := &Message{
+			Type:    party.Operation.MessageType(),
+			PartyID: party.ID,
+			From:    th.self,
+			To:      member,
+			Payload: payload,
 		}
+		if err := th.send(ctx, msg); err != nil {
+			errs = append(errs, fmt.Errorf("failed to send %s to %s: %w", action, member, err))
+		}
+	}
 
-		r, s, err := ecdsa.Sign(rand.Reader, privateKey, message)
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate signature share: %w", err)
+	return errors.Join(errs...)
+}
+
+// HandleMessage processes a key generation or signing message.
+func (th *TSSHandler) HandleMessage(ctx context.Context, msg *Message) error {
+	if msg.To != "" && msg.To != th.self {
+		return nil
+	}
+
+	var payload SessionPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return invalidMessage("failed to unmarshal session payload: %w", err)
+	}
+
+	party, err := th.partyMgr.GetParty(msg.PartyID)
+	if err != nil {
+		// Broadcasts of parties we are not a member of are expected on the shared topic
+		if msg.To == "" {
+			return nil
+		}
+		th.addPending(msg)
+		return nil
+	}
+
+	if !party.IsMember(msg.From) {
+		return invalidMessage("message from non-member %s for party %s", msg.From, party.ID)
+	}
+
+	th.mu.Lock()
+	s, started := th.sessions[party.ID]
+	if !started && payload.Action == SessionActionUpdate {
+		th.addPendingLocked(msg)
+	}
+	th.mu.Unlock()
+
+	switch payload.Action {
+	case SessionActionStart:
+		if msg.From != party.Initiator {
+			return invalidMessage("session start from non-initiator %s", msg.From)
+		}
+		if started {
+			return nil
+		}
+		_, err := th.StartSession(extractTrace(ctx, msg), party)
+		return err
+
+	case SessionActionAbort:
+		if msg.From != party.Initiator {
+			return invalidMessage("session abort from non-initiator %s", msg.From)
+		}
+		if started {
+			s.cancel(errSessionAborted)
 		}
+		return nil
 
-		signatureShares[i] = SignatureShare{
-			Index: share.Index,
-			Value: new(big.Int).Add(r, s),
+	case SessionActionUpdate, SessionActionEcho:
+		// Echoes arriving after the session finished are no longer needed
+		if !started {
+			return nil
+		}
+		if !th.transport.deliver(ctx, msg, &payload) {
+			return fmt.Errorf("session %s is no longer running", party.ID)
+		}
+		return nil
+
+	default:
+		return invalidMessage("unknown session action: %s", payload.Action)
+	}
+}
+
+// FlushPending replays messages that arrived before the party was known.
+func (th *TSSHandler) FlushPending(ctx context.Context, partyID string) {
+	th.mu.Lock()
+	pending := th.pending[partyID]
+	delete(th.pending, partyID)
+	th.mu.Unlock()
+
+	for _, p := range pending {
+		if err := th.HandleMessage(ctx, p.msg); err != nil {
+			th.logger.Warn("Error replaying message", LogKeyPartyID, partyID, LogKeyError, err)
 		}
 	}
+}
 
-	return signatureShares, nil
+func (th *TSSHandler) addPending(msg *Message) {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	th.addPendingLocked(msg)
 }
 
-func (th *TSSHandler) CombineSignatures(shares []SignatureShare) ([]byte, error) {
-	if len(shares) == 0 {
-		return nil, fmt.Errorf("no signature shares provided")
+func (th *TSSHandler) addPendingLocked(msg *Message) {
+	now := time.Now()
+	for partyID, msgs := range th.pending {
+		if now.Sub(msgs[len(msgs)-1].received) > PendingMessageTTL {
+			delete(th.pending, partyID)
+		}
+	}
+
+	msgs, exists := th.pending[msg.PartyID]
+	if !exists && len(th.pending) >= MaxPendingParties {
+		th.logger.Warn("Dropping message for unknown party: too many pending parties", LogKeyPartyID, msg.PartyID, LogKeyPeer, msg.From)
+		return
+	}
+	if len(msgs) >= MaxPendingMessages {
+		th.logger.Warn("Dropping message for unknown party: too many pending messages", LogKeyPartyID, msg.PartyID, LogKeyPeer, msg.From)
+		return
+	}
+	th.pending[msg.PartyID] = append(msgs, pendingMessage{msg: msg, received: now})
+}
+
+// schemeCurve returns the curve tss-lib runs the protocols of scheme on.
+func schemeCurve(scheme Scheme) elliptic.Curve {
+	if scheme == SchemeEdDSA {
+		return tss.Edwards()
+	}
+	return tss.S256()
+}
+
+// partyIDFromPeer maps a libp2p peer to its tss-lib party identifier. The key
+// must be stable across sessions since key shares are bound to it.
+func partyIDFromPeer(peerID peer.ID) *tss.PartyID {
+	hash := sha256.Sum256([]byte(peerID))
+	key := new(big.Int).SetBytes(hash[:])
+	key.Mod(key, tss.S256().Params().N)
+	return tss.NewPartyID(peerID.String(), peerID.ShortString(), key)
+}
+
+func readPreParams(path string) (*keygen.LocalPreParams, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pre-params: %w", err)
+	}
+
+	var preParams keygen.LocalPreParams
+	if err := json.Unmarshal(data, &preParams); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal pre-params: %w", err)
+	}
+	if !preParams.ValidateWithProof() {
+		return nil, fmt.Errorf("invalid pre-params in %s", path)
 	}
 
-	// In a real TSS implementation, this would combine the signature shares
-	// For simplicity, we'll just return the first signature share
-	return shares[0].Value.Bytes(), nil
+	return &preParams, nil
 }
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestStartSessionOfUnknownPartyLeavesNoSession(t *testing.T) {
+	net := newTestNetwork(t, 1)
+	node := net.nodes[0]
+	th := node.tssHandler
+
+	// The party manager does not know the party, so it can't be activated
+	party := &Party{
+		ID:        "unknown-party",
+		Initiator: node.host.ID(),
+		Members:   append([]peer.ID{node.host.ID()}, randPeerIDs(t, 1)...),
+		Threshold: 1,
+		Operation: TSSOperationKeyGen,
+		Scheme:    SchemeEdDSA,
+	}
+	th.addPending(&Message{PartyID: party.ID, From: party.Members[1], To: node.host.ID()})
+
+	for range 2 {
+		if _, err := th.StartSession(context.Background(), party); err == nil || !strings.Contains(err.Error(), "failed to activate party") {
+			t.Fatalf("expected the party activation to fail, got %v", err)
+		}
+	}
+
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	if _, exists := th.sessions[party.ID]; exists {
+		t.Fatal("failed session was left registered")
+	}
+	if got := len(th.pending[party.ID]); got != 1 {
+		t.Fatalf("%d pending messages kept for the party, want 1", got)
+	}
+}